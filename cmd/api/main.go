@@ -2,11 +2,15 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/omelaymy/users/config"
 	_ "github.com/omelaymy/users/docs"
 	"github.com/omelaymy/users/internal/api/http/delivery"
+	"github.com/omelaymy/users/pkg/db/inmemory"
 	"github.com/omelaymy/users/pkg/di"
 
 	"github.com/samber/do"
@@ -17,6 +21,14 @@ import (
 // @version 1.0
 // @securityDefinitions.basic BasicAuth
 // @name Authorization
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Type "Bearer" followed by a space and an access token.
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+// @description Type "ApiKey" followed by a space and an API key.
 // @host localhost:8888
 // @BasePath /api
 func main() {
@@ -25,11 +37,25 @@ func main() {
 	do.Provide(i, di.NewFlags)
 	do.Provide(i, di.NewConfig)
 	do.Provide(i, di.NewLogger)
+	do.Provide(i, di.NewHasher)
 	do.Provide(i, di.NewInMemoryDatabase)
 	do.Provide(i, di.NewAuth)
 	do.Provide(i, di.NewAuthRepository)
+	do.Provide(i, di.NewCipher)
+	do.Provide(i, di.NewSessionRepository)
+	do.Provide(i, di.NewLockoutRepository)
+	do.Provide(i, di.NewTokenManager)
+	do.Provide(i, di.NewPolicy)
 	do.Provide(i, di.NewUsers)
+	do.Provide(i, di.NewBreachedPasswords)
 	do.Provide(i, di.NewUsersRepository)
+	do.Provide(i, di.NewPasswordReset)
+	do.Provide(i, di.NewResetTokenRepository)
+	do.Provide(i, di.NewEmailVerification)
+	do.Provide(i, di.NewInvitations)
+	do.Provide(i, di.NewInviteRepository)
+	do.Provide(i, di.NewAccountStatus)
+	do.Provide(i, di.NewMailer)
 	do.Provide(i, di.NewRoutes)
 	do.Provide(i, di.NewHandlers)
 	do.Provide(i, di.NewMWManager)
@@ -43,5 +69,20 @@ func main() {
 	routes.RegisterRoutes()
 
 	cfg := do.MustInvoke[*config.Config](i)
-	log.Fatal(app.Listen(cfg.Server.Address))
+	go func() {
+		if err := app.Listen(cfg.Server.Address); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	if err := app.Shutdown(); err != nil {
+		log.Print(err)
+	}
+	if err := do.MustInvoke[*inmemory.InMemoryDatabase](i).Close(); err != nil {
+		log.Fatal(err)
+	}
 }
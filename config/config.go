@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -18,6 +19,142 @@ type Config struct {
 		ServiceName string `json:"serviceName"`
 	}
 
+	Database struct {
+		Shards int `json:"shards"`
+
+		WAL struct {
+			Path         string        `json:"path"`
+			SyncPolicy   string        `json:"syncPolicy"`
+			BatchSize    int           `json:"batchSize"`
+			SyncInterval time.Duration `json:"syncInterval"`
+		}
+
+		Snapshot struct {
+			Dir       string        `json:"dir"`
+			Interval  time.Duration `json:"interval"`
+			Threshold int           `json:"threshold"`
+			Retain    int           `json:"retain"`
+		}
+
+		Normalization struct {
+			CaseFold bool `json:"caseFold"`
+			NFKC     bool `json:"nfkc"`
+		}
+	}
+
+	Auth struct {
+		// PolicyFile is relative to the directory of the config file.
+		PolicyFile string `json:"policyFile"`
+
+		Password struct {
+			// Algorithm new password hashes are made with, argon2id or
+			// bcrypt. Hashes with another algorithm or other parameters
+			// are replaced when their users sign in.
+			Algorithm string `json:"algorithm"`
+
+			Argon2id struct {
+				Memory      uint32 `json:"memory"`
+				Iterations  uint32 `json:"iterations"`
+				Parallelism uint8  `json:"parallelism"`
+				SaltLength  uint32 `json:"saltLength"`
+				KeyLength   uint32 `json:"keyLength"`
+			}
+
+			Bcrypt struct {
+				Cost int `json:"cost"`
+			}
+
+			// Policy new passwords must meet; zero values turn its rules
+			// off.
+			Policy struct {
+				MinLength int `json:"minLength"`
+				// CharacterClasses is how many of lowercase letters,
+				// uppercase letters, digits and symbols a password mixes.
+				CharacterClasses   int  `json:"characterClasses"`
+				RejectPersonalInfo bool `json:"rejectPersonalInfo"`
+				// BreachedFile lists SHA-1 hashes of breached passwords,
+				// relative to the directory of the config file.
+				BreachedFile string `json:"breachedFile"`
+			}
+		}
+
+		EmailVerification struct {
+			// Required keeps users from signing in with a password until
+			// they have verified their email.
+			Required bool          `json:"required"`
+			TokenTTL time.Duration `json:"tokenTTL"`
+			// URL of the verification endpoint or a page that calls it;
+			// mails add the token to it as the token query parameter.
+			URL string `json:"url"`
+		}
+
+		Invitations struct {
+			TokenTTL time.Duration `json:"tokenTTL"`
+			// URL of the page invited users pick their username and
+			// password on; mails add the token to it as the token query
+			// parameter.
+			URL string `json:"url"`
+		}
+		PasswordReset struct {
+			TokenTTL time.Duration `json:"tokenTTL"`
+			// URL of the page users set their new password on; mails
+			// add the token to it as the token query parameter.
+			URL string `json:"url"`
+		}
+
+		Lockout struct {
+			Threshold          int           `json:"threshold"`
+			IPThreshold        int           `json:"ipThreshold"`
+			BaseDelay          time.Duration `json:"baseDelay"`
+			MaxDelay           time.Duration `json:"maxDelay"`
+			Window             time.Duration `json:"window"`
+			PermanentThreshold int           `json:"permanentThreshold"`
+		}
+
+		TOTP struct {
+			// Issuer names the service in authenticator apps.
+			Issuer string `json:"issuer"`
+			// Skew is the number of 30 second periods codes may be early
+			// or late.
+			Skew          int `json:"skew"`
+			RecoveryCodes int `json:"recoveryCodes"`
+			// EncryptionKey encrypts the secrets of second factors, 32
+			// bytes in base64. Changing it makes every second factor
+			// unusable.
+			EncryptionKey string `json:"encryptionKey"`
+		}
+
+		JWT struct {
+			Issuer     string        `json:"issuer"`
+			Audience   string        `json:"audience"`
+			AccessTTL  time.Duration `json:"accessTTL"`
+			RefreshTTL time.Duration `json:"refreshTTL"`
+			SigningKey string        `json:"signingKey"`
+			Keys       []struct {
+				ID             string `json:"id"`
+				Algorithm      string `json:"algorithm"`
+				Secret         string `json:"secret"`
+				PrivateKeyFile string `json:"privateKeyFile"`
+				PublicKeyFile  string `json:"publicKeyFile"`
+			}
+		}
+	}
+
+	Mail struct {
+		// Driver is smtp, or file to write mails to File, or to stdout
+		// when File is empty.
+		Driver string `json:"driver"`
+		From   string `json:"from"`
+		File   string `json:"file"`
+
+		SMTP struct {
+			Host     string `json:"host"`
+			Port     int    `json:"port"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+	}
+
 	Server struct {
 		Address string `json:"address"`
 	}
@@ -1,29 +1,295 @@
 package api
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type ErrorResponse struct {
 	Message string `json:"message"`
+	// Violations lists the rules the request breaks, when it is invalid.
+	Violations []ViolationResponse `json:"violations,omitempty"`
+}
+
+type ViolationResponse struct {
+	Field   string `json:"field" example:"password"`
+	Rule    string `json:"rule" example:"password_min_length"`
+	Param   string `json:"param,omitempty" example:"12"`
+	Message string `json:"message" example:"password must be at least 12 characters long"`
 }
 
 type UserRequest struct {
 	Email    string `json:"email" validate:"required"`
 	Username string `json:"username" validate:"required"`
-	Admin    bool   `json:"admin"`
-	Password string `json:"password,omitempty" validate:"required"`
+	// Roles can only be changed by callers with the roles:write permission.
+	Roles []string `json:"roles" validate:"dive,required"`
+	// ServiceAccount creates a user without a password that signs in with
+	// API keys only. It is ignored on updates.
+	ServiceAccount bool   `json:"serviceAccount"`
+	Password       string `json:"password,omitempty" validate:"required_unless=ServiceAccount true"`
+}
+
+// UserPatchDocument is the JSON document PATCH requests apply their patch to.
+// It holds no password unless the patch adds one.
+type UserPatchDocument struct {
+	Email    string   `json:"email" validate:"required"`
+	Username string   `json:"username" validate:"required"`
+	Roles    []string `json:"roles" validate:"dive,required"`
+	Password string   `json:"password,omitempty"`
 }
 
 type UserResponse struct {
-	Id       uuid.UUID `json:"id"`
-	Email    string    `json:"email"`
-	Username string    `json:"username"`
-	Admin    bool      `json:"admin"`
+	Id             uuid.UUID  `json:"id"`
+	Email          string     `json:"email"`
+	Username       string     `json:"username"`
+	Roles          []string   `json:"roles"`
+	ServiceAccount bool       `json:"serviceAccount"`
+	EmailVerified  bool       `json:"emailVerified"`
+	Pending        bool       `json:"pending"`
+	Status         string     `json:"status"`
+	StatusReason   string     `json:"statusReason,omitempty"`
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+	Version        uint64     `json:"version"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type UsersQueryRequest struct {
+	// Admin is kept from before roles: true stands for role=admin and false
+	// keeps users without the admin role.
+	Admin         *bool  `query:"admin"`
+	Role          string `query:"role"`
+	Username      string `query:"username"`
+	Email         string `query:"email"`
+	CreatedAfter  string `query:"createdAfter" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	EmailVerified string `query:"emailVerified" validate:"omitempty,oneof=true false"`
+	Sort          string `query:"sort" validate:"omitempty,oneof=username email createdAt"`
+	Cursor        string `query:"cursor"`
+	Limit         int    `query:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+type UsersPageResponse struct {
+	Users      []UserResponse `json:"users"`
+	NextCursor string         `json:"nextCursor,omitempty"`
 }
 
 type UserIdResponse struct {
 	Id uuid.UUID `json:"id"`
 }
 
+type UserIdsResponse struct {
+	Ids []uuid.UUID `json:"ids"`
+}
+
+type TokenRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	// Code is a code of the authenticator app or a recovery code, required
+	// of users with a second factor.
+	Code string `json:"code"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type InviteRequest struct {
+	Email string   `json:"email" validate:"required,email"`
+	Roles []string `json:"roles" validate:"dive,required"`
+}
+
+// SuspendRequest suspends a user until Until, or until the user is
+// reactivated if it is empty.
+type SuspendRequest struct {
+	Reason string `json:"reason" validate:"required"`
+	Until  string `json:"until" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+type DeactivateRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+type AcceptInviteRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type InvitationResponse struct {
+	UserId    uuid.UUID `json:"userId"`
+	Email     string    `json:"email"`
+	Roles     []string  `json:"roles"`
+	CreatedAt time.Time `json:"createdAt"`
+	// SentAt and ExpiresAt are absent when the invite has to be resent.
+	SentAt    *time.Time `json:"sentAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Expired   bool       `json:"expired"`
+}
+
+type InvitationsResponse struct {
+	Invitations []InvitationResponse `json:"invitations"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int64 `json:"expiresIn"`
+	// TOTPEnrollment marks an access token that can only set up the second
+	// factor the roles of the user require; it comes without a refresh token.
+	TOTPEnrollment bool `json:"totpEnrollment,omitempty"`
+}
+
+type SessionResponse struct {
+	Id         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current marks the session of the token the request was made with.
+	Current bool `json:"current"`
+}
+
+type SessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+type APIKeyRequest struct {
+	Name string `json:"name" validate:"required"`
+	// Scopes are the permissions the key may use.
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,required"`
+	// ExpiresAt is an RFC 3339 time; keys without it never expire.
+	ExpiresAt string `json:"expiresAt,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+type APIKeyResponse struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreatedAPIKeyResponse holds the only copy of the key the service hands out.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+type APIKeysResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
+}
+
+type PasswordRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+}
+
+// ProfileRequest is the part of a user that users edit themselves.
+type ProfileRequest struct {
+	Email    string `json:"email" validate:"required"`
+	Username string `json:"username" validate:"required"`
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name" validate:"required,max=64"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" validate:"dive,required"`
+	RequireTOTP bool     `json:"requireTotp"`
+}
+
+type RoleResponse struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	RequireTOTP bool      `json:"requireTotp"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type RolesResponse struct {
+	Roles []RoleResponse `json:"roles"`
+}
+
+type ExplainRequest struct {
+	Action string `query:"action" validate:"required,oneof=users:read users:write users:delete users:reset-password"`
+	Target string `query:"target" validate:"omitempty,uuid"`
+	// Fields is a comma-separated list of the fields an update changes.
+	Fields string `query:"fields"`
+}
+
+type ExplainResponse struct {
+	Allowed bool   `json:"allowed"`
+	Action  string `json:"action"`
+	// Rule is empty when no rule matched and the permissions of the caller
+	// decided.
+	Rule   string              `json:"rule,omitempty"`
+	Reason string              `json:"reason"`
+	Rules  []RuleTraceResponse `json:"rules"`
+}
+
+type RuleTraceResponse struct {
+	Name       string                   `json:"name"`
+	Effect     string                   `json:"effect"`
+	Applies    bool                     `json:"applies"`
+	Matched    bool                     `json:"matched"`
+	Conditions []ConditionTraceResponse `json:"conditions,omitempty"`
+}
+
+type ConditionTraceResponse struct {
+	Condition string `json:"condition"`
+	Matched   bool   `json:"matched"`
+}
+
+type LockoutResponse struct {
+	// Kind is login for usernames and emails, or ip for client IPs.
+	Kind          string     `json:"kind"`
+	Name          string     `json:"name"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`
+	Permanent     bool       `json:"permanent"`
+}
+
+type LockoutsResponse struct {
+	Lockouts []LockoutResponse `json:"lockouts"`
+}
+
+type TOTPStatusResponse struct {
+	Enabled  bool `json:"enabled"`
+	Required bool `json:"required"`
+	// RecoveryCodes is the number of recovery codes left.
+	RecoveryCodes int `json:"recoveryCodes"`
+}
+
+type TOTPSetupResponse struct {
+	// Secret is the base32 secret to type into an authenticator app.
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI of the secret, to show as a QR code.
+	URI string `json:"uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
 type SuccessResponse struct {
 	Success bool `json:"success"`
 }
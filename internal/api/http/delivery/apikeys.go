@@ -0,0 +1,191 @@
+package delivery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// @Summary Create API Key
+// @Description Create an API key for a user; the key is only returned in this response (requires the api-keys:write permission and every scope of the key)
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param key body api.APIKeyRequest true "API key to create"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.CreatedAPIKeyResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/api-keys [post]
+func (h *Handlers) CreateAPIKeyHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		var request api.APIKeyRequest
+		if err = c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err = h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		// A key must not grant more than its creator has, or creating keys
+		// for admins would make anyone allowed to create keys an admin.
+		identity := api.Identity(c)
+		for _, scope := range request.Scopes {
+			if identity == nil || !identity.Can(scope) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenScopeError)
+			}
+		}
+
+		var expiresAt time.Time
+		if request.ExpiresAt != "" {
+			expiresAt, _ = time.Parse(time.RFC3339, request.ExpiresAt)
+		}
+
+		key, secret, err := h.authUsecase.CreateAPIKey(id, request.Name, request.Scopes, expiresAt)
+		if err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, auth.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			if errors.Is(err, auth.InvalidScopeError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.CreatedAPIKeyResponse{
+			APIKeyResponse: apiKeyResponse(key),
+			Key:            secret,
+		})
+	}
+}
+
+// @Summary Get API Keys
+// @Description List the API keys of a user without their secrets (requires the users:read permission)
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.APIKeysResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/api-keys [get]
+func (h *Handlers) GetAPIKeysHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		keys, err := h.authUsecase.GetAPIKeys(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		response := api.APIKeysResponse{
+			Keys: make([]api.APIKeyResponse, len(keys)),
+		}
+		for i, key := range keys {
+			response.Keys[i] = apiKeyResponse(key)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// @Summary Revoke API Key
+// @Description Delete an API key of a user (requires the api-keys:write permission)
+// @Tags API Keys
+// @Produce json
+// @Param id path string true "User ID"
+// @Param keyId path string true "API key ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/api-keys/{keyId} [delete]
+func (h *Handlers) RevokeAPIKeyHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+		keyId, err := uuid.Parse(c.Params("keyId"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.authUsecase.RevokeAPIKey(id, keyId); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, auth.APIKeyNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+func apiKeyResponse(key *auth.APIKey) api.APIKeyResponse {
+	response := api.APIKeyResponse{
+		Id:        key.Id,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+	if !key.ExpiresAt.IsZero() {
+		response.ExpiresAt = &key.ExpiresAt
+	}
+	if !key.LastUsedAt.IsZero() {
+		response.LastUsedAt = &key.LastUsedAt
+	}
+
+	return response
+}
@@ -0,0 +1,412 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// @Summary Issue Tokens
+// @Description Exchange a username or email and password, and the code of users with a second factor, for an access and a refresh token; users whose roles require a second factor they have not set up get an access token to set it up with only; repeated failures lock out the login and the client IP for a while
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param credentials body api.TokenRequest true "User credentials"
+// @Success 200 {object} api.TokenResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Header 429 {string} Retry-After "Seconds until the lockout ends"
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/token [post]
+func (h *Handlers) IssueTokensHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.TokenRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		tokens, err := h.authUsecase.IssueTokens(request.Username, request.Password, request.Code, c.IP())
+		if err != nil {
+			if errors.Is(err, auth.TOTPRequiredError) {
+				return fiber.NewError(fiber.StatusUnauthorized, apiErrors.TOTPRequiredError)
+			}
+			if errors.Is(err, auth.EmailNotVerifiedError) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.EmailNotVerifiedError)
+			}
+			if errors.Is(err, auth.UserSuspendedError) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.UserSuspendedError)
+			}
+			if errors.Is(err, auth.UserDeactivatedError) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.UserDeactivatedError)
+			}
+			if errors.Is(err, auth.InvalidCredentialsError) {
+				return fiber.NewError(fiber.StatusUnauthorized, apiErrors.InvalidCredentialsError)
+			}
+			var locked *auth.LockedError
+			if errors.As(err, &locked) {
+				api.SetRetryAfter(c, locked)
+				return fiber.NewError(fiber.StatusTooManyRequests, apiErrors.TooManySignInsError)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(tokenResponse(tokens))
+	}
+}
+
+// @Summary Refresh Tokens
+// @Description Exchange a refresh token for a new access and refresh token
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body api.RefreshRequest true "Refresh token"
+// @Success 200 {object} api.TokenResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/refresh [post]
+func (h *Handlers) RefreshTokensHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.RefreshRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		tokens, err := h.authUsecase.RefreshTokens(request.RefreshToken)
+		if err != nil {
+			if errors.Is(err, auth.InvalidTokenError) {
+				return fiber.NewError(fiber.StatusUnauthorized, apiErrors.InvalidTokenError)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(tokenResponse(tokens))
+	}
+}
+
+// @Summary Request Password Reset
+// @Description Mail a single-use token to set a new password with to the user with the given email; the answer is the same whether or not the email has a user
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body api.PasswordResetRequest true "Email of the user"
+// @Success 202 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/password-reset [post]
+func (h *Handlers) RequestPasswordResetHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.PasswordResetRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		if err := h.passwordResetUsecase.RequestPasswordReset(request.Email); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Confirm Password Reset
+// @Description Set a new password with a password reset token and sign the user out everywhere; the token is used up unless the password breaks the password policy
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body api.PasswordResetConfirmRequest true "Reset token and new password"
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/password-reset/confirm [post]
+func (h *Handlers) ConfirmPasswordResetHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.PasswordResetConfirmRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		err := h.passwordResetUsecase.ConfirmPasswordReset(request.Token, request.Password)
+		if err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+			if errors.Is(err, users.InvalidResetTokenError) {
+				return fiber.NewError(fiber.StatusBadRequest, apiErrors.InvalidResetTokenError)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Verify Email
+// @Description Mark the email of a user verified with the token of the link mailed to it
+// @Tags Auth
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/verify-email [get]
+func (h *Handlers) VerifyEmailHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := h.emailVerificationUsecase.VerifyEmail(c.Query("token")); err != nil {
+			if errors.Is(err, users.InvalidVerificationTokenError) {
+				return fiber.NewError(fiber.StatusBadRequest, apiErrors.InvalidVerificationTokenError)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Send Email Verification
+// @Description Mail a user a new link that verifies the email of the user (requires the users:write permission or being the user)
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 202 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/verify-email [post]
+func (h *Handlers) SendVerificationHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.emailVerificationUsecase.SendVerification(id); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			if errors.Is(err, users.EmailAlreadyVerifiedError) {
+				code = fiber.StatusConflict
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+func tokenResponse(tokens *auth.Tokens) api.TokenResponse {
+	return api.TokenResponse{
+		AccessToken:    tokens.AccessToken,
+		RefreshToken:   tokens.RefreshToken,
+		TokenType:      "Bearer",
+		ExpiresIn:      int64(tokens.ExpiresIn.Seconds()),
+		TOTPEnrollment: tokens.TOTPEnrollment,
+	}
+}
+
+// @Summary Get Sessions
+// @Description List the sessions a user is signed in with (requires the users:read permission or being the user)
+// @Tags Auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SessionsResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/sessions [get]
+func (h *Handlers) GetSessionsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		sessions, err := h.authUsecase.GetUserSessions(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		var current uuid.UUID
+		if identity := api.Identity(c); identity != nil {
+			current = identity.SessionId
+		}
+
+		response := api.SessionsResponse{
+			Sessions: make([]api.SessionResponse, len(sessions)),
+		}
+		for i, session := range sessions {
+			response.Sessions[i] = api.SessionResponse{
+				Id:         session.Id,
+				CreatedAt:  session.CreatedAt,
+				LastUsedAt: session.LastUsedAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.Id == current,
+			}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// @Summary Revoke Sessions
+// @Description Sign a user out everywhere; access tokens already issued stay valid until they expire (requires the users:write permission or being the user)
+// @Tags Auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/sessions [delete]
+func (h *Handlers) RevokeSessionsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.authUsecase.RevokeUserSessions(id); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Revoke Session
+// @Description Sign a user out of one session (requires the users:write permission or being the user)
+// @Tags Auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param sessionId path string true "Session ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/sessions/{sessionId} [delete]
+func (h *Handlers) RevokeSessionHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+		sessionId, err := uuid.Parse(c.Params("sessionId"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.authUsecase.RevokeSession(id, sessionId); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, auth.SessionNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
@@ -1,9 +1,15 @@
 package delivery
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"mime"
+	"strconv"
 	"strings"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
@@ -11,35 +17,58 @@ import (
 
 	"github.com/omelaymy/users/internal/api"
 	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
 	"github.com/omelaymy/users/internal/users"
 )
 
 type Handlers struct {
-	usersUsecase     users.Usecase
-	validate         *validator.Validate
-	errorsTranslator ut.Translator
+	usersUsecase             users.Usecase
+	passwordResetUsecase     users.PasswordResetUsecase
+	emailVerificationUsecase users.EmailVerificationUsecase
+	invitationUsecase        users.InvitationUsecase
+	accountStatusUsecase     users.AccountStatusUsecase
+	authUsecase              auth.Usecase
+	policy                   auth.Policy
+	validate                 *validator.Validate
+	errorsTranslator         ut.Translator
 }
 
 func NewHandlers(
 	usersUsecase users.Usecase,
+	passwordResetUsecase users.PasswordResetUsecase,
+	emailVerificationUsecase users.EmailVerificationUsecase,
+	invitationUsecase users.InvitationUsecase,
+	accountStatusUsecase users.AccountStatusUsecase,
+	authUsecase auth.Usecase,
+	policy auth.Policy,
 	validate *validator.Validate,
 	errorsTranslator ut.Translator,
 
 ) *Handlers {
 	return &Handlers{
-		usersUsecase:     usersUsecase,
-		validate:         validate,
-		errorsTranslator: errorsTranslator,
+		usersUsecase:             usersUsecase,
+		passwordResetUsecase:     passwordResetUsecase,
+		emailVerificationUsecase: emailVerificationUsecase,
+		invitationUsecase:        invitationUsecase,
+		accountStatusUsecase:     accountStatusUsecase,
+		authUsecase:              authUsecase,
+		policy:                   policy,
+		validate:                 validate,
+		errorsTranslator:         errorsTranslator,
 	}
 }
 
 // @Summary Get User Information
-// @Description Get information about a specific user
+// @Description Get information about a specific user (requires the users:read permission, or the access policy allowing it)
 // @Tags Users
 // @Produce json
 // @Param id path string true "User ID"
 // @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} api.UserResponse
+// @Header 200 {string} ETag "User version"
+// @Failure 403 {object} api.ErrorResponse
 // @Failure 404 {object} api.ErrorResponse
 // @Failure 500 {object} api.ErrorResponse
 // @Router /v1/users/{id} [get]
@@ -53,33 +82,36 @@ func (h *Handlers) GetUserHandler() fiber.Handler {
 			)
 		}
 
-		user, err := h.usersUsecase.GetUser(id)
+		user, err := h.findUser(id)
 		if err != nil {
-			code := fiber.StatusInternalServerError
-			if errors.Is(err, users.UserNotFoundError) {
-				code = fiber.StatusNotFound
-			}
-			return fiber.NewError(code, err.Error())
+			return err
+		}
+		if err = h.authorize(c, auth.PermissionUsersRead, user); err != nil {
+			return err
+		}
+		if user == nil {
+			return fiber.NewError(fiber.StatusNotFound, users.UserNotFoundError.Error())
 		}
 
-		return c.Status(fiber.StatusOK).JSON(api.UserResponse{
-			Id:       user.Id,
-			Email:    user.Email,
-			Username: user.Username,
-			Admin:    user.Admin,
-		})
+		c.Set(fiber.HeaderETag, formatETag(user.Version))
+		return c.Status(fiber.StatusOK).JSON(userResponse(user))
 	}
 }
 
 // @Summary Update User
-// @Description Update a user with the provided information (requires admin access)
+// @Description Update a user with the provided information (requires the users:write permission or the access policy allowing it, and roles:write to change roles)
 // @Tags Users
 // @Param id path string true "User ID"
 // @Param user body api.UserRequest true "User object to update"
+// @Param If-Match header string false "ETag of the user version being updated"
 // @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} api.SuccessResponse
 // @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
 // @Failure 404 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse
 // @Failure 500 {object} api.ErrorResponse
 // @Router /v1/users/{id} [put]
 func (h *Handlers) UpdateUserHandler() fiber.Handler {
@@ -92,6 +124,14 @@ func (h *Handlers) UpdateUserHandler() fiber.Handler {
 			)
 		}
 
+		version, err := parseIfMatch(c.Get(fiber.HeaderIfMatch))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidIfMatch,
+			)
+		}
+
 		var user api.UserRequest
 		if err = c.BodyParser(&user); err != nil {
 			return fiber.NewError(
@@ -108,19 +148,212 @@ func (h *Handlers) UpdateUserHandler() fiber.Handler {
 			)
 		}
 
+		current, err := h.findUser(id)
+		if err != nil {
+			return err
+		}
+		var fields []string
+		if current != nil {
+			fields = changedFields(current, user.Email, user.Username, user.Password, user.Roles)
+		}
+		if err = h.authorize(c, auth.PermissionUsersWrite, current, fields...); err != nil {
+			return err
+		}
+		if current == nil {
+			return fiber.NewError(fiber.StatusNotFound, users.UserNotFoundError.Error())
+		}
+		if !canChangeRoles(c) && !sameRoles(current.Roles, user.Roles) {
+			return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenRolesError)
+		}
+		// The policy decided on this version; pinning it keeps a concurrent
+		// change, like one of the roles, from being reverted by this update.
+		if version == 0 {
+			version = current.Version
+		}
+
 		err = h.usersUsecase.UpdateUser(&users.User{
 			Id:       id,
 			Email:    user.Email,
 			Username: user.Username,
 			Password: user.Password,
-			Admin:    user.Admin,
+			Roles:    user.Roles,
+			Version:  version,
 		})
 		if err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
 			code := fiber.StatusInternalServerError
 			if errors.Is(err, users.UserNotFoundError) {
 				code = fiber.StatusNotFound
 			}
-			if errors.Is(err, users.UserAlreadyExistsError) {
+			if errors.Is(err, users.UserVersionMismatchError) {
+				code = fiber.StatusPreconditionFailed
+			}
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) ||
+				errors.Is(err, users.UnknownRoleError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// @Summary Patch User
+// @Description Partially update a user with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) applied to api.UserPatchDocument; the password only changes if the patch adds one (requires the users:write permission or the access policy allowing it, and roles:write to change roles)
+// @Tags Users
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param patch body object true "Merge patch or JSON patch document"
+// @Param If-Match header string false "ETag of the user version being updated"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse
+// @Failure 415 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id} [patch]
+func (h *Handlers) PatchUserHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		version, err := parseIfMatch(c.Get(fiber.HeaderIfMatch))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidIfMatch,
+			)
+		}
+
+		var apply func(document []byte) ([]byte, error)
+		mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		switch mediaType {
+		case mergePatchType:
+			patch := c.Body()
+			apply = func(document []byte) ([]byte, error) {
+				return jsonpatch.MergePatch(document, patch)
+			}
+		case jsonPatchType:
+			patch, err := jsonpatch.DecodePatch(c.Body())
+			if err != nil {
+				return fiber.NewError(
+					fiber.StatusBadRequest,
+					apiErrors.InvalidPatchError,
+					err.Error(),
+				)
+			}
+			apply = patch.Apply
+		default:
+			return fiber.NewError(
+				fiber.StatusUnsupportedMediaType,
+				apiErrors.UnsupportedPatchTypeError,
+			)
+		}
+
+		// The fields the patch changes are only known once it is applied
+		// below; callers who may not edit the user at all are turned away
+		// before that.
+		current, err := h.findUser(id)
+		if err != nil {
+			return err
+		}
+		if err = h.authorize(c, auth.PermissionUsersWrite, current); err != nil {
+			return err
+		}
+
+		err = h.usersUsecase.PatchUser(id, version, func(user *users.User) error {
+			document, err := json.Marshal(api.UserPatchDocument{
+				Email:    user.Email,
+				Username: user.Username,
+				Roles:    user.Roles,
+			})
+			if err != nil {
+				return err
+			}
+
+			if document, err = apply(document); err != nil {
+				return fiber.NewError(
+					fiber.StatusBadRequest,
+					apiErrors.InvalidPatchError,
+					err.Error(),
+				)
+			}
+
+			var patched api.UserPatchDocument
+			decoder := json.NewDecoder(bytes.NewReader(document))
+			decoder.DisallowUnknownFields()
+			if err = decoder.Decode(&patched); err != nil {
+				return fiber.NewError(
+					fiber.StatusBadRequest,
+					apiErrors.InvalidPatchError,
+					err.Error(),
+				)
+			}
+
+			if err = h.validate.StructCtx(c.Context(), &patched); err != nil {
+				errs := err.(validator.ValidationErrors)
+				return fiber.NewError(
+					fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+				)
+			}
+
+			fields := changedFields(user, patched.Email, patched.Username, patched.Password, patched.Roles)
+			if err = h.authorize(c, auth.PermissionUsersWrite, user, fields...); err != nil {
+				return err
+			}
+			if !canChangeRoles(c) && !sameRoles(user.Roles, patched.Roles) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenRolesError)
+			}
+
+			user.Email = patched.Email
+			user.Username = patched.Username
+			user.Roles = patched.Roles
+			user.Password = patched.Password
+			return nil
+		})
+		if err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				return err
+			}
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			if errors.Is(err, users.UserVersionMismatchError) {
+				code = fiber.StatusPreconditionFailed
+			}
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) ||
+				errors.Is(err, users.UnknownRoleError) {
 				code = fiber.StatusBadRequest
 			}
 			return fiber.NewError(code, err.Error())
@@ -135,14 +368,17 @@ func (h *Handlers) UpdateUserHandler() fiber.Handler {
 }
 
 // @Summary Create User
-// @Description Create a new user with the provided information (requires admin access)
+// @Description Create a new user with the provided information (requires the users:write permission, and roles:write to assign roles)
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param user body api.UserRequest true "User object to create"
 // @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} api.UserIdResponse
 // @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
 // @Failure 500 {object} api.ErrorResponse
 // @Router /v1/users [post]
 func (h *Handlers) CreateUserHandler() fiber.Handler {
@@ -162,16 +398,25 @@ func (h *Handlers) CreateUserHandler() fiber.Handler {
 				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
 			)
 		}
+		if len(user.Roles) > 0 && !canChangeRoles(c) {
+			return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenRolesError)
+		}
 
 		id, err := h.usersUsecase.CreateUser(&users.User{
-			Email:    user.Email,
-			Username: user.Username,
-			Password: user.Password,
-			Admin:    user.Admin,
+			Email:          user.Email,
+			Username:       user.Username,
+			Password:       user.Password,
+			Roles:          user.Roles,
+			ServiceAccount: user.ServiceAccount,
 		})
 		if err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
 			code := fiber.StatusInternalServerError
-			if errors.Is(err, users.UserAlreadyExistsError) {
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) ||
+				errors.Is(err, users.UnknownRoleError) {
 				code = fiber.StatusBadRequest
 			}
 			return fiber.NewError(code, err.Error())
@@ -183,26 +428,177 @@ func (h *Handlers) CreateUserHandler() fiber.Handler {
 	}
 }
 
+// @Summary Create Users
+// @Description Create several users at once; either all of them are created or none (requires the users:write permission, and roles:write to assign roles)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param users body []api.UserRequest true "User objects to create"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.UserIdsResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/batch [post]
+func (h *Handlers) CreateUsersHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request []api.UserRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		newUsers := make([]*users.User, len(request))
+		for i, user := range request {
+			if err := h.validate.StructCtx(c.Context(), &user); err != nil {
+				errs := err.(validator.ValidationErrors)
+				return fiber.NewError(
+					fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+				)
+			}
+			if len(user.Roles) > 0 && !canChangeRoles(c) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenRolesError)
+			}
+
+			newUsers[i] = &users.User{
+				Email:          user.Email,
+				Username:       user.Username,
+				Password:       user.Password,
+				Roles:          user.Roles,
+				ServiceAccount: user.ServiceAccount,
+			}
+		}
+
+		ids, err := h.usersUsecase.CreateUsers(newUsers)
+		if err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) ||
+				errors.Is(err, users.UnknownRoleError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.UserIdsResponse{
+			Ids: ids,
+		})
+	}
+}
+
+// defaultPageSize is the number of users GetUsersHandler returns when the
+// request sets no limit.
+const defaultPageSize = 50
+
 // @Summary Get Users
-// @Description Get a list of all users
+// @Description Get a page of users matching the filters; pass nextCursor from the response as cursor to get the next page (requires the users:read permission)
 // @Tags Users
 // @Produce json
+// @Param role query string false "Only users with this role"
+// @Param admin query bool false "Only users with (true) or without (false) the admin role"
+// @Param username query string false "Username prefix"
+// @Param email query string false "Email prefix"
+// @Param createdAfter query string false "Only users created after this RFC 3339 time"
+// @Param sort query string false "Sort field" Enums(username, email, createdAt) default(username)
+// @Param cursor query string false "Cursor of the next page"
+// @Param limit query int false "Page size" minimum(1) maximum(1000) default(50)
 // @Security BasicAuth
-// @Success 200 {array} api.UserResponse
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.UsersPageResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
 // @Router /v1/users [get]
 func (h *Handlers) GetUsersHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusOK).JSON(h.usersUsecase.GetUsers())
+		var request api.UsersQueryRequest
+		if err := c.QueryParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidQueryError,
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		query := users.UsersQuery{
+			Filter: users.UsersFilter{
+				Role:           request.Role,
+				UsernamePrefix: request.Username,
+				EmailPrefix:    request.Email,
+			},
+			SortBy: request.Sort,
+			Cursor: request.Cursor,
+			Limit:  request.Limit,
+		}
+		if request.Admin != nil {
+			if !*request.Admin {
+				query.Filter.WithoutRole = auth.AdminRole
+			} else if request.Role == "" || request.Role == auth.AdminRole {
+				query.Filter.Role = auth.AdminRole
+			} else {
+				return fiber.NewError(fiber.StatusBadRequest, apiErrors.ConflictingAdminFilterError)
+			}
+		}
+		if request.CreatedAfter != "" {
+			query.Filter.CreatedAfter, _ = time.Parse(time.RFC3339, request.CreatedAfter)
+		}
+		if request.EmailVerified != "" {
+			verified := request.EmailVerified == "true"
+			query.Filter.EmailVerified = &verified
+		}
+		if query.Limit == 0 {
+			query.Limit = defaultPageSize
+		}
+
+		page, err := h.usersUsecase.FindUsers(query)
+		if err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.InvalidCursorError) ||
+				errors.Is(err, users.InvalidSortFieldError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		response := api.UsersPageResponse{
+			Users:      make([]api.UserResponse, len(page.Users)),
+			NextCursor: page.NextCursor,
+		}
+		for i, user := range page.Users {
+			response.Users[i] = userResponse(user)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
 	}
 }
 
 // @Summary Delete User
-// @Description Delete a user by ID (requires admin access)
+// @Description Delete a user by ID (requires the users:delete permission, or the access policy allowing it)
 // @Tags Users
 // @Param id path string true "User ID"
+// @Param If-Match header string false "ETag of the user version being deleted"
 // @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} api.SuccessResponse "User deleted successfully"
 // @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
 // @Router /v1/users/{id} [delete]
 func (h *Handlers) DeleteUserHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -214,7 +610,32 @@ func (h *Handlers) DeleteUserHandler() fiber.Handler {
 			)
 		}
 
-		h.usersUsecase.DeleteUser(id)
+		version, err := parseIfMatch(c.Get(fiber.HeaderIfMatch))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidIfMatch,
+			)
+		}
+
+		current, err := h.findUser(id)
+		if err != nil {
+			return err
+		}
+		if err = h.authorize(c, auth.PermissionUsersDelete, current); err != nil {
+			return err
+		}
+		if current != nil && version == 0 {
+			version = current.Version
+		}
+
+		if err = h.usersUsecase.DeleteUser(id, version); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserVersionMismatchError) {
+				code = fiber.StatusPreconditionFailed
+			}
+			return fiber.NewError(code, err.Error())
+		}
 
 		return c.Status(fiber.StatusOK).JSON(
 			api.SuccessResponse{
@@ -224,6 +645,131 @@ func (h *Handlers) DeleteUserHandler() fiber.Handler {
 	}
 }
 
+// @Summary Reset Password
+// @Description Set a new password for a user and sign the user out (requires the users:reset-password permission, or the access policy allowing it)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param password body api.PasswordRequest true "New password"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/password [put]
+func (h *Handlers) ResetPasswordHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		var request api.PasswordRequest
+		if err = c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err = h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		current, err := h.findUser(id)
+		if err != nil {
+			return err
+		}
+		if err = h.authorize(c, auth.PermissionUsersResetPassword, current); err != nil {
+			return err
+		}
+
+		if err = h.usersUsecase.ResetPassword(id, request.Password); err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			if errors.Is(err, users.ServiceAccountPasswordError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// userResponse shows the status the user has now, so a suspension that has
+// ended is not shown.
+func userResponse(user *users.User) api.UserResponse {
+	response := api.UserResponse{
+		Id:             user.Id,
+		Email:          user.Email,
+		Username:       user.Username,
+		Roles:          user.Roles,
+		ServiceAccount: user.ServiceAccount,
+		EmailVerified:  user.EmailVerified,
+		Pending:        user.Pending,
+		Status:         user.StatusAt(time.Now()),
+		Version:        user.Version,
+		CreatedAt:      user.CreatedAt,
+	}
+	if response.Status != users.StatusActive {
+		response.StatusReason = user.StatusReason
+		if !user.SuspendedUntil.IsZero() {
+			response.SuspendedUntil = &user.SuspendedUntil
+		}
+	}
+
+	return response
+}
+
+// canChangeRoles reports whether the caller may assign and take away roles.
+// Without that, anyone allowed to edit users could make themselves admins.
+func canChangeRoles(c *fiber.Ctx) bool {
+	identity := api.Identity(c)
+	return identity != nil && identity.Can(auth.PermissionRolesWrite)
+}
+
+// sameRoles compares two lists of roles ignoring their order and duplicates.
+func sameRoles(a, b []string) bool {
+	seen := make(map[string]bool, len(a))
+	for _, role := range a {
+		seen[role] = false
+	}
+	for _, role := range b {
+		if _, ok := seen[role]; !ok {
+			return false
+		}
+		seen[role] = true
+	}
+	for _, found := range seen {
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
 func formattingValidatorErrors(tr ut.Translator, errs validator.ValidationErrors) string {
 	var sb strings.Builder
 	for i, e := range errs {
@@ -234,3 +780,51 @@ func formattingValidatorErrors(tr ut.Translator, errs validator.ValidationErrors
 	}
 	return sb.String()
 }
+
+// passwordPolicyError translates the violations of the password policy err
+// lists, or returns nil when err is no PasswordPolicyError.
+func passwordPolicyError(tr ut.Translator, err error) error {
+	var policyErr *users.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+
+	validationErr := &apiErrors.ValidationError{}
+	for _, violation := range policyErr.Violations {
+		message, _ := tr.T(violation.Rule, auth.FieldPassword, violation.Param)
+		validationErr.Violations = append(validationErr.Violations, api.ViolationResponse{
+			Field:   auth.FieldPassword,
+			Rule:    violation.Rule,
+			Param:   violation.Param,
+			Message: message,
+		})
+	}
+
+	return validationErr
+}
+
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseIfMatch returns the user version an If-Match header requires, or zero
+// when the header is missing or "*" and any version is accepted.
+func parseIfMatch(header string) (uint64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	tag, quoted := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	if !quoted || !closed {
+		return 0, errors.New("entity tag is not quoted")
+	}
+
+	version, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil || version == 0 {
+		return 0, errors.New("entity tag is not a user version")
+	}
+
+	return version, nil
+}
@@ -0,0 +1,80 @@
+package delivery_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/omelaymy/users/config"
+	"github.com/omelaymy/users/internal/api"
+	"github.com/omelaymy/users/internal/api/http/delivery"
+	"github.com/omelaymy/users/internal/users"
+	"github.com/omelaymy/users/internal/users/repository"
+	"github.com/omelaymy/users/internal/users/usecase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUsersRoleFilters(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	for _, user := range []*users.User{
+		{Username: "alice", Email: "alice@example.com", Roles: []string{"admin"}},
+		{Username: "bob", Email: "bob@example.com", Roles: []string{"user"}},
+		{Username: "carol", Email: "carol@example.com", Roles: []string{"admin", "user"}},
+	} {
+		_, err := repo.CreateUser(user)
+		require.NoError(t, err)
+	}
+
+	translator, _ := ut.New(en.New()).GetTranslator("en")
+	h := delivery.NewHandlers(
+		usecase.NewUsers(&config.Config{}, repo, nil, nil, nil, nil),
+		nil, nil, nil, nil, nil, nil,
+		validator.New(),
+		translator,
+	)
+	app := fiber.New()
+	app.Get("/users", h.GetUsersHandler())
+
+	get := func(query string) (int, []string) {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/users?"+query, nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+
+		var page api.UsersPageResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		usernames := make([]string, len(page.Users))
+		for i, user := range page.Users {
+			usernames[i] = user.Username
+		}
+		sort.Strings(usernames)
+		return resp.StatusCode, usernames
+	}
+
+	for _, tc := range []struct {
+		query     string
+		code      int
+		usernames []string
+	}{
+		{query: "role=admin", code: http.StatusOK, usernames: []string{"alice", "carol"}},
+		{query: "admin=true", code: http.StatusOK, usernames: []string{"alice", "carol"}},
+		{query: "admin=true&role=admin", code: http.StatusOK, usernames: []string{"alice", "carol"}},
+		{query: "admin=false", code: http.StatusOK, usernames: []string{"bob"}},
+		{query: "admin=false&role=user", code: http.StatusOK, usernames: []string{"bob"}},
+		{query: "admin=true&role=user", code: http.StatusBadRequest},
+		{query: "admin=maybe", code: http.StatusBadRequest},
+	} {
+		code, usernames := get(tc.query)
+		assert.Equal(t, tc.code, code, tc.query)
+		assert.Equal(t, tc.usernames, usernames, tc.query)
+	}
+}
@@ -0,0 +1,240 @@
+package delivery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// @Summary Invite User
+// @Description Create a pending user with the given email and roles and mail it an invite to pick a username and password (requires the users:write permission, and roles:write to assign roles)
+// @Tags Invites
+// @Accept json
+// @Produce json
+// @Param invite body api.InviteRequest true "Email and roles of the invited user"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 201 {object} api.UserIdResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/invites [post]
+func (h *Handlers) InviteHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.InviteRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+		if len(request.Roles) > 0 && !canChangeRoles(c) {
+			return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenRolesError)
+		}
+
+		id, err := h.invitationUsecase.Invite(request.Email, request.Roles)
+		if err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) ||
+				errors.Is(err, users.UnknownRoleError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(api.UserIdResponse{
+			Id: id,
+		})
+	}
+}
+
+// @Summary Get Invites
+// @Description List the users who were invited and have not accepted yet (requires the users:read permission)
+// @Tags Invites
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.InvitationsResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/invites [get]
+func (h *Handlers) GetInvitationsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invitations, err := h.invitationUsecase.GetInvitations()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		now := time.Now()
+		response := api.InvitationsResponse{
+			Invitations: make([]api.InvitationResponse, len(invitations)),
+		}
+		for i, invitation := range invitations {
+			response.Invitations[i] = invitationResponse(invitation, now)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// @Summary Resend Invite
+// @Description Mail an invited user a new invite, which replaces the previous one (requires the users:write permission)
+// @Tags Invites
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 202 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/invites/{id}/resend [post]
+func (h *Handlers) ResendInviteHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.invitationUsecase.ResendInvite(id); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.InviteNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Revoke Invite
+// @Description Delete an invited user who has not accepted yet, which makes the invite useless (requires the users:write permission)
+// @Tags Invites
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/invites/{id} [delete]
+func (h *Handlers) RevokeInviteHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.invitationUsecase.RevokeInvite(id); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.InviteNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Accept Invite
+// @Description Pick the username and password of an invited user with the invite token; the email counts as verified, and the token is used up unless the username is taken or the password breaks the password policy
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body api.AcceptInviteRequest true "Invite token, username and password"
+// @Success 200 {object} api.UserIdResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/invites/accept [post]
+func (h *Handlers) AcceptInviteHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.AcceptInviteRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		id, err := h.invitationUsecase.AcceptInvite(request.Token, request.Username, request.Password)
+		if err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+			if errors.Is(err, users.InvalidInviteTokenError) {
+				return fiber.NewError(fiber.StatusBadRequest, apiErrors.InvalidInviteTokenError)
+			}
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserAlreadyExistsError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.UserIdResponse{
+			Id: id,
+		})
+	}
+}
+
+func invitationResponse(invitation *users.Invitation, now time.Time) api.InvitationResponse {
+	response := api.InvitationResponse{
+		UserId:    invitation.UserId,
+		Email:     invitation.Email,
+		Roles:     invitation.Roles,
+		CreatedAt: invitation.CreatedAt,
+		Expired:   !invitation.ExpiresAt.After(now),
+	}
+	if !invitation.SentAt.IsZero() {
+		response.SentAt = &invitation.SentAt
+	}
+	if !invitation.ExpiresAt.IsZero() {
+		response.ExpiresAt = &invitation.ExpiresAt
+	}
+
+	return response
+}
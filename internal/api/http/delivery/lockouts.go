@@ -0,0 +1,120 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// @Summary Get Lockouts
+// @Description List the users, logins and client IPs with recent failed sign-ins and whether they are locked out (requires the users:unlock permission)
+// @Tags Lockouts
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.LockoutsResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/lockouts [get]
+func (h *Handlers) GetLockoutsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lockouts, err := h.authUsecase.GetLockouts()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		response := api.LockoutsResponse{
+			Lockouts: make([]api.LockoutResponse, len(lockouts)),
+		}
+		for i, lockout := range lockouts {
+			response.Lockouts[i] = api.LockoutResponse{
+				Kind:          lockout.Kind,
+				Name:          lockout.Name,
+				Failures:      lockout.Failures,
+				LastFailureAt: lockout.LastFailure,
+				Permanent:     lockout.Permanent,
+			}
+			if !lockout.LockedUntil.IsZero() {
+				lockedUntil := lockout.LockedUntil
+				response.Lockouts[i].LockedUntil = &lockedUntil
+			}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// @Summary Unlock
+// @Description Forget the failed sign-ins of a login or client IP (requires the users:unlock permission)
+// @Tags Lockouts
+// @Produce json
+// @Param kind path string true "Lockout kind" Enums(user, login, ip)
+// @Param name path string true "User id, login or client IP"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/lockouts/{kind}/{name} [delete]
+func (h *Handlers) UnlockHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := h.authUsecase.Unlock(c.Params("kind"), c.Params("name")); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, auth.LockoutNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Unlock User
+// @Description Forget the failed sign-ins with the username and the email of a user (requires the users:unlock permission)
+// @Tags Lockouts
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/lockout [delete]
+func (h *Handlers) UnlockUserHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.authUsecase.UnlockUser(id); err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, auth.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
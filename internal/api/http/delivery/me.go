@@ -0,0 +1,216 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// @Summary Get Own Profile
+// @Description Get the profile of the caller (requires the users:read permission, or the access policy allowing it)
+// @Tags Profile
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.UserResponse
+// @Header 200 {string} ETag "User version"
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/me [get]
+func (h *Handlers) GetMeHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := h.me(c)
+		if err != nil {
+			return err
+		}
+		if err = h.authorize(c, auth.PermissionUsersRead, user); err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderETag, formatETag(user.Version))
+		return c.Status(fiber.StatusOK).JSON(userResponse(user))
+	}
+}
+
+// @Summary Update Own Profile
+// @Description Change the email and username of the caller; roles and password stay as they are (requires the users:write permission, or the access policy allowing it)
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param profile body api.ProfileRequest true "Email and username"
+// @Param If-Match header string false "ETag of the user version being updated"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 412 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/me [put]
+func (h *Handlers) UpdateMeHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		version, err := parseIfMatch(c.Get(fiber.HeaderIfMatch))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidIfMatch,
+			)
+		}
+
+		var request api.ProfileRequest
+		if err = c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err = h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		current, err := h.me(c)
+		if err != nil {
+			return err
+		}
+		fields := changedFields(current, request.Email, request.Username, "", current.Roles)
+		if err = h.authorize(c, auth.PermissionUsersWrite, current, fields...); err != nil {
+			return err
+		}
+		// As with UpdateUserHandler, the policy decided on this version.
+		if version == 0 {
+			version = current.Version
+		}
+
+		err = h.usersUsecase.PatchUser(current.Id, version, func(user *users.User) error {
+			user.Email = request.Email
+			user.Username = request.Username
+			return nil
+		})
+		if err != nil {
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			if errors.Is(err, users.UserVersionMismatchError) {
+				code = fiber.StatusPreconditionFailed
+			}
+			if errors.Is(err, users.UserAlreadyExistsError) ||
+				errors.Is(err, users.EmailAlreadyExistsError) {
+				code = fiber.StatusBadRequest
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Change Own Password
+// @Description Replace the password of the caller, given the current one, and sign the caller out everywhere; wrong current passwords count towards the sign-in lockout
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param request body api.ChangePasswordRequest true "Current and new password"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 429 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/me/password [put]
+func (h *Handlers) ChangePasswordHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// A leaked API key must not be enough to take over its owner.
+		identity := api.Identity(c)
+		if identity == nil || identity.Scopes != nil {
+			return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenAPIKeyPasswordError)
+		}
+
+		var request api.ChangePasswordRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		if err := h.authUsecase.VerifyPassword(identity.UserId, request.CurrentPassword); err != nil {
+			if errors.Is(err, auth.InvalidCredentialsError) {
+				return fiber.NewError(fiber.StatusForbidden, apiErrors.WrongPasswordError)
+			}
+			if errors.Is(err, auth.UserNotFoundError) {
+				return fiber.NewError(fiber.StatusNotFound, users.UserNotFoundError.Error())
+			}
+			var locked *auth.LockedError
+			if errors.As(err, &locked) {
+				api.SetRetryAfter(c, locked)
+				return fiber.NewError(fiber.StatusTooManyRequests, apiErrors.TooManySignInsError)
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		if err := h.usersUsecase.ResetPassword(identity.UserId, request.Password); err != nil {
+			if policyErr := passwordPolicyError(h.errorsTranslator, err); policyErr != nil {
+				return policyErr
+			}
+			code := fiber.StatusInternalServerError
+			if errors.Is(err, users.UserNotFoundError) {
+				code = fiber.StatusNotFound
+			}
+			return fiber.NewError(code, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// me returns the user the caller is signed in as.
+func (h *Handlers) me(c *fiber.Ctx) (*users.User, error) {
+	identity := api.Identity(c)
+	if identity == nil {
+		return nil, fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenPolicyError)
+	}
+
+	user, err := h.findUser(identity.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, users.UserNotFoundError.Error())
+	}
+
+	return user, nil
+}
@@ -0,0 +1,157 @@
+package delivery
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// @Summary Explain Access
+// @Description Show whether the caller may perform an action on a user and which rules of the access policy decided it
+// @Tags Auth
+// @Produce json
+// @Param action query string true "Action" Enums(users:read, users:write, users:delete, users:reset-password)
+// @Param target query string false "User ID"
+// @Param fields query string false "Comma-separated fields an update changes" example(email,roles)
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.ExplainResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/explain [get]
+func (h *Handlers) ExplainHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.ExplainRequest
+		if err := c.QueryParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidQueryError,
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		var target *users.User
+		if request.Target != "" {
+			var err error
+			if target, err = h.findUser(uuid.MustParse(request.Target)); err != nil {
+				return err
+			}
+			// The trace tells attributes of the target, like whether it is
+			// an admin, to those who may read it only.
+			if err = h.authorize(c, auth.PermissionUsersRead, target); err != nil {
+				return err
+			}
+		}
+
+		var fields []string
+		if request.Fields != "" {
+			fields = strings.Split(request.Fields, ",")
+		}
+
+		decision := h.policy.Evaluate(accessRequest(c, request.Action, target, fields))
+
+		response := api.ExplainResponse{
+			Allowed: decision.Allowed,
+			Action:  request.Action,
+			Rule:    decision.Rule,
+			Reason:  decision.Reason,
+			Rules:   make([]api.RuleTraceResponse, len(decision.Trace)),
+		}
+		for i, rule := range decision.Trace {
+			response.Rules[i] = api.RuleTraceResponse{
+				Name:    rule.Rule,
+				Effect:  rule.Effect,
+				Applies: rule.Applies,
+				Matched: rule.Matched,
+			}
+			for _, condition := range rule.Conditions {
+				response.Rules[i].Conditions = append(response.Rules[i].Conditions, api.ConditionTraceResponse{
+					Condition: condition.Condition,
+					Matched:   condition.Matched,
+				})
+			}
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// authorize asks the access policy whether the caller may perform action on
+// target, changing fields.
+func (h *Handlers) authorize(c *fiber.Ctx, action string, target *users.User, fields ...string) error {
+	if !h.policy.Evaluate(accessRequest(c, action, target, fields)).Allowed {
+		return fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenPolicyError)
+	}
+
+	return nil
+}
+
+// findUser returns nil for a user that does not exist, so the policy decides
+// without its attributes before the handler answers 404. Callers that may not
+// act on users at all then cannot tell which exist.
+func (h *Handlers) findUser(id uuid.UUID) (*users.User, error) {
+	user, err := h.usersUsecase.GetUser(id)
+	if err != nil {
+		if errors.Is(err, users.UserNotFoundError) {
+			return nil, nil
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return user, nil
+}
+
+func accessRequest(c *fiber.Ctx, action string, target *users.User, fields []string) *auth.AccessRequest {
+	request := &auth.AccessRequest{
+		Subject: api.Identity(c),
+		Action:  action,
+		Fields:  fields,
+	}
+	if target != nil {
+		request.Target = &auth.User{
+			Id:             target.Id,
+			Email:          target.Email,
+			Username:       target.Username,
+			Roles:          target.Roles,
+			ServiceAccount: target.ServiceAccount,
+		}
+	}
+
+	return request
+}
+
+// changedFields lists the fields an update of user to the requested values
+// changes. Passwords are stored hashed, so setting one always counts.
+func changedFields(user *users.User, email, username, password string, roles []string) []string {
+	var fields []string
+	if email != user.Email {
+		fields = append(fields, auth.FieldEmail)
+	}
+	if username != user.Username {
+		fields = append(fields, auth.FieldUsername)
+	}
+	if password != "" {
+		fields = append(fields, auth.FieldPassword)
+	}
+	if !sameRoles(user.Roles, roles) {
+		fields = append(fields, auth.FieldRoles)
+	}
+
+	return fields
+}
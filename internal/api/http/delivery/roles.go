@@ -0,0 +1,214 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// @Summary Get Roles
+// @Description List every role with its permissions (requires the roles:read permission)
+// @Tags Roles
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.RolesResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/roles [get]
+func (h *Handlers) GetRolesHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		roles, err := h.authUsecase.GetRoles()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		response := api.RolesResponse{
+			Roles: make([]api.RoleResponse, len(roles)),
+		}
+		for i, role := range roles {
+			response.Roles[i] = roleResponse(role)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(response)
+	}
+}
+
+// @Summary Get Role
+// @Description Get a role by name (requires the roles:read permission)
+// @Tags Roles
+// @Produce json
+// @Param name path string true "Role name"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.RoleResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/roles/{name} [get]
+func (h *Handlers) GetRoleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, err := h.authUsecase.GetRole(c.Params("name"))
+		if err != nil {
+			return roleError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(roleResponse(role))
+	}
+}
+
+// @Summary Create Role
+// @Description Create a role from permissions (requires the roles:write permission)
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param role body api.RoleRequest true "Role to create"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.RoleResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/roles [post]
+func (h *Handlers) CreateRoleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.RoleRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		role, err := h.authUsecase.CreateRole(&auth.Role{
+			Name:        request.Name,
+			Description: request.Description,
+			Permissions: request.Permissions,
+			RequireTOTP: request.RequireTOTP,
+		})
+		if err != nil {
+			return roleError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(roleResponse(role))
+	}
+}
+
+// @Summary Update Role
+// @Description Replace the description, the permissions and whether a second factor is required of a role; its users get them with their next sign-in or token refresh. Of the admin role, only requireTotp can be changed (requires the roles:write permission)
+// @Tags Roles
+// @Accept json
+// @Produce json
+// @Param name path string true "Role name"
+// @Param role body api.RoleRequest true "Role with the same name"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.RoleResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/roles/{name} [put]
+func (h *Handlers) UpdateRoleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request api.RoleRequest
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+		if request.Name != c.Params("name") {
+			return fiber.NewError(fiber.StatusBadRequest, apiErrors.RoleNameMismatchError)
+		}
+
+		role, err := h.authUsecase.UpdateRole(&auth.Role{
+			Name:        request.Name,
+			Description: request.Description,
+			Permissions: request.Permissions,
+			RequireTOTP: request.RequireTOTP,
+		})
+		if err != nil {
+			return roleError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(roleResponse(role))
+	}
+}
+
+// @Summary Delete Role
+// @Description Delete a role no user has; the admin role cannot be deleted (requires the roles:write permission)
+// @Tags Roles
+// @Produce json
+// @Param name path string true "Role name"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/roles/{name} [delete]
+func (h *Handlers) DeleteRoleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := h.authUsecase.DeleteRole(c.Params("name")); err != nil {
+			return roleError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+func roleError(err error) error {
+	code := fiber.StatusInternalServerError
+	if errors.Is(err, auth.RoleNotFoundError) {
+		code = fiber.StatusNotFound
+	}
+	if errors.Is(err, auth.RoleAlreadyExistsError) ||
+		errors.Is(err, auth.RoleInUseError) {
+		code = fiber.StatusConflict
+	}
+	if errors.Is(err, auth.InvalidPermissionError) ||
+		errors.Is(err, auth.ReadOnlyRoleError) {
+		code = fiber.StatusBadRequest
+	}
+
+	return fiber.NewError(code, err.Error())
+}
+
+func roleResponse(role *auth.Role) api.RoleResponse {
+	return api.RoleResponse{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+		RequireTOTP: role.RequireTOTP,
+		CreatedAt:   role.CreatedAt,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/swagger"
 	"github.com/omelaymy/users/internal/api"
+	"github.com/omelaymy/users/internal/auth"
 )
 
 type Routes struct {
@@ -29,11 +30,73 @@ func (r *Routes) RegisterRoutes() {
 
 	api := r.router.Group("/api")
 	v1 := api.Group("/v1")
-	users := v1.Group("/users").Use(r.mw.BasicAuth())
 
-	users.Get("", r.h.GetUsersHandler())
+	authGroup := v1.Group("/auth")
+	authGroup.Post("/token", r.h.IssueTokensHandler())
+	authGroup.Post("/refresh", r.h.RefreshTokensHandler())
+	authGroup.Post("/password-reset", r.h.RequestPasswordResetHandler())
+	authGroup.Post("/password-reset/confirm", r.h.ConfirmPasswordResetHandler())
+	authGroup.Get("/verify-email", r.h.VerifyEmailHandler())
+	authGroup.Post("/invites/accept", r.h.AcceptInviteHandler())
+	authGroup.Get("/explain", r.mw.Auth(), r.h.ExplainHandler())
+
+	totp := authGroup.Group("/totp").Use(r.mw.AuthEnrolling())
+
+	totp.Get("", r.h.GetTOTPStatusHandler())
+	totp.Post("", r.h.EnrollTOTPHandler())
+	totp.Post("/confirm", r.h.ConfirmTOTPHandler())
+	totp.Post("/recovery-codes", r.h.RegenerateRecoveryCodesHandler())
+	totp.Delete("", r.h.DisableTOTPHandler())
+
+	users := v1.Group("/users").Use(r.mw.Auth())
+
+	users.Get("", r.mw.RequirePermission(auth.PermissionUsersRead), r.h.GetUsersHandler())
+	users.Post("", r.mw.RequirePermission(auth.PermissionUsersWrite), r.h.CreateUserHandler())
+	users.Post("/batch", r.mw.RequirePermission(auth.PermissionUsersWrite), r.h.CreateUsersHandler())
+
+	// The handlers of single users ask the access policy instead.
+	users.Get("/me", r.h.GetMeHandler())
+	users.Put("/me", r.h.UpdateMeHandler())
+	users.Put("/me/password", r.h.ChangePasswordHandler())
 	users.Get("/:id<guid>", r.h.GetUserHandler())
-	users.Post("", r.mw.AdminAuth(), r.h.CreateUserHandler())
-	users.Put("/:id<guid>", r.mw.AdminAuth(), r.h.UpdateUserHandler())
-	users.Delete("/:id<guid>", r.mw.AdminAuth(), r.h.DeleteUserHandler())
+	users.Put("/:id<guid>", r.h.UpdateUserHandler())
+	users.Patch("/:id<guid>", r.h.PatchUserHandler())
+	users.Delete("/:id<guid>", r.h.DeleteUserHandler())
+	users.Put("/:id<guid>/password", r.h.ResetPasswordHandler())
+
+	users.Get("/:id<guid>/sessions", r.mw.RequirePermissionOrSelf(auth.PermissionUsersRead), r.h.GetSessionsHandler())
+	users.Delete("/:id<guid>/sessions", r.mw.RequirePermissionOrSelf(auth.PermissionUsersWrite), r.h.RevokeSessionsHandler())
+	users.Delete("/:id<guid>/sessions/:sessionId<guid>", r.mw.RequirePermissionOrSelf(auth.PermissionUsersWrite), r.h.RevokeSessionHandler())
+
+	users.Post("/:id<guid>/verify-email", r.mw.RequirePermissionOrSelf(auth.PermissionUsersWrite), r.h.SendVerificationHandler())
+
+	users.Post("/:id<guid>/api-keys", r.mw.RequirePermission(auth.PermissionAPIKeysWrite), r.h.CreateAPIKeyHandler())
+	users.Get("/:id<guid>/api-keys", r.mw.RequirePermission(auth.PermissionUsersRead), r.h.GetAPIKeysHandler())
+	users.Delete("/:id<guid>/api-keys/:keyId<guid>", r.mw.RequirePermission(auth.PermissionAPIKeysWrite), r.h.RevokeAPIKeyHandler())
+
+	users.Delete("/:id<guid>/lockout", r.mw.RequirePermission(auth.PermissionUsersUnlock), r.h.UnlockUserHandler())
+	users.Post("/:id<guid>/suspend", r.mw.RequirePermission(auth.PermissionUsersSuspend), r.h.SuspendUserHandler())
+	users.Post("/:id<guid>/deactivate", r.mw.RequirePermission(auth.PermissionUsersSuspend), r.h.DeactivateUserHandler())
+	users.Post("/:id<guid>/reactivate", r.mw.RequirePermission(auth.PermissionUsersSuspend), r.h.ReactivateUserHandler())
+	users.Delete("/:id<guid>/totp", r.mw.RequirePermission(auth.PermissionUsersResetPassword), r.h.ResetTOTPHandler())
+
+	invites := v1.Group("/invites").Use(r.mw.Auth())
+
+	invites.Get("", r.mw.RequirePermission(auth.PermissionUsersRead), r.h.GetInvitationsHandler())
+	invites.Post("", r.mw.RequirePermission(auth.PermissionUsersWrite), r.h.InviteHandler())
+	invites.Post("/:id<guid>/resend", r.mw.RequirePermission(auth.PermissionUsersWrite), r.h.ResendInviteHandler())
+	invites.Delete("/:id<guid>", r.mw.RequirePermission(auth.PermissionUsersWrite), r.h.RevokeInviteHandler())
+
+	lockouts := v1.Group("/lockouts").Use(r.mw.Auth(), r.mw.RequirePermission(auth.PermissionUsersUnlock))
+
+	lockouts.Get("", r.h.GetLockoutsHandler())
+	lockouts.Delete("/:kind/:name", r.h.UnlockHandler())
+
+	roles := v1.Group("/roles").Use(r.mw.Auth())
+
+	roles.Get("", r.mw.RequirePermission(auth.PermissionRolesRead), r.h.GetRolesHandler())
+	roles.Get("/:name", r.mw.RequirePermission(auth.PermissionRolesRead), r.h.GetRoleHandler())
+	roles.Post("", r.mw.RequirePermission(auth.PermissionRolesWrite), r.h.CreateRoleHandler())
+	roles.Put("/:name", r.mw.RequirePermission(auth.PermissionRolesWrite), r.h.UpdateRoleHandler())
+	roles.Delete("/:name", r.mw.RequirePermission(auth.PermissionRolesWrite), r.h.DeleteRoleHandler())
 }
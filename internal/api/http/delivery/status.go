@@ -0,0 +1,169 @@
+package delivery
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// @Summary Suspend User
+// @Description Keep a user from signing in until the given time, or until the user is reactivated if none is given, and sign the user out everywhere (requires the users:suspend permission)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body api.SuspendRequest true "Reason and end of the suspension"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/suspend [post]
+func (h *Handlers) SuspendUserHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := h.otherUserId(c)
+		if err != nil {
+			return err
+		}
+
+		var request api.SuspendRequest
+		if err = c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err = h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+		var until time.Time
+		if request.Until != "" {
+			// The validator has checked the format already.
+			until, _ = time.Parse(time.RFC3339, request.Until)
+		}
+
+		err = h.accountStatusUsecase.Suspend(id, request.Reason, until)
+		return statusResponse(c, err)
+	}
+}
+
+// @Summary Deactivate User
+// @Description Keep a user from signing in until the user is reactivated, and sign the user out everywhere (requires the users:suspend permission)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body api.DeactivateRequest true "Reason of the deactivation"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/deactivate [post]
+func (h *Handlers) DeactivateUserHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := h.otherUserId(c)
+		if err != nil {
+			return err
+		}
+
+		var request api.DeactivateRequest
+		if err = c.BodyParser(&request); err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidRequestBodyError,
+				err.Error(),
+			)
+		}
+
+		if err = h.validate.StructCtx(c.Context(), &request); err != nil {
+			errs := err.(validator.ValidationErrors)
+			return fiber.NewError(
+				fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+			)
+		}
+
+		err = h.accountStatusUsecase.Deactivate(id, request.Reason)
+		return statusResponse(c, err)
+	}
+}
+
+// @Summary Reactivate User
+// @Description Let a suspended or deactivated user sign in again (requires the users:suspend permission)
+// @Tags Users
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/reactivate [post]
+func (h *Handlers) ReactivateUserHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := h.otherUserId(c)
+		if err != nil {
+			return err
+		}
+
+		err = h.accountStatusUsecase.Reactivate(id)
+		return statusResponse(c, err)
+	}
+}
+
+// otherUserId returns the id of the user named by the route, which must not
+// be the caller, so admins cannot lock themselves out by mistake.
+func (h *Handlers) otherUserId(c *fiber.Ctx) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.UUID{}, fiber.NewError(
+			fiber.StatusBadRequest,
+			apiErrors.InvalidId,
+		)
+	}
+	if identity := api.Identity(c); identity != nil && identity.UserId == id {
+		return uuid.UUID{}, fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenOwnStatusError)
+	}
+
+	return id, nil
+}
+
+func statusResponse(c *fiber.Ctx, err error) error {
+	if err != nil {
+		code := fiber.StatusInternalServerError
+		if errors.Is(err, users.UserNotFoundError) {
+			code = fiber.StatusNotFound
+		}
+		if errors.Is(err, users.SuspensionEndedError) {
+			code = fiber.StatusBadRequest
+		}
+		return fiber.NewError(code, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(
+		api.SuccessResponse{
+			Success: true,
+		},
+	)
+}
@@ -0,0 +1,262 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/omelaymy/users/internal/api"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// @Summary Get Second Factor
+// @Description Tell whether the caller has a second factor and whether the roles of the caller require one
+// @Tags Second Factor
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.TOTPStatusResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/totp [get]
+func (h *Handlers) GetTOTPStatusHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := totpCaller(c)
+		if err != nil {
+			return err
+		}
+
+		status, err := h.authUsecase.GetTOTPStatus(userId)
+		if err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.TOTPStatusResponse{
+			Enabled:       status.Enabled,
+			Required:      status.Required,
+			RecoveryCodes: status.RecoveryCodes,
+		})
+	}
+}
+
+// @Summary Set Up Second Factor
+// @Description Start setting up a second factor for the caller; the secret is only returned in this response and counts once confirmed with a code
+// @Tags Second Factor
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.TOTPSetupResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/totp [post]
+func (h *Handlers) EnrollTOTPHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := totpCaller(c)
+		if err != nil {
+			return err
+		}
+
+		setup, err := h.authUsecase.EnrollTOTP(userId)
+		if err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.TOTPSetupResponse{
+			Secret: setup.Secret,
+			URI:    setup.URI,
+		})
+	}
+}
+
+// @Summary Confirm Second Factor
+// @Description Turn the second factor of the caller on with a first code of the authenticator app; the recovery codes are only returned in this response
+// @Tags Second Factor
+// @Accept json
+// @Produce json
+// @Param request body api.TOTPCodeRequest true "Code of the authenticator app"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.RecoveryCodesResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 409 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/totp/confirm [post]
+func (h *Handlers) ConfirmTOTPHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := totpCaller(c)
+		if err != nil {
+			return err
+		}
+
+		code, err := h.totpCode(c)
+		if err != nil {
+			return err
+		}
+
+		codes, err := h.authUsecase.ConfirmTOTP(userId, code)
+		if err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// @Summary Regenerate Recovery Codes
+// @Description Replace the recovery codes of the caller, given a code of the authenticator app or a recovery code; the new codes are only returned in this response
+// @Tags Second Factor
+// @Accept json
+// @Produce json
+// @Param request body api.TOTPCodeRequest true "Code of the authenticator app or recovery code"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.RecoveryCodesResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/totp/recovery-codes [post]
+func (h *Handlers) RegenerateRecoveryCodesHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := totpCaller(c)
+		if err != nil {
+			return err
+		}
+
+		code, err := h.totpCode(c)
+		if err != nil {
+			return err
+		}
+
+		codes, err := h.authUsecase.RegenerateRecoveryCodes(userId, code)
+		if err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(api.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// @Summary Disable Second Factor
+// @Description Remove the second factor of the caller, given a code of the authenticator app or a recovery code
+// @Tags Second Factor
+// @Accept json
+// @Produce json
+// @Param request body api.TOTPCodeRequest true "Code of the authenticator app or recovery code"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 403 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/auth/totp [delete]
+func (h *Handlers) DisableTOTPHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := totpCaller(c)
+		if err != nil {
+			return err
+		}
+
+		code, err := h.totpCode(c)
+		if err != nil {
+			return err
+		}
+
+		if err = h.authUsecase.DisableTOTP(userId, code); err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// @Summary Reset Second Factor
+// @Description Remove the second factor of a user who lost it; users whose roles require one set up a new one with their next sign-in (requires the users:reset-password permission)
+// @Tags Second Factor
+// @Produce json
+// @Param id path string true "User ID"
+// @Security BasicAuth
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Success 200 {object} api.SuccessResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /v1/users/{id}/totp [delete]
+func (h *Handlers) ResetTOTPHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(
+				fiber.StatusBadRequest,
+				apiErrors.InvalidId,
+			)
+		}
+
+		if err = h.authUsecase.ResetTOTP(id); err != nil {
+			return totpError(err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(
+			api.SuccessResponse{
+				Success: true,
+			},
+		)
+	}
+}
+
+// totpCaller returns the user the second factor routes act on. API keys
+// cannot manage second factors, or a leaked key could replace the second
+// factor of its owner.
+func totpCaller(c *fiber.Ctx) (uuid.UUID, error) {
+	identity := api.Identity(c)
+	if identity == nil || identity.Scopes != nil {
+		return uuid.Nil, fiber.NewError(fiber.StatusForbidden, apiErrors.ForbiddenAPIKeyError)
+	}
+
+	return identity.UserId, nil
+}
+
+func (h *Handlers) totpCode(c *fiber.Ctx) (string, error) {
+	var request api.TOTPCodeRequest
+	if err := c.BodyParser(&request); err != nil {
+		return "", fiber.NewError(
+			fiber.StatusBadRequest,
+			apiErrors.InvalidRequestBodyError,
+			err.Error(),
+		)
+	}
+
+	if err := h.validate.StructCtx(c.Context(), &request); err != nil {
+		errs := err.(validator.ValidationErrors)
+		return "", fiber.NewError(
+			fiber.StatusBadRequest, formattingValidatorErrors(h.errorsTranslator, errs),
+		)
+	}
+
+	return request.Code, nil
+}
+
+func totpError(err error) error {
+	switch {
+	case errors.Is(err, auth.InvalidTOTPCodeError):
+		return fiber.NewError(fiber.StatusBadRequest, apiErrors.InvalidTOTPCodeError)
+	case errors.Is(err, auth.UserNotFoundError), errors.Is(err, auth.TOTPNotFoundError):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, auth.TOTPAlreadyEnabledError):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+}
@@ -2,6 +2,7 @@ package errors
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/omelaymy/users/internal/api"
@@ -20,7 +21,30 @@ func NewHttpErrorHandler(
 	}
 }
 
+// ValidationError is answered with 400 Bad Request and the violations it
+// lists.
+type ValidationError struct {
+	Violations []api.ViolationResponse
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+
+	return strings.Join(messages, "\n")
+}
+
 func (h *HttpErrorHandler) Handler(ctx *fiber.Ctx, err error) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Message:    validationErr.Error(),
+			Violations: validationErr.Violations,
+		})
+	}
+
 	code := fiber.StatusInternalServerError
 
 	var e *fiber.Error
@@ -4,4 +4,52 @@ const UnknownError = "unknown error"
 
 const InvalidRequestBodyError = "invalid request body error"
 
+const InvalidQueryError = "invalid query error"
+
+const InvalidPatchError = "invalid patch error"
+
+const ConflictingAdminFilterError = "admin=true cannot be combined with another role"
+
+const UnsupportedPatchTypeError = "patch must be application/merge-patch+json or application/json-patch+json"
+
+const InvalidCredentialsError = "invalid username or password"
+
+const InvalidTokenError = "invalid or expired token"
+
+const InvalidResetTokenError = "invalid or expired password reset token"
+
+const InvalidInviteTokenError = "invalid or expired invite token"
+
+const InvalidVerificationTokenError = "invalid or expired email verification token"
+
+const EmailNotVerifiedError = "email not verified; follow the link mailed to it first"
+
+const UserSuspendedError = "account suspended"
+
+const UserDeactivatedError = "account deactivated"
+
+const ForbiddenOwnStatusError = "users cannot suspend or deactivate themselves"
+
+const TOTPRequiredError = "second factor code required"
+
+const InvalidTOTPCodeError = "invalid second factor code"
+
+const ForbiddenAPIKeyError = "api keys cannot manage second factors"
+
+const ForbiddenAPIKeyPasswordError = "api keys cannot change passwords"
+
+const WrongPasswordError = "current password is wrong"
+
+const ForbiddenRolesError = "changing roles requires the roles:write permission"
+
+const ForbiddenScopeError = "api key scopes must be permissions of the caller"
+
+const RoleNameMismatchError = "role name does not match the path"
+
 const InvalidId = "invalid id error"
+
+const InvalidIfMatch = "invalid If-Match header error"
+
+const ForbiddenPolicyError = "forbidden by the access policy; GET /api/v1/auth/explain shows why"
+
+const TooManySignInsError = "too many failed sign-ins, try again later"
@@ -1,11 +1,19 @@
 package api
 
 import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/google/uuid"
 	"github.com/omelaymy/users/internal/auth"
 )
 
+const identityKey = "identity"
+
 type MWManager struct {
 	authUsecase auth.Usecase
 }
@@ -18,18 +26,129 @@ func NewMWManager(
 	}
 }
 
-func (mw *MWManager) BasicAuth() fiber.Handler {
-	return basicauth.New(
-		basicauth.Config{
-			Authorizer: mw.authUsecase.Authentication,
-		})
+// Auth authenticates a request by its bearer token, API key or Basic Auth
+// credentials, and stores the caller for Identity. Bearer tokens are checked
+// without reading the user store. Callers who still have to set up a second
+// factor are refused.
+func (mw *MWManager) Auth() fiber.Handler {
+	return mw.auth(false)
+}
+
+// AuthEnrolling is Auth for the routes that set up second factors, which
+// also lets callers through who still have to set one up.
+func (mw *MWManager) AuthEnrolling() fiber.Handler {
+	return mw.auth(true)
+}
+
+func (mw *MWManager) auth(allowEnrollment bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scheme, credentials, _ := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+
+		switch {
+		case strings.EqualFold(scheme, "Bearer"):
+			identity, err := mw.authUsecase.VerifyAccessToken(credentials)
+			if err != nil {
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return c.SendStatus(fiber.StatusUnauthorized)
+			}
+			c.Locals(identityKey, identity)
+		case strings.EqualFold(scheme, "ApiKey"):
+			identity, err := mw.authUsecase.VerifyAPIKey(credentials)
+			if err != nil {
+				c.Set(fiber.HeaderWWWAuthenticate, "ApiKey realm=Restricted")
+				return c.SendStatus(fiber.StatusUnauthorized)
+			}
+			c.Locals(identityKey, identity)
+		case strings.EqualFold(scheme, "Basic"):
+			identity, err := mw.basicAuth(credentials, c.IP())
+			if err != nil {
+				var locked *auth.LockedError
+				if errors.As(err, &locked) {
+					return TooManyRequests(c, locked)
+				}
+				return Unauthorized(c)
+			}
+			c.Locals(identityKey, identity)
+		default:
+			return Unauthorized(c)
+		}
+
+		if identity := Identity(c); identity.TOTPEnrollment && !allowEnrollment {
+			return Forbidden(c)
+		}
+
+		return c.Next()
+	}
+}
+
+// RequirePermission must run after Auth. It lets callers with the permission
+// through.
+func (mw *MWManager) RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity := Identity(c)
+		if identity == nil || !identity.Can(permission) {
+			return Forbidden(c)
+		}
+
+		return c.Next()
+	}
+}
+
+// RequirePermissionOrSelf must run after Auth. It lets callers with the
+// permission and the user named by the id route parameter through.
+func (mw *MWManager) RequirePermissionOrSelf(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity := Identity(c)
+		if identity == nil {
+			return Forbidden(c)
+		}
+		if id, err := uuid.Parse(c.Params("id")); !identity.Can(permission) && (err != nil || id != identity.UserId) {
+			return Forbidden(c)
+		}
+
+		return c.Next()
+	}
+}
+
+func (mw *MWManager) basicAuth(credentials, clientIP string) (*auth.Identity, error) {
+	raw, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, auth.InvalidCredentialsError
+	}
+
+	return mw.authUsecase.Authenticate(username, password, clientIP)
 }
 
-func (mw *MWManager) AdminAuth() fiber.Handler {
-	return basicauth.New(basicauth.Config{
-		Authorizer:   mw.authUsecase.AdminAuthorization,
-		Unauthorized: Forbidden,
-	})
+// Identity returns the caller authenticated by Auth, or nil.
+func Identity(c *fiber.Ctx) *auth.Identity {
+	identity, _ := c.Locals(identityKey).(*auth.Identity)
+	return identity
+}
+
+func Unauthorized(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, "Basic realm=Restricted")
+	return c.SendStatus(fiber.StatusUnauthorized)
+}
+
+// TooManyRequests answers a sign-in refused by a lockout.
+func TooManyRequests(c *fiber.Ctx, locked *auth.LockedError) error {
+	SetRetryAfter(c, locked)
+	return c.SendStatus(fiber.StatusTooManyRequests)
+}
+
+// SetRetryAfter tells when a lockout ends; permanent lockouts get no header.
+func SetRetryAfter(c *fiber.Ctx, locked *auth.LockedError) {
+	if locked.Until.IsZero() {
+		return
+	}
+
+	seconds := int64(time.Until(locked.Until).Seconds()) + 1
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(seconds, 10))
 }
 
 func Forbidden(c *fiber.Ctx) error {
@@ -1,12 +1,222 @@
 package auth
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type User struct {
-	Username string
-	Password string
-	Admin    bool
+	Id             uuid.UUID
+	Email          string
+	Username       string
+	Password       string
+	Roles          []string
+	ServiceAccount bool
+	EmailVerified  bool
+	Pending        bool
+	Status         string
+	SuspendedUntil time.Time
 }
 
+// The statuses of users. Suspended users cannot sign in until SuspendedUntil,
+// or until they are reactivated if it is zero; deactivated users cannot sign
+// in until they are reactivated.
+const (
+	StatusActive      = "active"
+	StatusSuspended   = "suspended"
+	StatusDeactivated = "deactivated"
+)
+
 type Credentials struct {
 	Username string
 	Password string
 }
+
+// Identity is the authenticated caller of a request. SessionId is zero for
+// callers that sent credentials instead of a token. Scopes are those of the
+// API key the caller sent and nil for other callers.
+type Identity struct {
+	UserId      uuid.UUID
+	Roles       []string
+	Permissions []string
+	Scopes      []string
+	SessionId   uuid.UUID
+	// TOTPEnrollment marks callers who signed in without the second factor
+	// their roles require. They have no permissions and may only set it up.
+	TOTPEnrollment bool
+}
+
+func (i *Identity) Can(permission string) bool {
+	return hasPermission(i.Permissions, permission)
+}
+
+const (
+	PermissionUsersRead          = "users:read"
+	PermissionUsersWrite         = "users:write"
+	PermissionUsersDelete        = "users:delete"
+	PermissionUsersResetPassword = "users:reset-password"
+	PermissionUsersUnlock        = "users:unlock"
+	PermissionUsersSuspend       = "users:suspend"
+	PermissionRolesRead          = "roles:read"
+	// PermissionRolesWrite allows changing roles and which users have them.
+	PermissionRolesWrite   = "roles:write"
+	PermissionAPIKeysWrite = "api-keys:write"
+)
+
+// Permissions lists every permission a role can grant.
+var Permissions = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionUsersDelete,
+	PermissionUsersResetPassword,
+	PermissionUsersUnlock,
+	PermissionUsersSuspend,
+	PermissionRolesRead,
+	PermissionRolesWrite,
+	PermissionAPIKeysWrite,
+}
+
+// AdminRole always grants every permission and cannot be changed.
+const AdminRole = "admin"
+
+// Role is a named set of permissions users are assigned. RequireTOTP makes a
+// second factor mandatory for its users.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	RequireTOTP bool
+	CreatedAt   time.Time
+}
+
+func hasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidPermission reports whether permission is one of Permissions.
+func ValidPermission(permission string) bool {
+	return hasPermission(Permissions, permission)
+}
+
+// Session is a family of refresh tokens started by one sign-in. Each refresh
+// replaces TokenId, the id of the only refresh token of the family that may
+// still be used.
+type Session struct {
+	Id         uuid.UUID
+	UserId     uuid.UUID
+	TokenId    string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Tokens of users who still have to set up a second factor are marked
+// TOTPEnrollment and come without a refresh token.
+type Tokens struct {
+	AccessToken    string
+	RefreshToken   string
+	ExpiresIn      time.Duration
+	TOTPEnrollment bool
+}
+
+// APIKey is a long-lived credential of a user. Hash is the SHA-256 of its
+// secret, which is only ever shown when the key is created. Its scopes are
+// permissions; a key grants those of them its owner has.
+type APIKey struct {
+	Id         uuid.UUID
+	UserId     uuid.UUID
+	Name       string
+	Hash       []byte
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// TOTP is the second factor of a user, a secret shared with an authenticator
+// app. It only counts once Confirmed, when the user has shown with a first
+// code that the app has it. LastStep is the time step of the last code
+// accepted, and RecoveryCodes are SHA-256 hashes of the single-use recovery
+// codes that are left.
+type TOTP struct {
+	UserId        uuid.UUID
+	Secret        []byte
+	Confirmed     bool
+	LastStep      uint64
+	RecoveryCodes [][]byte
+	CreatedAt     time.Time
+}
+
+// TOTPSetup is a new secret, shown once to be added to an authenticator app
+// by typing in Secret or scanning URI as a QR code.
+type TOTPSetup struct {
+	Secret string
+	URI    string
+}
+
+// TOTPStatus tells whether a user has a second factor and whether the roles
+// of the user require one.
+type TOTPStatus struct {
+	Enabled       bool
+	Required      bool
+	RecoveryCodes int
+}
+
+// TOTPOptions configure second factors. Issuer names the service in
+// authenticator apps, Skew is the number of periods codes may be early or
+// late, and users get RecoveryCodes recovery codes.
+type TOTPOptions struct {
+	Issuer        string
+	Skew          int
+	RecoveryCodes int
+}
+
+// The kinds of lockouts: failed sign-ins are counted per user, by id whatever
+// login they were made with, per login no user has and per client IP.
+const (
+	LockoutUser  = "user"
+	LockoutLogin = "login"
+	LockoutIP    = "ip"
+)
+
+// Lockout counts the recent failed sign-ins of a user, with a login or from a
+// client IP. Sign-ins are refused until LockedUntil, or for good if Permanent.
+// ExpiresAt is when the record can be forgotten and zero for permanent
+// lockouts.
+type Lockout struct {
+	Kind        string
+	Name        string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	Permanent   bool
+	ExpiresAt   time.Time
+}
+
+// Locked reports whether the lockout refuses sign-ins at now.
+func (l *Lockout) Locked(now time.Time) bool {
+	return l.Permanent || l.LockedUntil.After(now)
+}
+
+// LockoutOptions configure lockouts. From the Threshold-th failure of a user
+// or with a login, or the IPThreshold-th from a client IP, within Window on,
+// every failure locks out for BaseDelay, doubled with each failure up to
+// MaxDelay. Client IPs get a threshold of their own as many users may share
+// one. PermanentThreshold failures lock out a user or login until an admin
+// unlocks it; client IPs are never locked out for good. A zero threshold
+// disables its lockout.
+type LockoutOptions struct {
+	Threshold          int
+	IPThreshold        int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	Window             time.Duration
+	PermanentThreshold int
+}
@@ -1,7 +1,62 @@
 package auth
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var UserNotFoundError = errors.New("user not found")
 
+var InvalidCredentialsError = errors.New("invalid credentials")
+
+var InvalidTokenError = errors.New("invalid token")
+
+var SessionNotFoundError = errors.New("session not found")
+
+var TokenReusedError = errors.New("refresh token has already been used")
+
+var APIKeyNotFoundError = errors.New("api key not found")
+
+var InvalidScopeError = errors.New("invalid api key scope")
+
+var RoleNotFoundError = errors.New("role not found")
+
+var RoleAlreadyExistsError = errors.New("role already exists")
+
+var RoleInUseError = errors.New("role is assigned to users")
+
+var InvalidPermissionError = errors.New("invalid permission")
+
+var ReadOnlyRoleError = errors.New("role cannot be changed")
+
+var LockoutNotFoundError = errors.New("lockout not found")
+
+var EmailNotVerifiedError = errors.New("email not verified")
+
+var UserSuspendedError = errors.New("user suspended")
+
+var UserDeactivatedError = errors.New("user deactivated")
+
+var TOTPRequiredError = errors.New("second factor code required")
+
+var TOTPEnrollmentRequiredError = errors.New("second factor must be set up first")
+
+var TOTPNotFoundError = errors.New("second factor not set up")
+
+var TOTPAlreadyEnabledError = errors.New("second factor already set up")
+
+var InvalidTOTPCodeError = errors.New("invalid second factor code")
+
+// LockedError refuses a sign-in while its login or client IP is locked out.
+// Until is zero for permanent lockouts.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed sign-ins"
+}
+
 var UnknownError = errors.New("unknown error")
+
+var InvalidPolicyError = errors.New("invalid policy")
@@ -0,0 +1,49 @@
+package auth
+
+// Policy decides per record whether a caller may act on a user. Actions are
+// the permissions that allow them to every user, like users:write.
+type Policy interface {
+	Evaluate(request *AccessRequest) *Decision
+}
+
+// AccessRequest asks whether Subject may perform Action on Target, changing
+// Fields when the action is an update. Target is nil when the user does not
+// exist.
+type AccessRequest struct {
+	Subject *Identity
+	Action  string
+	Target  *User
+	Fields  []string
+}
+
+// The fields of a user an update can change.
+const (
+	FieldEmail    = "email"
+	FieldUsername = "username"
+	FieldPassword = "password"
+	FieldRoles    = "roles"
+)
+
+// Decision is the answer of a policy. Rule names the rule that decided and is
+// empty when no rule applied and the permissions of the subject decided.
+// Trace shows how each rule of the policy was evaluated.
+type Decision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+	Trace   []RuleTrace
+}
+
+type RuleTrace struct {
+	Rule   string
+	Effect string
+	// Applies reports whether the rule covers the action at all.
+	Applies    bool
+	Matched    bool
+	Conditions []ConditionTrace
+}
+
+type ConditionTrace struct {
+	Condition string
+	Matched   bool
+}
@@ -0,0 +1,344 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/omelaymy/users/internal/auth"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+const (
+	OperatorEquals       = "equals"
+	OperatorNotEquals    = "notEquals"
+	OperatorContainsAny  = "containsAny"
+	OperatorContainsNone = "containsNone"
+)
+
+// Rule allows or denies its actions when all of its conditions match.
+type Rule struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Effect      string      `yaml:"effect"`
+	Actions     []string    `yaml:"actions"`
+	Conditions  []Condition `yaml:"conditions"`
+}
+
+// Condition compares an attribute with Values, or with the attribute Ref
+// names. Every attribute is a list of strings; single values are lists of
+// one.
+type Condition struct {
+	Attribute string `yaml:"attribute"`
+	Operator  string `yaml:"operator"`
+	Values    List   `yaml:"values"`
+	Ref       string `yaml:"ref"`
+}
+
+// List is a list of strings that can be written as a single string in YAML.
+type List []string
+
+func (l *List) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = List{node.Value}
+		return nil
+	}
+
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+
+	return nil
+}
+
+type attribute func(request *auth.AccessRequest) ([]string, bool)
+
+// attributes resolve the attributes conditions can use. Target attributes
+// are missing for users that do not exist, and conditions on missing
+// attributes never match.
+var attributes = map[string]attribute{
+	"subject.id": func(r *auth.AccessRequest) ([]string, bool) {
+		return []string{r.Subject.UserId.String()}, true
+	},
+	"subject.roles": func(r *auth.AccessRequest) ([]string, bool) {
+		return r.Subject.Roles, true
+	},
+	"subject.permissions": func(r *auth.AccessRequest) ([]string, bool) {
+		return r.Subject.Permissions, true
+	},
+	"target.id": func(r *auth.AccessRequest) ([]string, bool) {
+		if r.Target == nil {
+			return nil, false
+		}
+		return []string{r.Target.Id.String()}, true
+	},
+	"target.username": func(r *auth.AccessRequest) ([]string, bool) {
+		if r.Target == nil {
+			return nil, false
+		}
+		return []string{r.Target.Username}, true
+	},
+	"target.email": func(r *auth.AccessRequest) ([]string, bool) {
+		if r.Target == nil {
+			return nil, false
+		}
+		return []string{r.Target.Email}, true
+	},
+	"target.roles": func(r *auth.AccessRequest) ([]string, bool) {
+		if r.Target == nil {
+			return nil, false
+		}
+		return r.Target.Roles, true
+	},
+	"target.serviceAccount": func(r *auth.AccessRequest) ([]string, bool) {
+		if r.Target == nil {
+			return nil, false
+		}
+		return []string{strconv.FormatBool(r.Target.ServiceAccount)}, true
+	},
+	"fields": func(r *auth.AccessRequest) ([]string, bool) {
+		return r.Fields, true
+	},
+}
+
+// Engine evaluates the rules of a policy. A matching deny rule wins over
+// matching allow rules, and when no rule matches the permissions of the
+// subject decide. An API key never allows actions outside its scopes.
+type Engine struct {
+	rules []Rule
+}
+
+type file struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Load reads a policy file. An empty path gives a policy without rules.
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return New(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %s", auth.InvalidPolicyError, err)
+	}
+
+	return New(f.Rules)
+}
+
+func New(rules []Rule) (*Engine, error) {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%w: rule %q: %s", auth.InvalidPolicyError, rule.Name, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: rule %q is defined twice", auth.InvalidPolicyError, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return &Engine{rules: rules}, nil
+}
+
+func (e *Engine) Evaluate(request *auth.AccessRequest) *auth.Decision {
+	if request.Subject == nil {
+		request.Subject = &auth.Identity{}
+	}
+
+	decision := &auth.Decision{Trace: make([]auth.RuleTrace, len(e.rules))}
+	var allow, deny *Rule
+	for i := range e.rules {
+		rule := &e.rules[i]
+		decision.Trace[i] = rule.evaluate(request)
+		if !decision.Trace[i].Matched {
+			continue
+		}
+		if rule.Effect == EffectDeny && deny == nil {
+			deny = rule
+		}
+		if rule.Effect == EffectAllow && allow == nil {
+			allow = rule
+		}
+	}
+
+	subject := request.Subject
+	switch {
+	case subject.Scopes != nil && !contains(subject.Scopes, request.Action):
+		decision.Reason = fmt.Sprintf("the api key does not have the %s scope", request.Action)
+	case deny != nil:
+		decision.Rule = deny.Name
+		decision.Reason = deny.reason()
+	case allow != nil:
+		decision.Allowed = true
+		decision.Rule = allow.Name
+		decision.Reason = allow.reason()
+	case subject.Can(request.Action):
+		decision.Allowed = true
+		decision.Reason = fmt.Sprintf("no rule matched and the subject has the %s permission", request.Action)
+	default:
+		decision.Reason = fmt.Sprintf("no rule matched and the subject does not have the %s permission", request.Action)
+	}
+
+	return decision
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Effect != EffectAllow && r.Effect != EffectDeny {
+		return fmt.Errorf("effect must be %s or %s", EffectAllow, EffectDeny)
+	}
+	if len(r.Actions) == 0 {
+		return errors.New("actions are required")
+	}
+	for _, action := range r.Actions {
+		if !auth.ValidPermission(action) {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+
+	for _, condition := range r.Conditions {
+		if _, ok := attributes[condition.Attribute]; !ok {
+			return fmt.Errorf("unknown attribute %q", condition.Attribute)
+		}
+		switch condition.Operator {
+		case OperatorEquals, OperatorNotEquals, OperatorContainsAny, OperatorContainsNone:
+		default:
+			return fmt.Errorf("unknown operator %q", condition.Operator)
+		}
+		if condition.Ref != "" {
+			if _, ok := attributes[condition.Ref]; !ok {
+				return fmt.Errorf("unknown attribute %q", condition.Ref)
+			}
+			if condition.Values != nil {
+				return errors.New("a condition has either values or a ref")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) evaluate(request *auth.AccessRequest) auth.RuleTrace {
+	trace := auth.RuleTrace{
+		Rule:    r.Name,
+		Effect:  r.Effect,
+		Applies: contains(r.Actions, request.Action),
+	}
+	if !trace.Applies {
+		return trace
+	}
+
+	trace.Matched = true
+	trace.Conditions = make([]auth.ConditionTrace, len(r.Conditions))
+	for i, condition := range r.Conditions {
+		matched := condition.match(request)
+		trace.Conditions[i] = auth.ConditionTrace{
+			Condition: condition.String(),
+			Matched:   matched,
+		}
+		trace.Matched = trace.Matched && matched
+	}
+
+	return trace
+}
+
+func (r *Rule) reason() string {
+	if r.Description != "" {
+		return r.Description
+	}
+
+	return fmt.Sprintf("rule %s matched", r.Name)
+}
+
+func (c *Condition) match(request *auth.AccessRequest) bool {
+	actual, ok := attributes[c.Attribute](request)
+	if !ok {
+		return false
+	}
+
+	expected := []string(c.Values)
+	if c.Ref != "" {
+		if expected, ok = attributes[c.Ref](request); !ok {
+			return false
+		}
+	}
+
+	switch c.Operator {
+	case OperatorEquals:
+		return sameSet(actual, expected)
+	case OperatorNotEquals:
+		return !sameSet(actual, expected)
+	case OperatorContainsAny:
+		return containsAny(actual, expected)
+	case OperatorContainsNone:
+		return !containsAny(actual, expected)
+	}
+
+	return false
+}
+
+func (c *Condition) String() string {
+	if c.Ref != "" {
+		return fmt.Sprintf("%s %s %s", c.Attribute, c.Operator, c.Ref)
+	}
+
+	return fmt.Sprintf("%s %s [%s]", c.Attribute, c.Operator, strings.Join(c.Values, ", "))
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(list, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameSet compares two lists ignoring their order and duplicates.
+func sameSet(a, b []string) bool {
+	for _, v := range a {
+		if !contains(b, v) {
+			return false
+		}
+	}
+	for _, v := range b {
+		if !contains(a, v) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,165 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/auth/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	engine, err := policy.Load("../../../config/policy.yml")
+	require.NoError(t, err)
+
+	user := &auth.Identity{UserId: uuid.New(), Roles: []string{"user"}, Permissions: []string{auth.PermissionUsersRead}}
+	helpdesk := &auth.Identity{
+		UserId:      uuid.New(),
+		Roles:       []string{"helpdesk"},
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersResetPassword},
+	}
+	admin := &auth.Identity{UserId: uuid.New(), Roles: []string{auth.AdminRole}, Permissions: auth.Permissions}
+
+	self := &auth.User{Id: user.UserId, Roles: user.Roles}
+	other := &auth.User{Id: uuid.New(), Roles: []string{"user"}}
+	adminUser := &auth.User{Id: admin.UserId, Roles: admin.Roles}
+
+	tests := []struct {
+		name    string
+		request auth.AccessRequest
+		allowed bool
+		rule    string
+	}{
+		{"user reads self", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersRead, Target: self}, true, "own-profile"},
+		{"user updates own email", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersWrite, Target: self, Fields: []string{auth.FieldEmail}}, true, "own-profile"},
+		{"user changes own roles", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersWrite, Target: self, Fields: []string{auth.FieldRoles}}, false, "own-roles"},
+		{"user changes own password", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersWrite, Target: self, Fields: []string{auth.FieldEmail, auth.FieldPassword}}, false, "own-password"},
+		{"user updates another user", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersWrite, Target: other}, false, ""},
+		{"user deletes self", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersDelete, Target: self}, false, ""},
+		{"user reads a missing user", auth.AccessRequest{Subject: user, Action: auth.PermissionUsersRead}, true, ""},
+		{"helpdesk edits a user", auth.AccessRequest{Subject: helpdesk, Action: auth.PermissionUsersWrite, Target: other}, true, "helpdesk-edits-users"},
+		{"helpdesk edits an admin", auth.AccessRequest{Subject: helpdesk, Action: auth.PermissionUsersWrite, Target: adminUser}, false, "helpdesk-not-admins"},
+		{"helpdesk resets a password", auth.AccessRequest{Subject: helpdesk, Action: auth.PermissionUsersResetPassword, Target: other}, true, ""},
+		{"helpdesk resets an admin password", auth.AccessRequest{Subject: helpdesk, Action: auth.PermissionUsersResetPassword, Target: adminUser}, false, "helpdesk-not-admins"},
+		{"helpdesk edits a missing user", auth.AccessRequest{Subject: helpdesk, Action: auth.PermissionUsersWrite}, false, ""},
+		{"admin edits a user", auth.AccessRequest{Subject: admin, Action: auth.PermissionUsersWrite, Target: other, Fields: []string{auth.FieldRoles}}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(&tt.request)
+			assert.Equal(t, tt.allowed, decision.Allowed, decision.Reason)
+			assert.Equal(t, tt.rule, decision.Rule)
+			assert.NotEmpty(t, decision.Reason)
+		})
+	}
+}
+
+func TestScopes(t *testing.T) {
+	engine, err := policy.New([]policy.Rule{
+		{
+			Name:    "own-profile",
+			Effect:  policy.EffectAllow,
+			Actions: []string{auth.PermissionUsersWrite},
+			Conditions: []policy.Condition{
+				{Attribute: "subject.id", Operator: policy.OperatorEquals, Ref: "target.id"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	key := &auth.Identity{UserId: uuid.New(), Scopes: []string{auth.PermissionUsersRead}}
+	decision := engine.Evaluate(&auth.AccessRequest{
+		Subject: key,
+		Action:  auth.PermissionUsersWrite,
+		Target:  &auth.User{Id: key.UserId},
+	})
+	assert.False(t, decision.Allowed)
+	assert.Empty(t, decision.Rule)
+	require.Len(t, decision.Trace, 1)
+	assert.True(t, decision.Trace[0].Matched)
+
+	key.Scopes = append(key.Scopes, auth.PermissionUsersWrite)
+	decision = engine.Evaluate(&auth.AccessRequest{
+		Subject: key,
+		Action:  auth.PermissionUsersWrite,
+		Target:  &auth.User{Id: key.UserId},
+	})
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "own-profile", decision.Rule)
+}
+
+func TestTrace(t *testing.T) {
+	engine, err := policy.New([]policy.Rule{
+		{
+			Name:    "no-service-accounts",
+			Effect:  policy.EffectDeny,
+			Actions: []string{auth.PermissionUsersDelete},
+			Conditions: []policy.Condition{
+				{Attribute: "target.serviceAccount", Operator: policy.OperatorEquals, Values: policy.List{"true"}},
+			},
+		},
+		{
+			Name:    "reads",
+			Effect:  policy.EffectAllow,
+			Actions: []string{auth.PermissionUsersRead},
+		},
+	})
+	require.NoError(t, err)
+
+	decision := engine.Evaluate(&auth.AccessRequest{
+		Subject: &auth.Identity{Permissions: []string{auth.PermissionUsersDelete}},
+		Action:  auth.PermissionUsersDelete,
+		Target:  &auth.User{ServiceAccount: true},
+	})
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "rule no-service-accounts matched", decision.Reason)
+	assert.Equal(t, []auth.RuleTrace{
+		{
+			Rule:    "no-service-accounts",
+			Effect:  policy.EffectDeny,
+			Applies: true,
+			Matched: true,
+			Conditions: []auth.ConditionTrace{
+				{Condition: "target.serviceAccount equals [true]", Matched: true},
+			},
+		},
+		{Rule: "reads", Effect: policy.EffectAllow},
+	}, decision.Trace)
+}
+
+func TestLoad(t *testing.T) {
+	engine, err := policy.Load("")
+	require.NoError(t, err)
+	assert.True(t, engine.Evaluate(&auth.AccessRequest{
+		Subject: &auth.Identity{Permissions: []string{auth.PermissionUsersRead}},
+		Action:  auth.PermissionUsersRead,
+	}).Allowed)
+
+	invalid := map[string]string{
+		"unknown field":     "rules:\n  - name: a\n    effect: allow\n    actions: [users:read]\n    when: []\n",
+		"missing name":      "rules:\n  - effect: allow\n    actions: [users:read]\n",
+		"unknown effect":    "rules:\n  - name: a\n    effect: maybe\n    actions: [users:read]\n",
+		"missing actions":   "rules:\n  - name: a\n    effect: allow\n",
+		"unknown action":    "rules:\n  - name: a\n    effect: allow\n    actions: [users:fly]\n",
+		"unknown attribute": "rules:\n  - name: a\n    effect: allow\n    actions: [users:read]\n    conditions:\n      - {attribute: subject.age, operator: equals, values: 1}\n",
+		"unknown operator":  "rules:\n  - name: a\n    effect: allow\n    actions: [users:read]\n    conditions:\n      - {attribute: subject.id, operator: like, values: a}\n",
+		"unknown ref":       "rules:\n  - name: a\n    effect: allow\n    actions: [users:read]\n    conditions:\n      - {attribute: subject.id, operator: equals, ref: target.age}\n",
+		"values and ref":    "rules:\n  - name: a\n    effect: allow\n    actions: [users:read]\n    conditions:\n      - {attribute: subject.id, operator: equals, ref: target.id, values: a}\n",
+		"duplicate name":    "rules:\n  - {name: a, effect: allow, actions: [users:read]}\n  - {name: a, effect: deny, actions: [users:read]}\n",
+	}
+	dir := t.TempDir()
+	for name, content := range invalid {
+		path := filepath.Join(dir, "policy.yml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		_, err = policy.Load(path)
+		assert.ErrorIs(t, err, auth.InvalidPolicyError, name)
+	}
+
+	_, err = policy.Load(filepath.Join(dir, "missing.yml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,5 +1,54 @@
 package auth
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type Repository interface {
+	GetUserById(id uuid.UUID) (*User, error)
 	GetUserByUsername(username string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	// UpdatePasswordHash replaces the password hash of a user if it is still
+	// oldHash, and does nothing otherwise.
+	UpdatePasswordHash(userId uuid.UUID, oldHash, newHash string) error
+	CreateAPIKey(key *APIKey) (uuid.UUID, error)
+	GetAPIKey(id uuid.UUID) (*APIKey, error)
+	GetUserAPIKeys(userId uuid.UUID) ([]*APIKey, error)
+	TouchAPIKey(id uuid.UUID, usedAt time.Time) error
+	DeleteAPIKey(id uuid.UUID) error
+	CreateRole(role *Role) error
+	GetRole(name string) (*Role, error)
+	GetRoles() ([]*Role, error)
+	UpdateRole(role *Role) error
+	DeleteRole(name string) error
+	GetTOTP(userId uuid.UUID) (*TOTP, error)
+	// UpdateTOTP applies update to the second factor of a user, or to a new
+	// one, and stores the result atomically. Nothing is stored if update
+	// fails.
+	UpdateTOTP(userId uuid.UUID, update func(totp *TOTP) error) error
+	DeleteTOTP(userId uuid.UUID) error
+}
+
+type SessionRepository interface {
+	CreateSession(session *Session) error
+	GetSession(id uuid.UUID) (*Session, error)
+	GetUserSessions(userId uuid.UUID) ([]*Session, error)
+	// RotateSession replaces the token id of a session if it is still
+	// tokenId, and fails with TokenReusedError otherwise.
+	RotateSession(id uuid.UUID, tokenId, newTokenId string, expiresAt time.Time) error
+	DeleteSession(id uuid.UUID) error
+	DeleteUserSessions(userId uuid.UUID) error
+}
+
+// LockoutRepository keeps lockouts. Records past their ExpiresAt are treated
+// as missing.
+type LockoutRepository interface {
+	GetLockout(kind, name string) (*Lockout, error)
+	GetLockouts() ([]*Lockout, error)
+	// UpdateLockout applies update to the lockout of kind and name, or to a
+	// new one without failures, and stores the result atomically.
+	UpdateLockout(kind, name string, update func(lockout *Lockout)) (*Lockout, error)
+	DeleteLockout(kind, name string) error
 }
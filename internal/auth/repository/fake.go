@@ -1,9 +1,18 @@
 package repository
 
-import "github.com/omelaymy/users/internal/auth"
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/auth"
+)
 
 type FakeRepository struct {
 	users map[string]*auth.User
+	keys  map[uuid.UUID]*auth.APIKey
+	roles map[string]*auth.Role
+	totps map[uuid.UUID]*auth.TOTP
 }
 
 func NewFakeRepository(
@@ -11,9 +20,26 @@ func NewFakeRepository(
 ) *FakeRepository {
 	return &FakeRepository{
 		users: users,
+		keys:  make(map[uuid.UUID]*auth.APIKey),
+		roles: make(map[string]*auth.Role),
+		totps: make(map[uuid.UUID]*auth.TOTP),
 	}
 }
 
+// GetUserById returns a copy of the user without its password, like
+// AuthRepository.
+func (f *FakeRepository) GetUserById(id uuid.UUID) (*auth.User, error) {
+	for _, user := range f.users {
+		if user.Id == id {
+			copied := *user
+			copied.Password = ""
+			return &copied, nil
+		}
+	}
+
+	return nil, auth.UserNotFoundError
+}
+
 func (f *FakeRepository) GetUserByUsername(username string) (*auth.User, error) {
 	user, ok := f.users[username]
 	if !ok {
@@ -21,3 +47,184 @@ func (f *FakeRepository) GetUserByUsername(username string) (*auth.User, error)
 	}
 	return user, nil
 }
+
+func (f *FakeRepository) GetUserByEmail(email string) (*auth.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, auth.UserNotFoundError
+}
+
+func (f *FakeRepository) UpdatePasswordHash(userId uuid.UUID, oldHash, newHash string) error {
+	for _, user := range f.users {
+		if user.Id == userId {
+			if user.Password == oldHash {
+				user.Password = newHash
+			}
+			return nil
+		}
+	}
+
+	return auth.UserNotFoundError
+}
+
+func (f *FakeRepository) CreateAPIKey(key *auth.APIKey) (uuid.UUID, error) {
+	if _, err := f.GetUserById(key.UserId); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	stored := *key
+	stored.Id = uuid.New()
+	stored.CreatedAt = time.Now()
+	f.keys[stored.Id] = &stored
+
+	return stored.Id, nil
+}
+
+func (f *FakeRepository) GetAPIKey(id uuid.UUID) (*auth.APIKey, error) {
+	key, ok := f.keys[id]
+	if !ok {
+		return nil, auth.APIKeyNotFoundError
+	}
+
+	copied := *key
+	return &copied, nil
+}
+
+func (f *FakeRepository) GetUserAPIKeys(userId uuid.UUID) ([]*auth.APIKey, error) {
+	var res []*auth.APIKey
+	for _, key := range f.keys {
+		if key.UserId == userId {
+			copied := *key
+			res = append(res, &copied)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+func (f *FakeRepository) TouchAPIKey(id uuid.UUID, usedAt time.Time) error {
+	key, ok := f.keys[id]
+	if !ok {
+		return auth.APIKeyNotFoundError
+	}
+	key.LastUsedAt = usedAt
+
+	return nil
+}
+
+func (f *FakeRepository) DeleteAPIKey(id uuid.UUID) error {
+	if _, ok := f.keys[id]; !ok {
+		return auth.APIKeyNotFoundError
+	}
+	delete(f.keys, id)
+
+	return nil
+}
+
+func (f *FakeRepository) CreateRole(role *auth.Role) error {
+	if _, ok := f.roles[role.Name]; ok {
+		return auth.RoleAlreadyExistsError
+	}
+
+	stored := *role
+	stored.CreatedAt = time.Now()
+	f.roles[role.Name] = &stored
+
+	return nil
+}
+
+func (f *FakeRepository) GetRole(name string) (*auth.Role, error) {
+	role, ok := f.roles[name]
+	if !ok {
+		return nil, auth.RoleNotFoundError
+	}
+
+	copied := *role
+	return &copied, nil
+}
+
+func (f *FakeRepository) GetRoles() ([]*auth.Role, error) {
+	res := make([]*auth.Role, 0, len(f.roles))
+	for _, role := range f.roles {
+		copied := *role
+		res = append(res, &copied)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+func (f *FakeRepository) UpdateRole(role *auth.Role) error {
+	existing, ok := f.roles[role.Name]
+	if !ok {
+		return auth.RoleNotFoundError
+	}
+
+	stored := *role
+	stored.CreatedAt = existing.CreatedAt
+	f.roles[role.Name] = &stored
+
+	return nil
+}
+
+func (f *FakeRepository) DeleteRole(name string) error {
+	if _, ok := f.roles[name]; !ok {
+		return auth.RoleNotFoundError
+	}
+	for _, user := range f.users {
+		for _, role := range user.Roles {
+			if role == name {
+				return auth.RoleInUseError
+			}
+		}
+	}
+	delete(f.roles, name)
+
+	return nil
+}
+
+func (f *FakeRepository) GetTOTP(userId uuid.UUID) (*auth.TOTP, error) {
+	totp, ok := f.totps[userId]
+	if !ok {
+		return nil, auth.TOTPNotFoundError
+	}
+
+	res := *totp
+	return &res, nil
+}
+
+func (f *FakeRepository) UpdateTOTP(userId uuid.UUID, update func(totp *auth.TOTP) error) error {
+	if _, err := f.GetUserById(userId); err != nil {
+		return err
+	}
+
+	totp := auth.TOTP{UserId: userId, CreatedAt: time.Now()}
+	if existing, ok := f.totps[userId]; ok {
+		totp = *existing
+		totp.RecoveryCodes = append([][]byte(nil), existing.RecoveryCodes...)
+	}
+	if err := update(&totp); err != nil {
+		return err
+	}
+	f.totps[userId] = &totp
+
+	return nil
+}
+
+func (f *FakeRepository) DeleteTOTP(userId uuid.UUID) error {
+	if _, ok := f.totps[userId]; !ok {
+		return auth.TOTPNotFoundError
+	}
+	delete(f.totps, userId)
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// minLockoutSweep is the number of lockouts below which expired ones are
+// left to be dropped when they are read.
+const minLockoutSweep = 1024
+
+type lockoutKey struct {
+	kind string
+	name string
+}
+
+// LockoutRepository keeps lockouts in memory, so a restart unlocks everybody.
+type LockoutRepository struct {
+	mu       sync.Mutex
+	lockouts map[lockoutKey]*auth.Lockout
+	sweepAt  int
+}
+
+func NewLockoutRepository() *LockoutRepository {
+	return &LockoutRepository{
+		lockouts: make(map[lockoutKey]*auth.Lockout),
+		sweepAt:  minLockoutSweep,
+	}
+}
+
+func (r *LockoutRepository) GetLockout(kind, name string) (*auth.Lockout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lockout, ok := r.get(lockoutKey{kind, name}, time.Now())
+	if !ok {
+		return nil, auth.LockoutNotFoundError
+	}
+
+	res := *lockout
+	return &res, nil
+}
+
+// GetLockouts returns the lockouts ordered by kind and name.
+func (r *LockoutRepository) GetLockouts() ([]*auth.Lockout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(time.Now())
+
+	res := make([]*auth.Lockout, 0, len(r.lockouts))
+	for _, lockout := range r.lockouts {
+		stored := *lockout
+		res = append(res, &stored)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+func (r *LockoutRepository) UpdateLockout(kind, name string, update func(lockout *auth.Lockout)) (*auth.Lockout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	key := lockoutKey{kind, name}
+	lockout, ok := r.get(key, now)
+	if !ok {
+		lockout = &auth.Lockout{Kind: kind, Name: name}
+	}
+
+	updated := *lockout
+	update(&updated)
+	r.lockouts[key] = &updated
+
+	// Failures from many client IPs or with many logins would otherwise pile
+	// up; sweeping whenever the map has doubled keeps the cost amortized.
+	if len(r.lockouts) >= r.sweepAt {
+		r.sweep(now)
+		r.sweepAt = 2 * len(r.lockouts)
+		if r.sweepAt < minLockoutSweep {
+			r.sweepAt = minLockoutSweep
+		}
+	}
+
+	res := updated
+	return &res, nil
+}
+
+func (r *LockoutRepository) DeleteLockout(kind, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := lockoutKey{kind, name}
+	if _, ok := r.get(key, time.Now()); !ok {
+		return auth.LockoutNotFoundError
+	}
+	delete(r.lockouts, key)
+
+	return nil
+}
+
+func (r *LockoutRepository) get(key lockoutKey, now time.Time) (*auth.Lockout, bool) {
+	lockout, ok := r.lockouts[key]
+	if !ok {
+		return nil, false
+	}
+	if expired(lockout, now) {
+		delete(r.lockouts, key)
+		return nil, false
+	}
+
+	return lockout, true
+}
+
+func (r *LockoutRepository) sweep(now time.Time) {
+	for key, lockout := range r.lockouts {
+		if expired(lockout, now) {
+			delete(r.lockouts, key)
+		}
+	}
+}
+
+func expired(lockout *auth.Lockout, now time.Time) bool {
+	return !lockout.ExpiresAt.IsZero() && !lockout.ExpiresAt.After(now)
+}
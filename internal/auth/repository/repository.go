@@ -2,27 +2,48 @@ package repository
 
 import (
 	"errors"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/omelaymy/users/internal/auth"
 	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/omelaymy/users/pkg/secure"
 	"github.com/rs/zerolog"
 )
 
+// AuthRepository stores the secrets of second factors encrypted with cipher,
+// bound to the id of their user.
 type AuthRepository struct {
-	db  *inmemory.InMemoryDatabase
-	log *zerolog.Logger
+	db     *inmemory.InMemoryDatabase
+	cipher *secure.Cipher
+	log    *zerolog.Logger
 }
 
 func NewAuthRepository(
 	db *inmemory.InMemoryDatabase,
+	cipher *secure.Cipher,
 	log *zerolog.Logger,
 ) *AuthRepository {
 	return &AuthRepository{
-		db:  db,
-		log: log,
+		db:     db,
+		cipher: cipher,
+		log:    log,
 	}
 }
 
+func (r *AuthRepository) GetUserById(id uuid.UUID) (*auth.User, error) {
+	user, err := r.db.GetUserById(id)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, auth.UserNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get user")
+		return nil, auth.UnknownError
+	}
+
+	return castUserFromDB(user), nil
+}
+
 func (r *AuthRepository) GetUserByUsername(username string) (*auth.User, error) {
 	user, err := r.db.GetUserByUsername(username)
 	if err != nil {
@@ -33,9 +54,330 @@ func (r *AuthRepository) GetUserByUsername(username string) (*auth.User, error)
 		return nil, auth.UnknownError
 	}
 
-	return &auth.User{
-		Username: user.Username,
-		Password: user.Password,
-		Admin:    user.Admin,
+	return castUserFromDB(user), nil
+}
+
+func (r *AuthRepository) GetUserByEmail(email string) (*auth.User, error) {
+	user, err := r.db.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, auth.UserNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get user")
+		return nil, auth.UnknownError
+	}
+
+	return castUserFromDB(user), nil
+}
+
+func (r *AuthRepository) UpdatePasswordHash(userId uuid.UUID, oldHash, newHash string) error {
+	user, err := r.db.GetUserById(userId)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.UserNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get user")
+		return auth.UnknownError
+	}
+	// Lookups by id leave the password out.
+	user, err = r.db.GetUserByUsername(user.Username)
+	if err != nil || user.ID != userId || user.Password != oldHash {
+		return nil
+	}
+
+	// The version makes the update fail rather than revert a concurrent
+	// change of the user, which may have set another password.
+	user.Password = newHash
+	if err = r.db.UpdateUser(user); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) || errors.Is(err, inmemory.VersionMismatchError) {
+			return nil
+		}
+		r.log.Err(err).Msg("failed to update password hash")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) CreateAPIKey(key *auth.APIKey) (uuid.UUID, error) {
+	id, err := r.db.InsertAPIKey(inmemory.APIKey{
+		UserID:    key.UserId,
+		Name:      key.Name,
+		Hash:      key.Hash,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return uuid.UUID{}, auth.UserNotFoundError
+		}
+		r.log.Err(err).Msg("failed to create api key")
+		return uuid.UUID{}, auth.UnknownError
+	}
+
+	return id, nil
+}
+
+func (r *AuthRepository) GetAPIKey(id uuid.UUID) (*auth.APIKey, error) {
+	key, err := r.db.GetAPIKey(id)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, auth.APIKeyNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get api key")
+		return nil, auth.UnknownError
+	}
+
+	return castAPIKeyFromDB(key), nil
+}
+
+func (r *AuthRepository) GetUserAPIKeys(userId uuid.UUID) ([]*auth.APIKey, error) {
+	keys := r.db.GetUserAPIKeys(userId)
+
+	res := make([]*auth.APIKey, len(keys))
+	for i, key := range keys {
+		res[i] = castAPIKeyFromDB(key)
+	}
+
+	return res, nil
+}
+
+func (r *AuthRepository) TouchAPIKey(id uuid.UUID, usedAt time.Time) error {
+	if err := r.db.TouchAPIKey(id, usedAt); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.APIKeyNotFoundError
+		}
+		r.log.Err(err).Msg("failed to touch api key")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) DeleteAPIKey(id uuid.UUID) error {
+	if err := r.db.DeleteAPIKey(id); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.APIKeyNotFoundError
+		}
+		r.log.Err(err).Msg("failed to delete api key")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) CreateRole(role *auth.Role) error {
+	err := r.db.InsertRole(castRoleToDB(role))
+	if err != nil {
+		if errors.Is(err, inmemory.AlreadyExistsError) {
+			return auth.RoleAlreadyExistsError
+		}
+		r.log.Err(err).Msg("failed to create role")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) GetRole(name string) (*auth.Role, error) {
+	role, err := r.db.GetRole(name)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, auth.RoleNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get role")
+		return nil, auth.UnknownError
+	}
+
+	return castRoleFromDB(role), nil
+}
+
+func (r *AuthRepository) GetRoles() ([]*auth.Role, error) {
+	roles := r.db.GetRoles()
+
+	res := make([]*auth.Role, len(roles))
+	for i, role := range roles {
+		res[i] = castRoleFromDB(role)
+	}
+
+	return res, nil
+}
+
+func (r *AuthRepository) UpdateRole(role *auth.Role) error {
+	if err := r.db.UpdateRole(castRoleToDB(role)); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.RoleNotFoundError
+		}
+		r.log.Err(err).Msg("failed to update role")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) DeleteRole(name string) error {
+	if err := r.db.DeleteRole(name); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.RoleNotFoundError
+		}
+		if errors.Is(err, inmemory.RoleInUseError) {
+			return auth.RoleInUseError
+		}
+		r.log.Err(err).Msg("failed to delete role")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) GetTOTP(userId uuid.UUID) (*auth.TOTP, error) {
+	totp, err := r.db.GetTOTP(userId)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, auth.TOTPNotFoundError
+		}
+		r.log.Err(err).Msg("failed to get second factor")
+		return nil, auth.UnknownError
+	}
+
+	res, err := r.castTOTPFromDB(totp)
+	if err != nil {
+		r.log.Err(err).Msg("failed to decrypt second factor")
+		return nil, auth.UnknownError
+	}
+
+	return res, nil
+}
+
+func (r *AuthRepository) UpdateTOTP(userId uuid.UUID, update func(totp *auth.TOTP) error) error {
+	var updateErr error
+	err := r.db.UpdateTOTP(userId, func(stored *inmemory.TOTP) error {
+		totp, err := r.castTOTPFromDB(*stored)
+		if err != nil {
+			return err
+		}
+		if updateErr = update(totp); updateErr != nil {
+			return updateErr
+		}
+
+		*stored, err = r.castTOTPToDB(totp)
+		return err
+	})
+	if err != nil {
+		if updateErr != nil {
+			return updateErr
+		}
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.UserNotFoundError
+		}
+		r.log.Err(err).Msg("failed to update second factor")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) DeleteTOTP(userId uuid.UUID) error {
+	if err := r.db.DeleteTOTP(userId); err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return auth.TOTPNotFoundError
+		}
+		r.log.Err(err).Msg("failed to delete second factor")
+		return auth.UnknownError
+	}
+
+	return nil
+}
+
+// castTOTPFromDB decrypts the secret, which a second factor that was just
+// created does not have yet.
+func (r *AuthRepository) castTOTPFromDB(totp inmemory.TOTP) (*auth.TOTP, error) {
+	res := &auth.TOTP{
+		UserId:        totp.UserID,
+		Confirmed:     totp.Confirmed,
+		LastStep:      totp.LastStep,
+		RecoveryCodes: totp.RecoveryCodes,
+		CreatedAt:     totp.CreatedAt,
+	}
+	if len(totp.Secret) > 0 {
+		secret, err := r.cipher.Decrypt(totp.Secret, totp.UserID[:])
+		if err != nil {
+			return nil, err
+		}
+		res.Secret = secret
+	}
+
+	return res, nil
+}
+
+func (r *AuthRepository) castTOTPToDB(totp *auth.TOTP) (inmemory.TOTP, error) {
+	secret, err := r.cipher.Encrypt(totp.Secret, totp.UserId[:])
+	if err != nil {
+		return inmemory.TOTP{}, err
+	}
+
+	return inmemory.TOTP{
+		UserID:        totp.UserId,
+		Secret:        secret,
+		Confirmed:     totp.Confirmed,
+		LastStep:      totp.LastStep,
+		RecoveryCodes: totp.RecoveryCodes,
+		CreatedAt:     totp.CreatedAt,
 	}, nil
 }
+
+func castRoleToDB(role *auth.Role) inmemory.Role {
+	return inmemory.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+		RequireTOTP: role.RequireTOTP,
+	}
+}
+
+func castRoleFromDB(role inmemory.Role) *auth.Role {
+	return &auth.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+		RequireTOTP: role.RequireTOTP,
+		CreatedAt:   role.CreatedAt,
+	}
+}
+
+func castAPIKeyFromDB(key inmemory.APIKey) *auth.APIKey {
+	return &auth.APIKey{
+		Id:         key.ID,
+		UserId:     key.UserID,
+		Name:       key.Name,
+		Hash:       key.Hash,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+func castUserFromDB(user inmemory.User) *auth.User {
+	return &auth.User{
+		Id:             user.ID,
+		Email:          user.Email,
+		Username:       user.Username,
+		Password:       user.Password,
+		Roles:          user.Roles,
+		ServiceAccount: user.ServiceAccount,
+		EmailVerified:  user.EmailVerified,
+		Pending:        user.Pending,
+		Status:         statusFromDB(user.Status),
+		SuspendedUntil: user.SuspendedUntil,
+	}
+}
+
+// Active users are stored without a status.
+func statusFromDB(status string) string {
+	if status == "" {
+		return auth.StatusActive
+	}
+
+	return status
+}
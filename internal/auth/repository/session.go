@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/auth"
+)
+
+// SessionRepository keeps sessions in memory. They are lost on restart, which
+// signs everybody out once their access tokens expire.
+type SessionRepository struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*auth.Session
+	byUser   map[uuid.UUID]map[uuid.UUID]struct{}
+}
+
+func NewSessionRepository() *SessionRepository {
+	return &SessionRepository{
+		sessions: make(map[uuid.UUID]*auth.Session),
+		byUser:   make(map[uuid.UUID]map[uuid.UUID]struct{}),
+	}
+}
+
+func (r *SessionRepository) CreateSession(session *auth.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Expired sessions of the user are dropped here, so they do not pile up
+	// for users who sign in often.
+	r.deleteExpired(session.UserId, time.Now())
+
+	stored := *session
+	r.sessions[session.Id] = &stored
+	if r.byUser[session.UserId] == nil {
+		r.byUser[session.UserId] = make(map[uuid.UUID]struct{})
+	}
+	r.byUser[session.UserId][session.Id] = struct{}{}
+
+	return nil
+}
+
+func (r *SessionRepository) GetSession(id uuid.UUID) (*auth.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, auth.SessionNotFoundError
+	}
+
+	res := *session
+	return &res, nil
+}
+
+// GetUserSessions returns the unexpired sessions of a user, oldest first.
+func (r *SessionRepository) GetUserSessions(userId uuid.UUID) ([]*auth.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteExpired(userId, time.Now())
+
+	res := make([]*auth.Session, 0, len(r.byUser[userId]))
+	for id := range r.byUser[userId] {
+		session := *r.sessions[id]
+		res = append(res, &session)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+func (r *SessionRepository) RotateSession(id uuid.UUID, tokenId, newTokenId string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return auth.SessionNotFoundError
+	}
+	if session.TokenId != tokenId {
+		return auth.TokenReusedError
+	}
+
+	session.TokenId = newTokenId
+	session.LastUsedAt = time.Now()
+	session.ExpiresAt = expiresAt
+
+	return nil
+}
+
+func (r *SessionRepository) DeleteSession(id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return auth.SessionNotFoundError
+	}
+	r.delete(session)
+
+	return nil
+}
+
+func (r *SessionRepository) DeleteUserSessions(userId uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.byUser[userId] {
+		delete(r.sessions, id)
+	}
+	delete(r.byUser, userId)
+
+	return nil
+}
+
+func (r *SessionRepository) deleteExpired(userId uuid.UUID, now time.Time) {
+	for id := range r.byUser[userId] {
+		if session := r.sessions[id]; !session.ExpiresAt.After(now) {
+			r.delete(session)
+		}
+	}
+}
+
+func (r *SessionRepository) delete(session *auth.Session) {
+	delete(r.sessions, session.Id)
+	delete(r.byUser[session.UserId], session.Id)
+	if len(r.byUser[session.UserId]) == 0 {
+		delete(r.byUser, session.UserId)
+	}
+}
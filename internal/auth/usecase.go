@@ -1,6 +1,38 @@
 package auth
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type Usecase interface {
 	Authentication(username, password string) bool
-	AdminAuthorization(username, password string) bool
+	Authorization(username, password, permission string) bool
+	Authenticate(login, password, clientIP string) (*Identity, error)
+	IssueTokens(login, password, code, clientIP string) (*Tokens, error)
+	VerifyPassword(userId uuid.UUID, password string) error
+	RefreshTokens(refreshToken string) (*Tokens, error)
+	VerifyAccessToken(accessToken string) (*Identity, error)
+	GetUserSessions(userId uuid.UUID) ([]*Session, error)
+	RevokeSession(userId, sessionId uuid.UUID) error
+	RevokeUserSessions(userId uuid.UUID) error
+	CreateAPIKey(userId uuid.UUID, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error)
+	GetAPIKeys(userId uuid.UUID) ([]*APIKey, error)
+	RevokeAPIKey(userId, keyId uuid.UUID) error
+	VerifyAPIKey(key string) (*Identity, error)
+	CreateRole(role *Role) (*Role, error)
+	GetRole(name string) (*Role, error)
+	GetRoles() ([]*Role, error)
+	UpdateRole(role *Role) (*Role, error)
+	DeleteRole(name string) error
+	GetLockouts() ([]*Lockout, error)
+	Unlock(kind, name string) error
+	UnlockUser(userId uuid.UUID) error
+	GetTOTPStatus(userId uuid.UUID) (*TOTPStatus, error)
+	EnrollTOTP(userId uuid.UUID) (*TOTPSetup, error)
+	ConfirmTOTP(userId uuid.UUID, code string) ([]string, error)
+	RegenerateRecoveryCodes(userId uuid.UUID, code string) ([]string, error)
+	DisableTOTP(userId uuid.UUID, code string) error
+	ResetTOTP(userId uuid.UUID) error
 }
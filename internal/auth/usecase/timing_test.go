@@ -0,0 +1,153 @@
+//go:build timing
+
+// The timing tests measure many password hashes and are statistical, so they
+// only run with go test -tags timing.
+
+package usecase_test
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/auth/repository"
+	"github.com/omelaymy/users/internal/auth/usecase"
+	"github.com/omelaymy/users/pkg/secure"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAuthenticationTiming checks with a two-sample Kolmogorov-Smirnov test
+// that failed sign-ins of existing and unknown users take equally long, so
+// response times do not tell which users exist.
+func TestAuthenticationTiming(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+		},
+		"robot": {
+			Id:             uuid.New(),
+			Username:       "robot",
+			ServiceAccount: true,
+		},
+	}
+	authUsecase := newAuth(t, repository.NewFakeRepository(users))
+
+	measure := func(login string) float64 {
+		start := time.Now()
+		_, err := authUsecase.Authenticate(login, "wrongpassword", "")
+		elapsed := time.Since(start)
+		assert.Equal(t, auth.InvalidCredentialsError, err)
+		return float64(elapsed)
+	}
+
+	// Warm up the dummy hash, which is made on first use.
+	measure("nobody")
+
+	const samples = 80
+	var existing, unknown, service []float64
+	for i := 0; i < samples; i++ {
+		// Interleaving the samples spreads drift of the machine's speed
+		// evenly over them.
+		existing = append(existing, measure("testuser"))
+		unknown = append(unknown, measure("nobody"))
+		service = append(service, measure("robot"))
+	}
+
+	// The critical value for a significance level of 0.001.
+	critical := math.Sqrt(-math.Log(0.001/2)/2) * math.Sqrt(2.0/samples)
+
+	d := ksStatistic(existing, unknown)
+	assert.Less(t, d, critical, "unknown users are told apart by timing (D = %.3f)", d)
+	d = ksStatistic(existing, service)
+	assert.Less(t, d, critical, "service accounts are told apart by timing (D = %.3f)", d)
+}
+
+// TestAuthenticationTimingMixedHashes checks that users whose passwords were
+// hashed with an older algorithm are not told apart from unknown users or
+// from users with hashes of the current algorithm.
+func TestAuthenticationTimingMixedHashes(t *testing.T) {
+	bcrypt := secure.NewBcrypt(secure.BcryptParams{Cost: 8})
+	argon2id := secure.NewArgon2id(secure.Argon2idParams{Memory: 8 * 1024, Iterations: 1})
+	bcryptHash, _ := bcrypt.Hash("password")
+	argon2idHash, _ := argon2id.Hash("password")
+	users := map[string]*auth.User{
+		"bcrypt": {
+			Id:       uuid.New(),
+			Username: "bcrypt",
+			Password: bcryptHash,
+		},
+		"argon2id": {
+			Id:       uuid.New(),
+			Username: "argon2id",
+			Password: argon2idHash,
+		},
+	}
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		secure.NewHasher(argon2id, bcrypt),
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+
+	measure := func(login string) float64 {
+		start := time.Now()
+		_, err := authUsecase.Authenticate(login, "wrongpassword", "")
+		elapsed := time.Since(start)
+		assert.Equal(t, auth.InvalidCredentialsError, err)
+		return float64(elapsed)
+	}
+
+	measure("nobody")
+
+	const samples = 80
+	var bcryptUsers, argon2idUsers, unknown []float64
+	for i := 0; i < samples; i++ {
+		bcryptUsers = append(bcryptUsers, measure("bcrypt"))
+		argon2idUsers = append(argon2idUsers, measure("argon2id"))
+		unknown = append(unknown, measure("nobody"))
+	}
+
+	critical := math.Sqrt(-math.Log(0.001/2)/2) * math.Sqrt(2.0/samples)
+
+	d := ksStatistic(bcryptUsers, unknown)
+	assert.Less(t, d, critical, "users with bcrypt hashes are told apart from unknown users (D = %.3f)", d)
+	d = ksStatistic(argon2idUsers, unknown)
+	assert.Less(t, d, critical, "users with argon2id hashes are told apart from unknown users (D = %.3f)", d)
+	d = ksStatistic(bcryptUsers, argon2idUsers)
+	assert.Less(t, d, critical, "the algorithm of a hash is told apart by timing (D = %.3f)", d)
+}
+
+// ksStatistic is the largest distance between the empirical distribution
+// functions of two samples.
+func ksStatistic(a, b []float64) float64 {
+	a = append([]float64(nil), a...)
+	b = append([]float64(nil), b...)
+	sort.Float64s(a)
+	sort.Float64s(b)
+
+	var d float64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		x := math.Min(a[i], b[j])
+		for i < len(a) && a[i] <= x {
+			i++
+		}
+		for j < len(b) && b[j] <= x {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+
+	return d
+}
@@ -1,44 +1,1073 @@
 package usecase
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/omelaymy/users/internal/auth"
 	"github.com/omelaymy/users/pkg/secure"
+	"github.com/omelaymy/users/pkg/token"
+	"github.com/omelaymy/users/pkg/totp"
 )
 
+// Auth reads the time from clock, so tests can control when codes and keys
+// expire.
 type Auth struct {
 	repository auth.Repository
+	sessions   auth.SessionRepository
+	lockouts   auth.LockoutRepository
+	tokens     *token.Manager
+	hasher     *secure.Hasher
+	lockout    auth.LockoutOptions
+	totp       auth.TOTPOptions
+	// requireVerifiedEmail keeps users with an unverified email from
+	// signing in.
+	requireVerifiedEmail bool
+	clock                func() time.Time
 }
 
 func NewAuth(
 	repository auth.Repository,
+	sessions auth.SessionRepository,
+	lockouts auth.LockoutRepository,
+	tokens *token.Manager,
+	hasher *secure.Hasher,
+	lockout auth.LockoutOptions,
+	totp auth.TOTPOptions,
+	requireVerifiedEmail bool,
+	clock func() time.Time,
 ) *Auth {
 	return &Auth{
-		repository: repository,
+		repository:           repository,
+		sessions:             sessions,
+		lockouts:             lockouts,
+		tokens:               tokens,
+		hasher:               hasher,
+		lockout:              lockout,
+		totp:                 totp,
+		requireVerifiedEmail: requireVerifiedEmail,
+		clock:                clock,
 	}
 }
 
 func (a *Auth) Authentication(username, password string) bool {
-	user, err := a.repository.GetUserByUsername(username)
+	_, err := a.Authenticate(username, password, "")
+	return err == nil
+}
+
+func (a *Auth) Authorization(username, password, permission string) bool {
+	identity, err := a.Authenticate(username, password, "")
+	return err == nil && identity.Can(permission)
+}
+
+// Authenticate checks a password against the user with the given username or
+// email. Users with a second factor, or whose roles require one, cannot sign
+// in with a password alone.
+func (a *Auth) Authenticate(login, password, clientIP string) (*auth.Identity, error) {
+	identity, err := a.signIn(login, password, "", clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if identity.TOTPEnrollment {
+		return nil, auth.TOTPEnrollmentRequiredError
+	}
+
+	return identity, nil
+}
+
+// VerifyPassword checks the password of a signed-in user, e.g. before the
+// user changes it. Wrong passwords count towards the lockout of the username,
+// so a stolen session cannot be used to guess the password.
+func (a *Auth) VerifyPassword(userId uuid.UUID, password string) error {
+	now := a.clock()
+
+	// Users looked up by id come without their password.
+	user, err := a.repository.GetUserById(userId)
+	if err != nil {
+		return err
+	}
+	user, err = a.repository.GetUserByUsername(user.Username)
+	if err != nil {
+		return err
+	}
+	if user.Id != userId {
+		return auth.UserNotFoundError
+	}
+	name := user.Id.String()
+	if err = a.checkLockout(auth.LockoutUser, name, now); err != nil {
+		return err
+	}
+
+	if user.ServiceAccount || user.Pending {
+		a.hasher.CompareDummy(password)
+		return auth.InvalidCredentialsError
+	}
+	if err = a.hasher.Compare(user.Password, password); err != nil {
+		if err = a.fail(auth.LockoutUser, name, now); err != nil {
+			return err
+		}
+		return auth.InvalidCredentialsError
+	}
+
+	return nil
+}
+
+// signIn checks a password and, for users with a second factor, a code
+// against the user with the given username or email. Failed sign-ins lock
+// out the user or, if no user has the login, the login, and unless clientIP
+// is empty the client IP. Logins nobody has are locked out just like users,
+// so lockouts do not tell which users exist. A user is locked out by id, so
+// every login the database resolves to the user shares one lockout.
+func (a *Auth) signIn(login, password, code, clientIP string) (*auth.Identity, error) {
+	now := a.clock()
+
+	user, err := a.findUser(login)
+	if err != nil && !errors.Is(err, auth.UserNotFoundError) {
+		return nil, err
+	}
+	account := auth.Lockout{Kind: auth.LockoutLogin, Name: login}
+	if user != nil {
+		account = auth.Lockout{Kind: auth.LockoutUser, Name: user.Id.String()}
+	}
+
+	keys := []auth.Lockout{account}
+	if clientIP != "" {
+		keys = append(keys, auth.Lockout{Kind: auth.LockoutIP, Name: clientIP})
+	}
+	for _, key := range keys {
+		if err := a.checkLockout(key.Kind, key.Name, now); err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err := a.authenticate(user, password, code, now)
+	if err != nil {
+		if errors.Is(err, auth.InvalidCredentialsError) {
+			for _, key := range keys {
+				if err := a.fail(key.Kind, key.Name, now); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, err
+	}
+
+	// The client IP keeps its failures, or signing in to an account of
+	// their own would let attackers guess on.
+	if err = a.lockouts.DeleteLockout(account.Kind, account.Name); err != nil &&
+		!errors.Is(err, auth.LockoutNotFoundError) {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// authenticate hashes the password whether or not the user exists, so the
+// response time does not tell which users do. A password hash made with
+// outdated parameters is replaced once the password is known to match it.
+// A wrong code counts as wrong credentials, while a missing one does not, so
+// the failures of both count towards the same lockout. A nil user is one
+// that was not found.
+func (a *Auth) authenticate(user *auth.User, password, code string, now time.Time) (*auth.Identity, error) {
+	if user == nil {
+		a.hasher.CompareDummy(password)
+		return nil, auth.InvalidCredentialsError
+	}
+
+	// Service accounts have no password and sign in with API keys only;
+	// pending users have none until they accept their invite.
+	if user.ServiceAccount || user.Pending {
+		a.hasher.CompareDummy(password)
+		return nil, auth.InvalidCredentialsError
+	}
+	if err := a.hasher.Compare(user.Password, password); err != nil {
+		return nil, auth.InvalidCredentialsError
+	}
+
+	if a.hasher.NeedsRehash(user.Password) {
+		// Failing to upgrade the hash must not fail the sign-in; it is
+		// tried again with the next one.
+		if hash, err := a.hasher.Hash(password); err == nil {
+			_ = a.repository.UpdatePasswordHash(user.Id, user.Password, hash)
+		}
+	}
+
+	// Only callers who know the password learn that the user is not active
+	// or that the email is not verified.
+	if err := checkStatus(user, now); err != nil {
+		return nil, err
+	}
+	if a.requireVerifiedEmail && !user.EmailVerified {
+		return nil, auth.EmailNotVerifiedError
+	}
+
+	identity := &auth.Identity{UserId: user.Id, Roles: user.Roles}
+
+	factor, err := a.repository.GetTOTP(user.Id)
+	if err != nil && !errors.Is(err, auth.TOTPNotFoundError) {
+		return nil, err
+	}
+	if factor != nil && factor.Confirmed {
+		if code == "" {
+			return nil, auth.TOTPRequiredError
+		}
+		if err = a.useCode(user.Id, code, now); err != nil {
+			if errors.Is(err, auth.InvalidTOTPCodeError) {
+				return nil, auth.InvalidCredentialsError
+			}
+			return nil, err
+		}
+	} else if identity.TOTPEnrollment, err = a.totpRequired(user.Roles); err != nil {
+		return nil, err
+	}
+
+	if !identity.TOTPEnrollment {
+		if identity.Permissions, err = a.permissions(user.Roles); err != nil {
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+// IssueTokens exchanges credentials, and the code of the second factor of
+// users who have one, for an access and a refresh token of a new session.
+// Users without the second factor their roles require get an access token to
+// set it up with and no session.
+func (a *Auth) IssueTokens(login, password, code, clientIP string) (*auth.Tokens, error) {
+	identity, err := a.signIn(login, password, code, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if !identity.TOTPEnrollment {
+		identity.SessionId = uuid.New()
+	}
+
+	tokens, refresh, err := a.issue(identity)
 	if err != nil {
-		return false
+		return nil, err
+	}
+	if refresh == nil {
+		return tokens, nil
 	}
 
-	if err := secure.ComparePasswords(user.Password, password); err != nil {
-		return false
+	now := a.clock()
+	err = a.sessions.CreateSession(&auth.Session{
+		Id:         identity.SessionId,
+		UserId:     identity.UserId,
+		TokenId:    refresh.ID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  refresh.ExpiresAt.Time,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return true
+	return tokens, nil
 }
 
-func (a *Auth) AdminAuthorization(username, password string) bool {
-	user, err := a.repository.GetUserByUsername(username)
+// RefreshTokens rotates the refresh token of a session. A refresh token that
+// has already been rotated may have been stolen, so presenting it again
+// revokes the whole session. The user and its roles are read again, so a
+// deleted user cannot refresh and changed permissions show up in the new
+// access token.
+func (a *Auth) RefreshTokens(refreshToken string) (*auth.Tokens, error) {
+	claims, err := a.tokens.Verify(refreshToken, token.TypeRefresh)
 	if err != nil {
-		return false
+		return nil, auth.InvalidTokenError
 	}
 
-	if err := secure.ComparePasswords(user.Password, password); err != nil {
-		return false
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, auth.InvalidTokenError
+	}
+	sessionId, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		return nil, auth.InvalidTokenError
+	}
+
+	session, err := a.sessions.GetSession(sessionId)
+	if err != nil {
+		if errors.Is(err, auth.SessionNotFoundError) {
+			return nil, auth.InvalidTokenError
+		}
+		return nil, err
+	}
+	if session.UserId != userId {
+		return nil, auth.InvalidTokenError
+	}
+	if session.TokenId != claims.ID {
+		return nil, a.revokeReused(sessionId)
+	}
+
+	user, err := a.repository.GetUserById(userId)
+	if err != nil {
+		if errors.Is(err, auth.UserNotFoundError) {
+			_ = a.sessions.DeleteSession(sessionId)
+			return nil, auth.InvalidTokenError
+		}
+		return nil, err
+	}
+
+	// Sessions of users whose roles came to require a second factor they
+	// have not set up, whose email is no longer verified, or who are no
+	// longer active, end here.
+	enroll, err := a.enrollmentRequired(user)
+	if err != nil {
+		return nil, err
+	}
+	if enroll || a.requireVerifiedEmail && !user.EmailVerified || checkStatus(user, a.clock()) != nil {
+		_ = a.sessions.DeleteSession(sessionId)
+		return nil, auth.InvalidTokenError
 	}
 
-	return user.Admin
+	permissions, err := a.permissions(user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, refresh, err := a.issue(&auth.Identity{
+		UserId:      user.Id,
+		Roles:       user.Roles,
+		Permissions: permissions,
+		SessionId:   sessionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Of two concurrent refreshes with the same token only one rotates the
+	// session; the other one counts as reuse.
+	err = a.sessions.RotateSession(sessionId, claims.ID, refresh.ID, refresh.ExpiresAt.Time)
+	if err != nil {
+		if errors.Is(err, auth.TokenReusedError) {
+			return nil, a.revokeReused(sessionId)
+		}
+		if errors.Is(err, auth.SessionNotFoundError) {
+			return nil, auth.InvalidTokenError
+		}
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// VerifyAccessToken trusts the claims of a valid access token and reads
+// neither the user nor the session, so a revoked session keeps its access
+// token until it expires.
+func (a *Auth) VerifyAccessToken(accessToken string) (*auth.Identity, error) {
+	claims, err := a.tokens.Verify(accessToken, token.TypeAccess)
+	if err != nil {
+		return nil, auth.InvalidTokenError
+	}
+
+	identity := &auth.Identity{
+		Roles:          claims.Roles,
+		Permissions:    claims.Permissions,
+		TOTPEnrollment: claims.TOTPEnrollment,
+	}
+	if identity.UserId, err = uuid.Parse(claims.Subject); err != nil {
+		return nil, auth.InvalidTokenError
+	}
+	if identity.SessionId, err = uuid.Parse(claims.SessionId); err != nil {
+		return nil, auth.InvalidTokenError
+	}
+
+	return identity, nil
+}
+
+func (a *Auth) GetUserSessions(userId uuid.UUID) ([]*auth.Session, error) {
+	return a.sessions.GetUserSessions(userId)
+}
+
+func (a *Auth) RevokeSession(userId, sessionId uuid.UUID) error {
+	session, err := a.sessions.GetSession(sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserId != userId {
+		return auth.SessionNotFoundError
+	}
+
+	return a.sessions.DeleteSession(sessionId)
+}
+
+// RevokeUserSessions signs a user out everywhere.
+func (a *Auth) RevokeUserSessions(userId uuid.UUID) error {
+	return a.sessions.DeleteUserSessions(userId)
+}
+
+// CreateAPIKey creates a key for a user and returns it with its secret, which
+// is not stored and cannot be shown again. A zero expiresAt never expires.
+func (a *Auth) CreateAPIKey(
+	userId uuid.UUID,
+	name string,
+	scopes []string,
+	expiresAt time.Time,
+) (*auth.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", auth.InvalidScopeError
+	}
+	for _, scope := range scopes {
+		if !auth.ValidPermission(scope) {
+			return nil, "", auth.InvalidScopeError
+		}
+	}
+
+	secret := make([]byte, apiKeySecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", auth.UnknownError
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &auth.APIKey{
+		UserId:    userId,
+		Name:      name,
+		Hash:      hashAPIKeySecret(encodedSecret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	id, err := a.repository.CreateAPIKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	created, err := a.repository.GetAPIKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return created, apiKeyPrefix + hex.EncodeToString(id[:]) + "_" + encodedSecret, nil
+}
+
+func (a *Auth) GetAPIKeys(userId uuid.UUID) ([]*auth.APIKey, error) {
+	return a.repository.GetUserAPIKeys(userId)
+}
+
+func (a *Auth) RevokeAPIKey(userId, keyId uuid.UUID) error {
+	key, err := a.repository.GetAPIKey(keyId)
+	if err != nil {
+		return err
+	}
+	if key.UserId != userId {
+		return auth.APIKeyNotFoundError
+	}
+
+	return a.repository.DeleteAPIKey(keyId)
+}
+
+// VerifyAPIKey checks a key sent as "ApiKey <key>". The caller gets the
+// permissions of the key's owner that are among the key's scopes.
+func (a *Auth) VerifyAPIKey(key string) (*auth.Identity, error) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, auth.InvalidCredentialsError
+	}
+
+	stored, err := a.repository.GetAPIKey(id)
+	if err != nil {
+		if errors.Is(err, auth.APIKeyNotFoundError) {
+			return nil, auth.InvalidCredentialsError
+		}
+		return nil, err
+	}
+
+	now := a.clock()
+	if subtle.ConstantTimeCompare(stored.Hash, hashAPIKeySecret(secret)) != 1 ||
+		!stored.ExpiresAt.IsZero() && !now.Before(stored.ExpiresAt) {
+		return nil, auth.InvalidCredentialsError
+	}
+
+	user, err := a.repository.GetUserById(stored.UserId)
+	if err != nil {
+		if errors.Is(err, auth.UserNotFoundError) {
+			return nil, auth.InvalidCredentialsError
+		}
+		return nil, err
+	}
+	if checkStatus(user, now) != nil {
+		return nil, auth.InvalidCredentialsError
+	}
+
+	// Every use would otherwise be a logged write; LastUsedAt is only
+	// accurate to apiKeyTouchInterval.
+	if now.Sub(stored.LastUsedAt) >= apiKeyTouchInterval {
+		if err = a.repository.TouchAPIKey(id, now); err != nil &&
+			!errors.Is(err, auth.APIKeyNotFoundError) {
+			return nil, err
+		}
+	}
+
+	owned, err := a.permissions(user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &auth.Identity{UserId: user.Id, Roles: user.Roles, Scopes: stored.Scopes}
+	for _, permission := range owned {
+		if hasScope(stored, permission) {
+			identity.Permissions = append(identity.Permissions, permission)
+		}
+	}
+
+	return identity, nil
+}
+
+func (a *Auth) CreateRole(role *auth.Role) (*auth.Role, error) {
+	if err := validatePermissions(role.Permissions); err != nil {
+		return nil, err
+	}
+	if err := a.repository.CreateRole(role); err != nil {
+		return nil, err
+	}
+
+	return a.repository.GetRole(role.Name)
+}
+
+func (a *Auth) GetRole(name string) (*auth.Role, error) {
+	return a.repository.GetRole(name)
+}
+
+func (a *Auth) GetRoles() ([]*auth.Role, error) {
+	return a.repository.GetRoles()
+}
+
+// UpdateRole replaces the description, the permissions and whether a second
+// factor is required of a role. Its users get the new permissions with their
+// next sign-in or refresh. Of the admin role, only the requirement of a
+// second factor can change.
+func (a *Auth) UpdateRole(role *auth.Role) (*auth.Role, error) {
+	if role.Name == auth.AdminRole {
+		admin, err := a.repository.GetRole(auth.AdminRole)
+		if err != nil && !errors.Is(err, auth.RoleNotFoundError) {
+			return nil, err
+		}
+		if admin == nil || role.Description != admin.Description ||
+			!samePermissions(role.Permissions, admin.Permissions) {
+			return nil, auth.ReadOnlyRoleError
+		}
+	}
+	if err := validatePermissions(role.Permissions); err != nil {
+		return nil, err
+	}
+	if err := a.repository.UpdateRole(role); err != nil {
+		return nil, err
+	}
+
+	return a.repository.GetRole(role.Name)
+}
+
+func (a *Auth) DeleteRole(name string) error {
+	if name == auth.AdminRole {
+		return auth.ReadOnlyRoleError
+	}
+
+	return a.repository.DeleteRole(name)
+}
+
+func (a *Auth) GetLockouts() ([]*auth.Lockout, error) {
+	return a.lockouts.GetLockouts()
+}
+
+func (a *Auth) Unlock(kind, name string) error {
+	return a.lockouts.DeleteLockout(kind, name)
+}
+
+// UnlockUser lifts the lockout of a user. Lockouts of client IPs stay.
+func (a *Auth) UnlockUser(userId uuid.UUID) error {
+	if _, err := a.repository.GetUserById(userId); err != nil {
+		return err
+	}
+
+	err := a.lockouts.DeleteLockout(auth.LockoutUser, userId.String())
+	if err != nil && !errors.Is(err, auth.LockoutNotFoundError) {
+		return err
+	}
+
+	return nil
+}
+
+// GetTOTPStatus tells whether a user has a second factor and whether the
+// roles of the user require one.
+func (a *Auth) GetTOTPStatus(userId uuid.UUID) (*auth.TOTPStatus, error) {
+	user, err := a.repository.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &auth.TOTPStatus{}
+	if status.Required, err = a.totpRequired(user.Roles); err != nil {
+		return nil, err
+	}
+
+	factor, err := a.repository.GetTOTP(userId)
+	if err != nil && !errors.Is(err, auth.TOTPNotFoundError) {
+		return nil, err
+	}
+	if factor != nil && factor.Confirmed {
+		status.Enabled = true
+		status.RecoveryCodes = len(factor.RecoveryCodes)
+	}
+
+	return status, nil
+}
+
+// EnrollTOTP starts setting up a second factor with a new secret, replacing
+// the secret of a setup that was not confirmed.
+func (a *Auth) EnrollTOTP(userId uuid.UUID) (*auth.TOTPSetup, error) {
+	user, err := a.repository.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.clock().UTC()
+	err = a.repository.UpdateTOTP(userId, func(factor *auth.TOTP) error {
+		if factor.Confirmed {
+			return auth.TOTPAlreadyEnabledError
+		}
+		factor.Secret = secret
+		factor.LastStep = 0
+		factor.RecoveryCodes = nil
+		factor.CreatedAt = now
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.TOTPSetup{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.URI(a.totp.Issuer, user.Username, secret, a.totpOptions()),
+	}, nil
+}
+
+// ConfirmTOTP turns a second factor that was set up on once code shows that
+// the authenticator app has its secret, and returns its recovery codes. They
+// are shown only this once.
+func (a *Auth) ConfirmTOTP(userId uuid.UUID, code string) ([]string, error) {
+	codes, hashes, err := a.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.clock()
+	err = a.repository.UpdateTOTP(userId, func(factor *auth.TOTP) error {
+		if len(factor.Secret) == 0 {
+			return auth.TOTPNotFoundError
+		}
+		if factor.Confirmed {
+			return auth.TOTPAlreadyEnabledError
+		}
+		step, ok := totp.Validate(factor.Secret, code, now, factor.LastStep, a.totpOptions())
+		if !ok {
+			return auth.InvalidTOTPCodeError
+		}
+		factor.Confirmed = true
+		factor.LastStep = step
+		factor.RecoveryCodes = hashes
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, so the old
+// ones stop working.
+func (a *Auth) RegenerateRecoveryCodes(userId uuid.UUID, code string) ([]string, error) {
+	codes, hashes, err := a.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.clock()
+	err = a.repository.UpdateTOTP(userId, func(factor *auth.TOTP) error {
+		if err := a.checkCode(factor, code, now); err != nil {
+			return err
+		}
+		factor.RecoveryCodes = hashes
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP removes the second factor of a user. Users whose roles require
+// one have to set up a new one with their next sign-in.
+func (a *Auth) DisableTOTP(userId uuid.UUID, code string) error {
+	if err := a.useCode(userId, code, a.clock()); err != nil {
+		return err
+	}
+
+	return a.repository.DeleteTOTP(userId)
+}
+
+// ResetTOTP removes the second factor of a user who lost it, without a code.
+func (a *Auth) ResetTOTP(userId uuid.UUID) error {
+	return a.repository.DeleteTOTP(userId)
+}
+
+func (a *Auth) checkLockout(kind, name string, now time.Time) error {
+	lockout, err := a.lockouts.GetLockout(kind, name)
+	if err != nil {
+		if errors.Is(err, auth.LockoutNotFoundError) {
+			return nil
+		}
+		return err
+	}
+	if !lockout.Locked(now) {
+		return nil
+	}
+
+	locked := &auth.LockedError{}
+	if !lockout.Permanent {
+		locked.Until = lockout.LockedUntil
+	}
+	return locked
+}
+
+// fail counts a failed sign-in. Failures older than the window are
+// forgotten, and every failure from the threshold on locks out twice as long
+// as the one before.
+func (a *Auth) fail(kind, name string, now time.Time) error {
+	opts := a.lockout
+	threshold := opts.Threshold
+	if kind == auth.LockoutIP {
+		threshold = opts.IPThreshold
+	}
+	if threshold <= 0 {
+		return nil
+	}
+
+	_, err := a.lockouts.UpdateLockout(kind, name, func(lockout *auth.Lockout) {
+		if !lockout.Permanent && now.Sub(lockout.LastFailure) > opts.Window {
+			lockout.Failures = 0
+		}
+		lockout.Failures++
+		lockout.LastFailure = now
+
+		if excess := lockout.Failures - threshold; excess >= 0 {
+			delay := opts.MaxDelay
+			if excess < 32 && opts.BaseDelay<<excess < opts.MaxDelay {
+				delay = opts.BaseDelay << excess
+			}
+			lockout.LockedUntil = now.Add(delay)
+		}
+		if kind != auth.LockoutIP && opts.PermanentThreshold > 0 &&
+			lockout.Failures >= opts.PermanentThreshold {
+			lockout.Permanent = true
+		}
+
+		lockout.ExpiresAt = time.Time{}
+		if !lockout.Permanent {
+			lockout.ExpiresAt = now.Add(opts.Window)
+			if lockout.LockedUntil.After(lockout.ExpiresAt) {
+				lockout.ExpiresAt = lockout.LockedUntil
+			}
+		}
+	})
+
+	return err
+}
+
+// permissions resolves roles to the sorted union of their permissions. The
+// admin role grants every permission, including ones added after it was
+// stored; roles that no longer exist grant nothing.
+func (a *Auth) permissions(roles []string) ([]string, error) {
+	set := make(map[string]struct{})
+	for _, name := range roles {
+		if name == auth.AdminRole {
+			for _, permission := range auth.Permissions {
+				set[permission] = struct{}{}
+			}
+			continue
+		}
+
+		role, err := a.repository.GetRole(name)
+		if err != nil {
+			if errors.Is(err, auth.RoleNotFoundError) {
+				continue
+			}
+			return nil, err
+		}
+		for _, permission := range role.Permissions {
+			set[permission] = struct{}{}
+		}
+	}
+
+	permissions := make([]string, 0, len(set))
+	for permission := range set {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+// totpRequired reports whether any of roles requires a second factor.
+func (a *Auth) totpRequired(roles []string) (bool, error) {
+	for _, name := range roles {
+		role, err := a.repository.GetRole(name)
+		if err != nil {
+			if errors.Is(err, auth.RoleNotFoundError) {
+				continue
+			}
+			return false, err
+		}
+		if role.RequireTOTP {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// enrollmentRequired reports whether the roles of a user require a second
+// factor the user has not set up.
+func (a *Auth) enrollmentRequired(user *auth.User) (bool, error) {
+	required, err := a.totpRequired(user.Roles)
+	if err != nil || !required {
+		return false, err
+	}
+
+	factor, err := a.repository.GetTOTP(user.Id)
+	if err != nil {
+		if errors.Is(err, auth.TOTPNotFoundError) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return !factor.Confirmed, nil
+}
+
+// useCode checks a code of the second factor of a user and records that it
+// was used.
+func (a *Auth) useCode(userId uuid.UUID, code string, now time.Time) error {
+	return a.repository.UpdateTOTP(userId, func(factor *auth.TOTP) error {
+		return a.checkCode(factor, code, now)
+	})
+}
+
+// checkCode accepts a code of the authenticator app that is newer than the
+// last one accepted, or one of the recovery codes, which is then used up.
+func (a *Auth) checkCode(factor *auth.TOTP, code string, now time.Time) error {
+	if !factor.Confirmed {
+		return auth.TOTPNotFoundError
+	}
+
+	if step, ok := totp.Validate(factor.Secret, code, now, factor.LastStep, a.totpOptions()); ok {
+		factor.LastStep = step
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range factor.RecoveryCodes {
+		if subtle.ConstantTimeCompare(stored, hash) == 1 {
+			left := make([][]byte, 0, len(factor.RecoveryCodes)-1)
+			left = append(left, factor.RecoveryCodes[:i]...)
+			factor.RecoveryCodes = append(left, factor.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+
+	return auth.InvalidTOTPCodeError
+}
+
+func (a *Auth) totpOptions() totp.Options {
+	return totp.Options{Skew: a.totp.Skew}
+}
+
+// recoveryCodes returns new recovery codes and their hashes.
+func (a *Auth) recoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, a.totp.RecoveryCodes)
+	hashes := make([][]byte, a.totp.RecoveryCodes)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))[:recoveryCodeLength]
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+func (a *Auth) revokeReused(sessionId uuid.UUID) error {
+	if err := a.sessions.DeleteSession(sessionId); err != nil &&
+		!errors.Is(err, auth.SessionNotFoundError) {
+		return err
+	}
+
+	return auth.InvalidTokenError
+}
+
+func (a *Auth) issue(identity *auth.Identity) (*auth.Tokens, *token.Claims, error) {
+	claims := token.Claims{
+		Roles:          identity.Roles,
+		Permissions:    identity.Permissions,
+		SessionId:      identity.SessionId.String(),
+		TOTPEnrollment: identity.TOTPEnrollment,
+	}
+	claims.Subject = identity.UserId.String()
+
+	access, _, err := a.tokens.Sign(token.TypeAccess, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	if identity.TOTPEnrollment {
+		return &auth.Tokens{
+			AccessToken:    access,
+			ExpiresIn:      a.tokens.AccessTTL(),
+			TOTPEnrollment: true,
+		}, nil, nil
+	}
+
+	claims.Roles = nil
+	claims.Permissions = nil
+	refresh, refreshClaims, err := a.tokens.Sign(token.TypeRefresh, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &auth.Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    a.tokens.AccessTTL(),
+	}, refreshClaims, nil
+}
+
+// checkStatus refuses users who are deactivated, or suspended at now.
+func checkStatus(user *auth.User, now time.Time) error {
+	switch user.Status {
+	case auth.StatusDeactivated:
+		return auth.UserDeactivatedError
+	case auth.StatusSuspended:
+		if user.SuspendedUntil.IsZero() || user.SuspendedUntil.After(now) {
+			return auth.UserSuspendedError
+		}
+	}
+
+	return nil
+}
+
+// findUser resolves a login identifier, which may be either a username or an
+// email. Usernames take precedence.
+func (a *Auth) findUser(login string) (*auth.User, error) {
+	user, err := a.repository.GetUserByUsername(login)
+	if errors.Is(err, auth.UserNotFoundError) {
+		return a.repository.GetUserByEmail(login)
+	}
+
+	return user, err
+}
+
+const (
+	// apiKeyPrefix starts every API key, followed by the hex encoded key id,
+	// an underscore and the secret.
+	apiKeyPrefix       = "usk_"
+	apiKeySecretLength = 32
+
+	apiKeyTouchInterval = time.Minute
+)
+
+func hasScope(key *auth.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func samePermissions(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return strings.Join(a, " ") == strings.Join(b, " ")
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !auth.ValidPermission(permission) {
+			return auth.InvalidPermissionError
+		}
+	}
+
+	return nil
+}
+
+func parseAPIKey(key string) (uuid.UUID, string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return uuid.UUID{}, "", false
+	}
+
+	encodedId, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return uuid.UUID{}, "", false
+	}
+
+	var id uuid.UUID
+	if n, err := hex.Decode(id[:], []byte(encodedId)); err != nil || n != len(id) || len(encodedId) != 2*len(id) {
+		return uuid.UUID{}, "", false
+	}
+
+	return id, secret, true
+}
+
+// hashAPIKeySecret uses a plain SHA-256: unlike passwords, secrets are random
+// and long enough that a slow hash adds nothing.
+func hashAPIKeySecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+const (
+	// recoveryCodeLength is the number of base32 characters of a recovery
+	// code, drawn from recoveryCodeBytes random bytes.
+	recoveryCodeLength = 10
+	recoveryCodeBytes  = 7
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// hashRecoveryCode ignores case, dashes and spaces, so codes can be typed the
+// way they were shown or written down. Like API key secrets, recovery codes
+// are random enough for a plain SHA-256.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
 }
@@ -1,51 +1,841 @@
 package usecase_test
 
 import (
+	"encoding/base32"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/omelaymy/users/internal/auth"
 	"github.com/omelaymy/users/internal/auth/repository"
 	"github.com/omelaymy/users/internal/auth/usecase"
+	"github.com/omelaymy/users/pkg/db/inmemory"
 	"github.com/omelaymy/users/pkg/secure"
+	"github.com/omelaymy/users/pkg/token"
+	"github.com/omelaymy/users/pkg/totp"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTokenManager(t *testing.T) *token.Manager {
+	m, err := token.NewManager(token.Options{
+		Issuer:     "users",
+		Audience:   "users-api",
+		AccessTTL:  time.Minute,
+		RefreshTTL: time.Hour,
+		SigningKey: "test",
+		Keys: []token.KeyOptions{
+			{ID: "test", Algorithm: token.HS256, Secret: "0123456789abcdef0123456789abcdef"},
+		},
+	}, time.Now)
+	require.NoError(t, err)
+
+	return m
+}
+
+var hasher = secure.NewHasher(secure.NewBcrypt(secure.BcryptParams{Cost: 8}))
+
+func newAuth(t *testing.T, repo auth.Repository) *usecase.Auth {
+	return usecase.NewAuth(
+		repo,
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+}
+
 func TestAuthentication(t *testing.T) {
-	password, _ := secure.HashPassword("password")
+	password, _ := hasher.Hash("password")
 	users := map[string]*auth.User{
 		"testuser": {
 			Username: "testuser",
 			Password: password,
-			Admin:    false,
+		},
+		"invited@example.com": {
+			Username: "invited@example.com",
+			Pending:  true,
 		},
 	}
 	repo := repository.NewFakeRepository(users)
-	authUsecase := usecase.NewAuth(repo)
+	authUsecase := newAuth(t, repo)
 
 	assert.True(t, authUsecase.Authentication("testuser", "password"))
 	assert.False(t, authUsecase.Authentication("testuser", "wrongpassword"))
 	assert.False(t, authUsecase.Authentication("nonexistentuser", "password"))
+	assert.False(t, authUsecase.Authentication("invited@example.com", ""))
+}
+
+func TestAuthorization(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testadmin": {
+			Username: "testadmin",
+			Password: password,
+			Roles:    []string{auth.AdminRole},
+		},
+		"testuser": {
+			Username: "testuser",
+			Password: password,
+			Roles:    []string{"auditor"},
+		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+	_, err := authUsecase.CreateRole(&auth.Role{Name: "auditor", Permissions: []string{auth.PermissionUsersRead}})
+	require.NoError(t, err)
+
+	assert.True(t, authUsecase.Authorization("testadmin", "password", auth.PermissionUsersDelete))
+	assert.True(t, authUsecase.Authorization("testuser", "password", auth.PermissionUsersRead))
+	assert.False(t, authUsecase.Authorization("testuser", "password", auth.PermissionUsersDelete))
+	assert.False(t, authUsecase.Authorization("testadmin", "wrongpassword", auth.PermissionUsersRead))
+	assert.False(t, authUsecase.Authorization("nonexistentuser", "password", auth.PermissionUsersRead))
+}
+
+func TestRoles(t *testing.T) {
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Roles:    []string{"helpdesk"},
+		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+
+	_, err := authUsecase.CreateRole(&auth.Role{Name: "helpdesk", Permissions: []string{"users:everything"}})
+	assert.Equal(t, auth.InvalidPermissionError, err)
+
+	role, err := authUsecase.CreateRole(&auth.Role{
+		Name:        "helpdesk",
+		Description: "Resets passwords",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersResetPassword},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Resets passwords", role.Description)
+	assert.False(t, role.CreatedAt.IsZero())
+	_, err = authUsecase.CreateRole(&auth.Role{Name: "helpdesk"})
+	assert.Equal(t, auth.RoleAlreadyExistsError, err)
+
+	role, err = authUsecase.UpdateRole(&auth.Role{Name: "helpdesk", Permissions: []string{auth.PermissionUsersRead}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{auth.PermissionUsersRead}, role.Permissions)
+	_, err = authUsecase.UpdateRole(&auth.Role{Name: "missing"})
+	assert.Equal(t, auth.RoleNotFoundError, err)
+
+	_, err = authUsecase.UpdateRole(&auth.Role{Name: auth.AdminRole})
+	assert.Equal(t, auth.ReadOnlyRoleError, err)
+	assert.Equal(t, auth.ReadOnlyRoleError, authUsecase.DeleteRole(auth.AdminRole))
+
+	assert.Equal(t, auth.RoleInUseError, authUsecase.DeleteRole("helpdesk"))
+	users["testuser"].Roles = nil
+	require.NoError(t, authUsecase.DeleteRole("helpdesk"))
+	roles, err := authUsecase.GetRoles()
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+// countingAlgorithm counts the comparisons made with an algorithm.
+type countingAlgorithm struct {
+	secure.Algorithm
+	compares int
+}
+
+func (c *countingAlgorithm) Compare(hash, password string) error {
+	c.compares++
+	return c.Algorithm.Compare(hash, password)
+}
+
+// TestAuthenticationHashWork checks that failed sign-ins of unknown users,
+// users without a password and users with hashes of either algorithm do the
+// same hashing work, so response times do not tell them apart.
+func TestAuthenticationHashWork(t *testing.T) {
+	argon2id := &countingAlgorithm{Algorithm: secure.NewArgon2id(secure.Argon2idParams{Memory: 64, Iterations: 1})}
+	bcrypt := &countingAlgorithm{Algorithm: secure.NewBcrypt(secure.BcryptParams{Cost: 4})}
+	argon2idHash, _ := argon2id.Hash("password")
+	bcryptHash, _ := bcrypt.Hash("password")
+	users := map[string]*auth.User{
+		"argon2id": {Id: uuid.New(), Username: "argon2id", Password: argon2idHash},
+		"bcrypt":   {Id: uuid.New(), Username: "bcrypt", Password: bcryptHash},
+		"robot":    {Id: uuid.New(), Username: "robot", ServiceAccount: true},
+		"invitee":  {Id: uuid.New(), Username: "invitee", Pending: true},
+	}
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		secure.NewHasher(argon2id, bcrypt),
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+
+	for _, login := range []string{"argon2id", "bcrypt", "robot", "invitee", "nobody"} {
+		argon2id.compares, bcrypt.compares = 0, 0
+		_, err := authUsecase.Authenticate(login, "wrongpassword", "")
+		assert.Equal(t, auth.InvalidCredentialsError, err, login)
+		assert.Equal(t, 1, argon2id.compares, login)
+		assert.Equal(t, 1, bcrypt.compares, login)
+	}
+}
+
+func TestAuthenticationByEmail(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Email:    "testuser@example.com",
+			Username: "testuser",
+			Password: password,
+			Roles:    []string{auth.AdminRole},
+		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+
+	assert.True(t, authUsecase.Authentication("testuser@example.com", "password"))
+	assert.True(t, authUsecase.Authorization("testuser@example.com", "password", auth.PermissionUsersWrite))
+	assert.False(t, authUsecase.Authentication("testuser@example.com", "wrongpassword"))
+	assert.False(t, authUsecase.Authentication("nonexistent@example.com", "password"))
 }
 
-func TestAdminAuthorization(t *testing.T) {
-	password, _ := secure.HashPassword("password")
+func TestTokens(t *testing.T) {
+	password, _ := hasher.Hash("password")
 	users := map[string]*auth.User{
 		"testadmin": {
+			Id:       uuid.New(),
 			Username: "testadmin",
 			Password: password,
-			Admin:    true,
+			Roles:    []string{auth.AdminRole},
 		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+
+	_, err := authUsecase.IssueTokens("testadmin", "wrongpassword", "", "")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+
+	tokens, err := authUsecase.IssueTokens("testadmin", "password", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, tokens.ExpiresIn)
+
+	identity, err := authUsecase.VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, users["testadmin"].Id, identity.UserId)
+	assert.ElementsMatch(t, auth.Permissions, identity.Permissions)
+	assert.Equal(t, []string{auth.AdminRole}, identity.Roles)
+
+	_, err = authUsecase.VerifyAccessToken(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+	_, err = authUsecase.RefreshTokens(tokens.AccessToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+
+	users["testadmin"].Roles = nil
+	refreshed, err := authUsecase.RefreshTokens(tokens.RefreshToken)
+	require.NoError(t, err)
+	identity, err = authUsecase.VerifyAccessToken(refreshed.AccessToken)
+	require.NoError(t, err)
+	assert.Empty(t, identity.Permissions)
+
+	delete(users, "testadmin")
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
 		"testuser": {
+			Id:       uuid.New(),
 			Username: "testuser",
 			Password: password,
-			Admin:    false,
 		},
 	}
 	repo := repository.NewFakeRepository(users)
-	authUsecase := usecase.NewAuth(repo)
+	authUsecase := newAuth(t, repo)
+
+	first, err := authUsecase.IssueTokens("testuser", "password", "", "")
+	require.NoError(t, err)
+	second, err := authUsecase.RefreshTokens(first.RefreshToken)
+	require.NoError(t, err)
+	third, err := authUsecase.RefreshTokens(second.RefreshToken)
+	require.NoError(t, err)
+
+	sessions, err := authUsecase.GetUserSessions(users["testuser"].Id)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	identity, err := authUsecase.VerifyAccessToken(third.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, sessions[0].Id, identity.SessionId)
+
+	// Reusing a rotated token revokes the session, so the latest token of
+	// the family stops working as well.
+	_, err = authUsecase.RefreshTokens(first.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+	_, err = authUsecase.RefreshTokens(third.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+
+	sessions, err = authUsecase.GetUserSessions(users["testuser"].Id)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+		},
+		"otheruser": {
+			Id:       uuid.New(),
+			Username: "otheruser",
+			Password: password,
+		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+	userId := users["testuser"].Id
+
+	var tokens []*auth.Tokens
+	for i := 0; i < 3; i++ {
+		issued, err := authUsecase.IssueTokens("testuser", "password", "", "")
+		require.NoError(t, err)
+		tokens = append(tokens, issued)
+	}
+	other, err := authUsecase.IssueTokens("otheruser", "password", "", "")
+	require.NoError(t, err)
+
+	sessions, err := authUsecase.GetUserSessions(userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3)
+
+	assert.Equal(t, auth.SessionNotFoundError, authUsecase.RevokeSession(users["otheruser"].Id, sessions[0].Id))
+	require.NoError(t, authUsecase.RevokeSession(userId, sessions[0].Id))
+	_, err = authUsecase.RefreshTokens(tokens[0].RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+	_, err = authUsecase.RefreshTokens(tokens[1].RefreshToken)
+	assert.NoError(t, err)
+
+	require.NoError(t, authUsecase.RevokeUserSessions(userId))
+	sessions, err = authUsecase.GetUserSessions(userId)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	_, err = authUsecase.RefreshTokens(tokens[2].RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+
+	_, err = authUsecase.RefreshTokens(other.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestAPIKeys(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"ci": {
+			Id:             uuid.New(),
+			Username:       "ci",
+			Roles:          []string{"deployer"},
+			ServiceAccount: true,
+		},
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+		},
+	}
+	repo := repository.NewFakeRepository(users)
+	authUsecase := newAuth(t, repo)
+	ciId := users["ci"].Id
+	_, err := authUsecase.CreateRole(&auth.Role{
+		Name:        "deployer",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersWrite},
+	})
+	require.NoError(t, err)
+
+	_, err = authUsecase.Authenticate("ci", "", "")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+
+	_, _, err = authUsecase.CreateAPIKey(ciId, "deploy", nil, time.Time{})
+	assert.Equal(t, auth.InvalidScopeError, err)
+	_, _, err = authUsecase.CreateAPIKey(ciId, "deploy", []string{"users:everything"}, time.Time{})
+	assert.Equal(t, auth.InvalidScopeError, err)
+	_, _, err = authUsecase.CreateAPIKey(uuid.New(), "deploy", []string{auth.PermissionUsersRead}, time.Time{})
+	assert.Equal(t, auth.UserNotFoundError, err)
+
+	writeKey, writeSecret, err := authUsecase.CreateAPIKey(
+		ciId,
+		"deploy",
+		[]string{auth.PermissionUsersRead, auth.PermissionUsersWrite, auth.PermissionUsersDelete},
+		time.Time{},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", writeKey.Name)
+	assert.NotContains(t, string(writeKey.Hash), writeSecret)
+	_, readSecret, err := authUsecase.CreateAPIKey(ciId, "monitoring", []string{auth.PermissionUsersRead}, time.Time{})
+	require.NoError(t, err)
+	_, expiredSecret, err := authUsecase.CreateAPIKey(ciId, "old", []string{auth.PermissionUsersRead}, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	identity, err := authUsecase.VerifyAPIKey(writeSecret)
+	require.NoError(t, err)
+	assert.Equal(t, ciId, identity.UserId)
+	// The owner cannot delete users, so neither can the key.
+	assert.Equal(t, []string{auth.PermissionUsersRead, auth.PermissionUsersWrite}, identity.Permissions)
+
+	identity, err = authUsecase.VerifyAPIKey(readSecret)
+	require.NoError(t, err)
+	assert.Equal(t, []string{auth.PermissionUsersRead}, identity.Permissions)
+	assert.Equal(t, []string{auth.PermissionUsersRead}, identity.Scopes)
+
+	keys, err := authUsecase.GetAPIKeys(ciId)
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	assert.False(t, keys[0].LastUsedAt.IsZero())
+	assert.True(t, keys[2].LastUsedAt.IsZero())
+
+	for _, invalid := range []string{
+		expiredSecret,
+		writeSecret[:len(writeSecret)-1],
+		"usk_" + writeSecret[len("usk_")+1:],
+		"not a key",
+	} {
+		_, err = authUsecase.VerifyAPIKey(invalid)
+		assert.Equal(t, auth.InvalidCredentialsError, err, invalid)
+	}
+
+	assert.Equal(t, auth.APIKeyNotFoundError, authUsecase.RevokeAPIKey(users["testuser"].Id, writeKey.Id))
+	require.NoError(t, authUsecase.RevokeAPIKey(ciId, writeKey.Id))
+	_, err = authUsecase.VerifyAPIKey(writeSecret)
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+}
+
+func TestLockout(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Email:    "test@user.com",
+			Username: "testuser",
+			Password: password,
+		},
+	}
+	newLockoutAuth := func(opts auth.LockoutOptions) *usecase.Auth {
+		return usecase.NewAuth(
+			repository.NewFakeRepository(users),
+			repository.NewSessionRepository(),
+			repository.NewLockoutRepository(),
+			newTokenManager(t),
+			hasher,
+			opts,
+			auth.TOTPOptions{},
+			false,
+			time.Now,
+		)
+	}
+
+	authUsecase := newLockoutAuth(auth.LockoutOptions{
+		Threshold:   3,
+		IPThreshold: 3,
+		BaseDelay:   time.Hour,
+		MaxDelay:    4 * time.Hour,
+		Window:      time.Hour,
+	})
+
+	// A login nobody has is locked out just like one of a user, so lockouts
+	// do not tell which users exist.
+	for _, login := range []string{"testuser", "nobody"} {
+		for i := 0; i < 3; i++ {
+			_, err := authUsecase.Authenticate(login, "wrongpassword", "")
+			assert.Equal(t, auth.InvalidCredentialsError, err, login)
+		}
+		_, err := authUsecase.Authenticate(login, "password", "")
+		var locked *auth.LockedError
+		require.ErrorAs(t, err, &locked, login)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), locked.Until, time.Minute)
+	}
+
+	// The user is locked out whatever login it is resolved from.
+	_, err := authUsecase.Authenticate("test@user.com", "password", "")
+	assert.IsType(t, &auth.LockedError{}, err)
+
+	require.NoError(t, authUsecase.UnlockUser(users["testuser"].Id))
+	_, err = authUsecase.Authenticate("testuser", "password", "")
+	require.NoError(t, err)
+	require.NoError(t, authUsecase.Unlock(auth.LockoutLogin, "nobody"))
+	assert.Equal(t, auth.LockoutNotFoundError, authUsecase.Unlock(auth.LockoutLogin, "nobody"))
+
+	// Guessing from one client IP with many logins locks out the IP.
+	for _, login := range []string{"a", "b", "c"} {
+		_, err = authUsecase.Authenticate(login, "wrongpassword", "192.0.2.1")
+		assert.Equal(t, auth.InvalidCredentialsError, err)
+	}
+	_, err = authUsecase.Authenticate("testuser", "password", "192.0.2.1")
+	assert.IsType(t, &auth.LockedError{}, err)
+	_, err = authUsecase.Authenticate("testuser", "password", "192.0.2.2")
+	require.NoError(t, err)
+
+	lockouts, err := authUsecase.GetLockouts()
+	require.NoError(t, err)
+	require.Len(t, lockouts, 4)
+	assert.Equal(t, auth.LockoutIP, lockouts[0].Kind)
+	assert.Equal(t, 3, lockouts[0].Failures)
+	assert.Equal(t, auth.LockoutLogin, lockouts[1].Kind)
+	assert.Equal(t, "a", lockouts[1].Name)
+
+	// Each failure from the threshold on locks out twice as long, up to the
+	// maximum, and enough failures lock out a login for good.
+	authUsecase = newLockoutAuth(auth.LockoutOptions{
+		Threshold:          1,
+		IPThreshold:        1,
+		BaseDelay:          time.Nanosecond,
+		MaxDelay:           4 * time.Nanosecond,
+		Window:             time.Hour,
+		PermanentThreshold: 5,
+	})
+	for i, delay := range []time.Duration{1, 2, 4, 4} {
+		_, err = authUsecase.Authenticate("testuser", "wrongpassword", "192.0.2.1")
+		assert.Equal(t, auth.InvalidCredentialsError, err)
+
+		lockouts, err = authUsecase.GetLockouts()
+		require.NoError(t, err)
+		require.Len(t, lockouts, 2)
+		assert.Equal(t, i+1, lockouts[1].Failures)
+		assert.Equal(t, delay, lockouts[1].LockedUntil.Sub(lockouts[1].LastFailure))
+	}
+
+	_, err = authUsecase.Authenticate("testuser", "wrongpassword", "192.0.2.1")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+	_, err = authUsecase.Authenticate("testuser", "password", "192.0.2.2")
+	var locked *auth.LockedError
+	require.ErrorAs(t, err, &locked)
+	assert.True(t, locked.Until.IsZero())
+
+	require.NoError(t, authUsecase.UnlockUser(users["testuser"].Id))
+	_, err = authUsecase.Authenticate("test@user.com", "password", "192.0.2.1")
+	require.NoError(t, err, "client IPs are never locked out for good")
+}
+
+// TestLockoutResolvedLogins checks that every login the database resolves to
+// a user counts towards the same lockout.
+func TestLockoutResolvedLogins(t *testing.T) {
+	db, err := inmemory.OpenInMemoryDatabase(inmemory.Options{
+		Normalization: inmemory.Normalization{CaseFold: true, NFKC: true},
+	})
+	require.NoError(t, err)
+	password, _ := hasher.Hash("password")
+	id, err := db.InsertUser(inmemory.User{Username: "admin", Email: "admin@example.com", Password: password})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	authUsecase := usecase.NewAuth(
+		repository.NewAuthRepository(db, nil, &log),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{Threshold: 3, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+
+	for _, login := range []string{"ａｄｍｉｎ", "ADMIN", "admin@example.com"} {
+		_, err = authUsecase.Authenticate(login, "wrongpassword", "")
+		assert.Equal(t, auth.InvalidCredentialsError, err, login)
+	}
+	for _, login := range []string{"admin", "ａｄｍｉｎ", "ADMIN@EXAMPLE.COM"} {
+		_, err = authUsecase.Authenticate(login, "password", "")
+		assert.IsType(t, &auth.LockedError{}, err, login)
+	}
+
+	lockouts, err := authUsecase.GetLockouts()
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, auth.LockoutUser, lockouts[0].Kind)
+	assert.Equal(t, id.String(), lockouts[0].Name)
+	assert.Equal(t, 3, lockouts[0].Failures)
+}
+
+func TestVerifyPassword(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+		},
+	}
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{Threshold: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, Window: time.Hour},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+	id := users["testuser"].Id
+
+	require.NoError(t, authUsecase.VerifyPassword(id, "password"))
+	assert.Equal(t, auth.UserNotFoundError, authUsecase.VerifyPassword(uuid.New(), "password"))
+
+	// Wrong passwords lock out the username like failed sign-ins.
+	assert.Equal(t, auth.InvalidCredentialsError, authUsecase.VerifyPassword(id, "wrongpassword"))
+	assert.Equal(t, auth.InvalidCredentialsError, authUsecase.VerifyPassword(id, "wrongpassword"))
+	assert.IsType(t, &auth.LockedError{}, authUsecase.VerifyPassword(id, "password"))
+	_, err := authUsecase.Authenticate("testuser", "password", "")
+	assert.IsType(t, &auth.LockedError{}, err)
+}
+
+func TestRehash(t *testing.T) {
+	legacy, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: legacy,
+		},
+	}
+	argon2id := secure.NewHasher(
+		secure.NewArgon2id(secure.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}),
+		secure.NewBcrypt(secure.BcryptParams{Cost: 8}),
+	)
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		argon2id,
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		false,
+		time.Now,
+	)
+
+	_, err := authUsecase.Authenticate("testuser", "wrongpassword", "")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+	assert.Equal(t, legacy, users["testuser"].Password, "only a matching password is rehashed")
+
+	_, err = authUsecase.Authenticate("testuser", "password", "")
+	require.NoError(t, err)
+	upgraded := users["testuser"].Password
+	assert.True(t, strings.HasPrefix(upgraded, "$argon2id$v=19$m=64,t=1,p=1$"), upgraded)
+
+	_, err = authUsecase.Authenticate("testuser", "password", "")
+	require.NoError(t, err)
+	assert.Equal(t, upgraded, users["testuser"].Password)
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:            uuid.New(),
+			Username:      "testuser",
+			Password:      password,
+			EmailVerified: true,
+		},
+	}
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		true,
+		time.Now,
+	)
+
+	tokens, err := authUsecase.IssueTokens("testuser", "password", "", "")
+	require.NoError(t, err)
+
+	users["testuser"].EmailVerified = false
+	_, err = authUsecase.IssueTokens("testuser", "wrongpassword", "", "")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.Equal(t, auth.EmailNotVerifiedError, err)
+	assert.False(t, authUsecase.Authentication("testuser", "password"))
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+
+	users["testuser"].EmailVerified = true
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err, "the session ended")
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.NoError(t, err)
+}
+
+func TestUserStatus(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+			Roles:    []string{"reader"},
+			Status:   auth.StatusActive,
+		},
+	}
+	now := time.Now()
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{},
+		auth.TOTPOptions{},
+		false,
+		func() time.Time { return now },
+	)
+	_, err := authUsecase.CreateRole(&auth.Role{Name: "reader", Permissions: []string{auth.PermissionUsersRead}})
+	require.NoError(t, err)
+	userId := users["testuser"].Id
+
+	tokens, err := authUsecase.IssueTokens("testuser", "password", "", "")
+	require.NoError(t, err)
+	_, secret, err := authUsecase.CreateAPIKey(userId, "script", []string{auth.PermissionUsersRead}, time.Time{})
+	require.NoError(t, err)
+
+	users["testuser"].Status = auth.StatusSuspended
+	users["testuser"].SuspendedUntil = now.Add(time.Hour)
+	_, err = authUsecase.IssueTokens("testuser", "wrongpassword", "", "")
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.Equal(t, auth.UserSuspendedError, err)
+	assert.False(t, authUsecase.Authentication("testuser", "password"))
+	_, err = authUsecase.VerifyAPIKey(secret)
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
+
+	// The suspension ends by itself.
+	now = now.Add(time.Hour)
+	tokens, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	require.NoError(t, err)
+	_, err = authUsecase.VerifyAPIKey(secret)
+	assert.NoError(t, err)
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.NoError(t, err)
+
+	users["testuser"].SuspendedUntil = time.Time{}
+	now = now.Add(365 * 24 * time.Hour)
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.Equal(t, auth.UserSuspendedError, err, "suspended until reactivated")
+
+	users["testuser"].Status = auth.StatusDeactivated
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.Equal(t, auth.UserDeactivatedError, err)
+	_, err = authUsecase.VerifyAPIKey(secret)
+	assert.Equal(t, auth.InvalidCredentialsError, err)
+
+	users["testuser"].Status = auth.StatusActive
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.NoError(t, err)
+}
+
+func TestTOTP(t *testing.T) {
+	password, _ := hasher.Hash("password")
+	users := map[string]*auth.User{
+		"testuser": {
+			Id:       uuid.New(),
+			Username: "testuser",
+			Password: password,
+			Roles:    []string{"operator"},
+		},
+	}
+	now := time.Unix(1700000000, 0)
+	authUsecase := usecase.NewAuth(
+		repository.NewFakeRepository(users),
+		repository.NewSessionRepository(),
+		repository.NewLockoutRepository(),
+		newTokenManager(t),
+		hasher,
+		auth.LockoutOptions{},
+		auth.TOTPOptions{Issuer: "Users", Skew: 1, RecoveryCodes: 10},
+		false,
+		func() time.Time { return now },
+	)
+	userId := users["testuser"].Id
+	_, err := authUsecase.CreateRole(&auth.Role{
+		Name:        "operator",
+		Permissions: []string{auth.PermissionUsersRead},
+		RequireTOTP: true,
+	})
+	require.NoError(t, err)
+
+	// Without the second factor the role requires, a sign-in only allows
+	// setting it up.
+	tokens, err := authUsecase.IssueTokens("testuser", "password", "", "")
+	require.NoError(t, err)
+	assert.True(t, tokens.TOTPEnrollment)
+	assert.Empty(t, tokens.RefreshToken)
+	identity, err := authUsecase.VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.True(t, identity.TOTPEnrollment)
+	assert.Empty(t, identity.Permissions)
+	_, err = authUsecase.Authenticate("testuser", "password", "")
+	assert.Equal(t, auth.TOTPEnrollmentRequiredError, err)
+
+	setup, err := authUsecase.EnrollTOTP(userId)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(setup.URI, "otpauth://totp/Users:testuser?"), setup.URI)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(setup.Secret)
+	require.NoError(t, err)
+	code := func() string { return totp.Code(secret, totp.Step(now, 0), totp.DefaultDigits) }
+
+	_, err = authUsecase.ConfirmTOTP(userId, "000000")
+	assert.Equal(t, auth.InvalidTOTPCodeError, err)
+	recoveryCodes, err := authUsecase.ConfirmTOTP(userId, code())
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+	_, err = authUsecase.EnrollTOTP(userId)
+	assert.Equal(t, auth.TOTPAlreadyEnabledError, err)
+
+	_, err = authUsecase.IssueTokens("testuser", "password", "", "")
+	assert.Equal(t, auth.TOTPRequiredError, err)
+	_, err = authUsecase.IssueTokens("testuser", "password", code(), "")
+	assert.Equal(t, auth.InvalidCredentialsError, err, "a code is only accepted once")
+
+	now = now.Add(totp.DefaultPeriod)
+	tokens, err = authUsecase.IssueTokens("testuser", "password", code(), "")
+	require.NoError(t, err)
+	assert.False(t, tokens.TOTPEnrollment)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	_, err = authUsecase.IssueTokens("testuser", "password", strings.ToUpper(recoveryCodes[0]), "")
+	require.NoError(t, err)
+	_, err = authUsecase.IssueTokens("testuser", "password", recoveryCodes[0], "")
+	assert.Equal(t, auth.InvalidCredentialsError, err, "a recovery code is only accepted once")
+
+	status, err := authUsecase.GetTOTPStatus(userId)
+	require.NoError(t, err)
+	assert.Equal(t, &auth.TOTPStatus{Enabled: true, Required: true, RecoveryCodes: 9}, status)
+
+	regenerated, err := authUsecase.RegenerateRecoveryCodes(userId, recoveryCodes[1])
+	require.NoError(t, err)
+	assert.Equal(t, auth.InvalidTOTPCodeError, authUsecase.DisableTOTP(userId, recoveryCodes[2]))
+	require.NoError(t, authUsecase.DisableTOTP(userId, regenerated[0]))
+
+	// Sessions of users whose second factor is gone end with their next
+	// refresh.
+	_, err = authUsecase.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, auth.InvalidTokenError, err)
 
-	assert.True(t, authUsecase.AdminAuthorization("testadmin", "password"))
-	assert.False(t, authUsecase.AdminAuthorization("testuser", "password"))
-	assert.False(t, authUsecase.AdminAuthorization("testadmin", "wrongpassword"))
-	assert.False(t, authUsecase.AdminAuthorization("nonexistentuser", "password"))
+	status, err = authUsecase.GetTOTPStatus(userId)
+	require.NoError(t, err)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, auth.TOTPNotFoundError, authUsecase.ResetTOTP(userId))
 }
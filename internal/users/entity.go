@@ -1,11 +1,123 @@
 package users
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type User struct {
 	Id       uuid.UUID `json:"id,omitempty"`
 	Email    string    `json:"email"`
 	Username string    `json:"username"`
-	Admin    bool      `json:"admin"`
-	Password string    `json:"password,omitempty"`
+	Roles    []string  `json:"roles"`
+	// ServiceAccount users have no password and sign in with API keys only.
+	ServiceAccount bool `json:"serviceAccount"`
+	// EmailVerified is set once the user followed the link mailed to Email,
+	// and cleared when Email changes.
+	EmailVerified bool `json:"emailVerified"`
+	// Pending users were invited and have not accepted the invite yet. They
+	// have no password, and their username is their email until then.
+	Pending bool `json:"pending"`
+	// Status is one of StatusActive, StatusSuspended and StatusDeactivated.
+	// StatusReason tells why the user is not active. A suspension ends at
+	// SuspendedUntil, or lasts until the user is reactivated if it is zero.
+	Status         string    `json:"status"`
+	StatusReason   string    `json:"statusReason,omitempty"`
+	SuspendedUntil time.Time `json:"suspendedUntil"`
+	Password       string    `json:"password,omitempty"`
+	Version        uint64    `json:"version"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+const (
+	StatusActive      = "active"
+	StatusSuspended   = "suspended"
+	StatusDeactivated = "deactivated"
+)
+
+// StatusAt returns the status of the user at now. A suspension that has
+// ended counts as active.
+func (u *User) StatusAt(now time.Time) string {
+	if u.Status == StatusSuspended && !u.SuspendedUntil.IsZero() && !u.SuspendedUntil.After(now) {
+		return StatusActive
+	}
+
+	return u.Status
+}
+
+const (
+	SortByUsername  = "username"
+	SortByEmail     = "email"
+	SortByCreatedAt = "createdAt"
+)
+
+// UsersFilter selects users by all of its non-zero fields.
+type UsersFilter struct {
+	// Role keeps users that have the role, WithoutRole those that do not.
+	Role           string
+	WithoutRole    string
+	UsernamePrefix string
+	EmailPrefix    string
+	CreatedAfter   time.Time
+	EmailVerified  *bool
+}
+
+type UsersQuery struct {
+	Filter UsersFilter
+	SortBy string
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+}
+
+type UsersPage struct {
+	Users      []*User
+	NextCursor string
+}
+
+// Rules of the password policy. They double as the keys of the translations of
+// their violations, which get the field and the parameter of the violation.
+const (
+	PasswordMinLength = "password_min_length"
+	PasswordClasses   = "password_classes"
+	PasswordUsername  = "password_username"
+	PasswordEmail     = "password_email"
+	PasswordBreached  = "password_breached"
+)
+
+// PasswordViolation is a rule of the password policy a password breaks.
+type PasswordViolation struct {
+	Rule  string
+	Param string
+}
+
+// ResetToken lets a user who forgot the password set a new one. Hash is the
+// SHA-256 of the token, which is only ever mailed to the user.
+type ResetToken struct {
+	Hash      []byte
+	UserId    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Invite lets an invited user pick a username and password. Hash is the
+// SHA-256 of the token, which is only ever mailed to the user.
+type Invite struct {
+	Hash      []byte
+	UserId    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Invitation is a pending user with the invite last mailed to it. SentAt and
+// ExpiresAt are zero when the invite was lost with a restart; resending it
+// mails a new one.
+type Invitation struct {
+	UserId    uuid.UUID
+	Email     string
+	Roles     []string
+	CreatedAt time.Time
+	SentAt    time.Time
+	ExpiresAt time.Time
 }
@@ -1,9 +1,53 @@
 package users
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var UserNotFoundError = errors.New("user not found")
 
 var UserAlreadyExistsError = errors.New("user with this username already exists")
 
+var EmailAlreadyExistsError = errors.New("user with this email already exists")
+
+var UserVersionMismatchError = errors.New("user has been modified since the given version")
+
+var UnknownRoleError = errors.New("unknown role")
+
+var ServiceAccountPasswordError = errors.New("service accounts have no password")
+
+var InvalidCursorError = errors.New("invalid cursor")
+
+var InvalidSortFieldError = errors.New("invalid sort field")
+
+var ResetTokenNotFoundError = errors.New("password reset token not found")
+
+var InvalidResetTokenError = errors.New("invalid or expired password reset token")
+
+var InvalidVerificationTokenError = errors.New("invalid or expired email verification token")
+
+var EmailAlreadyVerifiedError = errors.New("email already verified")
+
+var InviteNotFoundError = errors.New("invite not found")
+
+var InvalidInviteTokenError = errors.New("invalid or expired invite token")
+
+var SuspensionEndedError = errors.New("suspension must end in the future")
+
 var UnknownError = errors.New("unknown error")
+
+// PasswordPolicyError lists the rules of the password policy a password
+// breaks.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		rules[i] = violation.Rule
+	}
+
+	return "password breaks the password policy: " + strings.Join(rules, ", ")
+}
@@ -5,7 +5,30 @@ import "github.com/google/uuid"
 type Repository interface {
 	CreateUser(user *User) (uuid.UUID, error)
 	GetUserById(id uuid.UUID) (*User, error)
+	GetUserByEmail(email string) (*User, error)
 	GetUsers() []*User
+	FindUsers(query UsersQuery) (*UsersPage, error)
 	UpdateUser(user *User) error
-	DeleteUser(id uuid.UUID)
+	DeleteUser(id uuid.UUID, version uint64) error
+	WithinTransaction(fn func(repository Repository) error) error
+}
+
+// ResetTokenRepository keeps at most one password reset token a user.
+type ResetTokenRepository interface {
+	// CreateResetToken replaces the token the user had.
+	CreateResetToken(token *ResetToken) error
+	GetResetToken(hash []byte) (*ResetToken, error)
+	GetUserResetToken(userId uuid.UUID) (*ResetToken, error)
+	// DeleteResetToken fails with ResetTokenNotFoundError for a token that
+	// was deleted already, so only one caller can use a token.
+	DeleteResetToken(hash []byte) error
+}
+
+// InviteRepository keeps at most one invite a user.
+type InviteRepository interface {
+	// CreateInvite replaces the invite the user had.
+	CreateInvite(invite *Invite) error
+	GetInvite(hash []byte) (*Invite, error)
+	GetUserInvite(userId uuid.UUID) (*Invite, error)
+	DeleteUserInvite(userId uuid.UUID) error
 }
@@ -1,6 +1,11 @@
 package repository
 
 import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/omelaymy/users/internal/users"
 )
@@ -20,21 +25,53 @@ func (f *FakeRepository) CreateUser(user *users.User) (uuid.UUID, error) {
 		if u.Username == user.Username {
 			return uuid.UUID{}, users.UserAlreadyExistsError
 		}
+		if u.Email == user.Email {
+			return uuid.UUID{}, users.EmailAlreadyExistsError
+		}
 	}
 
 	user.Id = uuid.New()
-	f.users[user.Id] = user
+	user.Version = 1
+	user.CreatedAt = time.Now()
+	if user.Status == "" {
+		user.Status = users.StatusActive
+	}
+	stored := *user
+	f.users[user.Id] = &stored
 
 	return user.Id, nil
 }
 
+// GetUserById returns a copy of the user without its password, like
+// UsersRepository.
 func (f *FakeRepository) GetUserById(id uuid.UUID) (*users.User, error) {
 	user, ok := f.users[id]
 	if !ok {
 		return nil, users.UserNotFoundError
 	}
 
-	return user, nil
+	copied := *user
+	copied.Password = ""
+	return &copied, nil
+}
+
+// GetPassword returns the stored password hash of the user.
+func (f *FakeRepository) GetPassword(id uuid.UUID) string {
+	if user, ok := f.users[id]; ok {
+		return user.Password
+	}
+
+	return ""
+}
+
+func (f *FakeRepository) GetUserByEmail(email string) (*users.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, users.UserNotFoundError
 }
 
 func (f *FakeRepository) GetUsers() []*users.User {
@@ -46,22 +83,112 @@ func (f *FakeRepository) GetUsers() []*users.User {
 	return users
 }
 
+// FindUsers filters and sorts all users on every call; its cursors are
+// offsets into the result.
+func (f *FakeRepository) FindUsers(query users.UsersQuery) (*users.UsersPage, error) {
+	filter := query.Filter
+
+	var found []*users.User
+	for _, user := range f.users {
+		if filter.Role != "" && !hasRole(user, filter.Role) ||
+			filter.WithoutRole != "" && hasRole(user, filter.WithoutRole) ||
+			!strings.HasPrefix(user.Username, filter.UsernamePrefix) ||
+			!strings.HasPrefix(user.Email, filter.EmailPrefix) ||
+			!filter.CreatedAfter.IsZero() && !user.CreatedAt.After(filter.CreatedAfter) ||
+			filter.EmailVerified != nil && user.EmailVerified != *filter.EmailVerified {
+			continue
+		}
+		found = append(found, user)
+	}
+
+	var less func(a, b *users.User) bool
+	switch query.SortBy {
+	case "", users.SortByUsername:
+		less = func(a, b *users.User) bool { return a.Username < b.Username }
+	case users.SortByEmail:
+		less = func(a, b *users.User) bool { return a.Email < b.Email }
+	case users.SortByCreatedAt:
+		less = func(a, b *users.User) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	default:
+		return nil, users.InvalidSortFieldError
+	}
+	sort.SliceStable(found, func(i, j int) bool { return less(found[i], found[j]) })
+
+	offset := 0
+	if query.Cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(query.Cursor); err != nil || offset > len(found) {
+			return nil, users.InvalidCursorError
+		}
+	}
+	found = found[offset:]
+
+	page := &users.UsersPage{Users: found}
+	if query.Limit > 0 && len(found) > query.Limit {
+		page.Users = found[:query.Limit]
+		page.NextCursor = strconv.Itoa(offset + query.Limit)
+	}
+
+	return page, nil
+}
+
 func (f *FakeRepository) UpdateUser(user *users.User) error {
 	for _, u := range f.users {
 		if u.Id != user.Id && u.Username == user.Username {
 			return users.UserAlreadyExistsError
 		}
+		if u.Id != user.Id && u.Email == user.Email {
+			return users.EmailAlreadyExistsError
+		}
 	}
 
-	_, ok := f.users[user.Id]
+	existing, ok := f.users[user.Id]
 	if !ok {
 		return users.UserNotFoundError
 	}
+	if user.Version != 0 && user.Version != existing.Version {
+		return users.UserVersionMismatchError
+	}
 
-	f.users[user.Id] = user
+	user.Version = existing.Version + 1
+	if user.Password == "" {
+		user.Password = existing.Password
+	}
+	stored := *user
+	f.users[user.Id] = &stored
 	return nil
 }
 
-func (f *FakeRepository) DeleteUser(id uuid.UUID) {
+func (f *FakeRepository) DeleteUser(id uuid.UUID, version uint64) error {
+	if user, ok := f.users[id]; version != 0 && (!ok || user.Version != version) {
+		return users.UserVersionMismatchError
+	}
+
 	delete(f.users, id)
+	return nil
+}
+
+func (f *FakeRepository) WithinTransaction(fn func(repository users.Repository) error) error {
+	saved := make(map[uuid.UUID]*users.User, len(f.users))
+	for id, user := range f.users {
+		copied := *user
+		saved[id] = &copied
+	}
+
+	if err := fn(f); err != nil {
+		f.users = saved
+		return err
+	}
+
+	return nil
+}
+
+func hasRole(user *users.User, role string) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// InviteRepository keeps invites in memory. They are lost on restart, after
+// which admins resend them; the pending users stay.
+type InviteRepository struct {
+	mu      sync.Mutex
+	invites map[string]*users.Invite
+	byUser  map[uuid.UUID]string
+}
+
+func NewInviteRepository() *InviteRepository {
+	return &InviteRepository{
+		invites: make(map[string]*users.Invite),
+		byUser:  make(map[uuid.UUID]string),
+	}
+}
+
+func (r *InviteRepository) CreateInvite(invite *users.Invite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, ok := r.byUser[invite.UserId]; ok {
+		delete(r.invites, key)
+	}
+
+	stored := *invite
+	key := hex.EncodeToString(invite.Hash)
+	r.invites[key] = &stored
+	r.byUser[invite.UserId] = key
+
+	return nil
+}
+
+func (r *InviteRepository) GetInvite(hash []byte) (*users.Invite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.invites[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, users.InviteNotFoundError
+	}
+
+	res := *invite
+	return &res, nil
+}
+
+func (r *InviteRepository) GetUserInvite(userId uuid.UUID) (*users.Invite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.byUser[userId]
+	if !ok {
+		return nil, users.InviteNotFoundError
+	}
+
+	res := *r.invites[key]
+	return &res, nil
+}
+
+func (r *InviteRepository) DeleteUserInvite(userId uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.byUser[userId]
+	if !ok {
+		return users.InviteNotFoundError
+	}
+	delete(r.invites, key)
+	delete(r.byUser, userId)
+
+	return nil
+}
@@ -9,9 +9,21 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// store is implemented by both the database and its transactions.
+type store interface {
+	InsertUser(user inmemory.User) (uuid.UUID, error)
+	GetUserById(id uuid.UUID) (inmemory.User, error)
+	GetUserByEmail(email string) (inmemory.User, error)
+	GetUsers() []inmemory.User
+	FindUsers(query inmemory.Query) (inmemory.Page, error)
+	UpdateUser(user inmemory.User) error
+	DeleteUser(id uuid.UUID, version uint64) error
+}
+
 type UsersRepository struct {
-	db  *inmemory.InMemoryDatabase
-	log *zerolog.Logger
+	db    *inmemory.InMemoryDatabase
+	store store
+	log   *zerolog.Logger
 }
 
 func NewUsersRepository(
@@ -19,24 +31,37 @@ func NewUsersRepository(
 	log *zerolog.Logger,
 ) *UsersRepository {
 	return &UsersRepository{
-		db:  db,
-		log: log,
+		db:    db,
+		store: db,
+		log:   log,
 	}
 }
 
 func (r *UsersRepository) CreateUser(user *users.User) (uuid.UUID, error) {
-	id, err := r.db.InsertUser(
+	id, err := r.store.InsertUser(
 		inmemory.User{
-			Email:    user.Email,
-			Username: user.Username,
-			Password: user.Password,
-			Admin:    user.Admin,
+			Email:          user.Email,
+			Username:       user.Username,
+			Password:       user.Password,
+			Roles:          user.Roles,
+			ServiceAccount: user.ServiceAccount,
+			EmailVerified:  user.EmailVerified,
+			Pending:        user.Pending,
+			Status:         statusToDB(user.Status),
+			StatusReason:   user.StatusReason,
+			SuspendedUntil: user.SuspendedUntil,
 		},
 	)
 	if err != nil {
 		if errors.Is(err, inmemory.AlreadyExistsError) {
 			return uuid.UUID{}, users.UserAlreadyExistsError
 		}
+		if errors.Is(err, inmemory.EmailAlreadyExistsError) {
+			return uuid.UUID{}, users.EmailAlreadyExistsError
+		}
+		if errors.Is(err, inmemory.UnknownRoleError) {
+			return uuid.UUID{}, users.UnknownRoleError
+		}
 		return uuid.UUID{}, users.UnknownError
 	}
 
@@ -44,7 +69,32 @@ func (r *UsersRepository) CreateUser(user *users.User) (uuid.UUID, error) {
 }
 
 func (r *UsersRepository) GetUserById(id uuid.UUID) (*users.User, error) {
-	user, err := r.db.GetUserById(id)
+	user, err := r.store.GetUserById(id)
+	if err != nil {
+		if errors.Is(err, inmemory.NotFoundError) {
+			return nil, users.UserNotFoundError
+		}
+		return nil, users.UnknownError
+	}
+
+	return &users.User{
+		Id:             user.ID,
+		Email:          user.Email,
+		Username:       user.Username,
+		Roles:          user.Roles,
+		ServiceAccount: user.ServiceAccount,
+		EmailVerified:  user.EmailVerified,
+		Pending:        user.Pending,
+		Status:         statusFromDB(user.Status),
+		StatusReason:   user.StatusReason,
+		SuspendedUntil: user.SuspendedUntil,
+		Version:        user.Version,
+		CreatedAt:      user.CreatedAt,
+	}, nil
+}
+
+func (r *UsersRepository) GetUserByEmail(email string) (*users.User, error) {
+	user, err := r.store.GetUserByEmail(email)
 	if err != nil {
 		if errors.Is(err, inmemory.NotFoundError) {
 			return nil, users.UserNotFoundError
@@ -53,53 +103,180 @@ func (r *UsersRepository) GetUserById(id uuid.UUID) (*users.User, error) {
 	}
 
 	return &users.User{
-		Id:       user.ID,
-		Email:    user.Email,
-		Username: user.Username,
-		Admin:    user.Admin,
+		Id:             user.ID,
+		Email:          user.Email,
+		Username:       user.Username,
+		Roles:          user.Roles,
+		ServiceAccount: user.ServiceAccount,
+		EmailVerified:  user.EmailVerified,
+		Pending:        user.Pending,
+		Status:         statusFromDB(user.Status),
+		StatusReason:   user.StatusReason,
+		SuspendedUntil: user.SuspendedUntil,
+		Version:        user.Version,
+		CreatedAt:      user.CreatedAt,
 	}, nil
 }
 
 func (r *UsersRepository) GetUsers() []*users.User {
-	return castUsersFromDB(r.db.GetUsers())
+	return castUsersFromDB(r.store.GetUsers())
+}
+
+func (r *UsersRepository) FindUsers(query users.UsersQuery) (*users.UsersPage, error) {
+	page, err := r.store.FindUsers(inmemory.Query{
+		Filter: inmemory.UserFilter{
+			Role:           query.Filter.Role,
+			WithoutRole:    query.Filter.WithoutRole,
+			UsernamePrefix: query.Filter.UsernamePrefix,
+			EmailPrefix:    query.Filter.EmailPrefix,
+			CreatedAfter:   query.Filter.CreatedAfter,
+			EmailVerified:  query.Filter.EmailVerified,
+		},
+		SortBy: inmemory.SortField(query.SortBy),
+		Cursor: query.Cursor,
+		Limit:  query.Limit,
+	})
+	if err != nil {
+		if errors.Is(err, inmemory.InvalidCursorError) {
+			return nil, users.InvalidCursorError
+		}
+		if errors.Is(err, inmemory.InvalidSortFieldError) {
+			return nil, users.InvalidSortFieldError
+		}
+		return nil, users.UnknownError
+	}
+
+	return &users.UsersPage{
+		Users:      castUsersFromDB(page.Users),
+		NextCursor: page.NextCursor,
+	}, nil
 }
 
 func (r *UsersRepository) UpdateUser(user *users.User) error {
-	err := r.db.UpdateUser(
+	err := r.store.UpdateUser(
 		inmemory.User{
-			ID:       user.Id,
-			Email:    user.Email,
-			Username: user.Username,
-			Admin:    user.Admin,
+			ID:             user.Id,
+			Email:          user.Email,
+			Username:       user.Username,
+			Password:       user.Password,
+			Roles:          user.Roles,
+			EmailVerified:  user.EmailVerified,
+			Pending:        user.Pending,
+			Status:         statusToDB(user.Status),
+			StatusReason:   user.StatusReason,
+			SuspendedUntil: user.SuspendedUntil,
+			Version:        user.Version,
 		},
 	)
 	if err != nil {
 		if errors.Is(err, inmemory.NotFoundError) {
 			return users.UserNotFoundError
 		}
+		if errors.Is(err, inmemory.VersionMismatchError) {
+			return users.UserVersionMismatchError
+		}
 		if errors.Is(err, inmemory.AlreadyExistsError) {
 			return users.UserAlreadyExistsError
 		}
+		if errors.Is(err, inmemory.EmailAlreadyExistsError) {
+			return users.EmailAlreadyExistsError
+		}
+		if errors.Is(err, inmemory.UnknownRoleError) {
+			return users.UnknownRoleError
+		}
 		return users.UnknownError
 	}
 
 	return nil
 }
 
-func (r *UsersRepository) DeleteUser(id uuid.UUID) {
-	r.db.DeleteUser(id)
+func (r *UsersRepository) DeleteUser(id uuid.UUID, version uint64) error {
+	if err := r.store.DeleteUser(id, version); err != nil {
+		if errors.Is(err, inmemory.VersionMismatchError) {
+			return users.UserVersionMismatchError
+		}
+		r.log.Err(err).Msg("failed to delete user")
+		return users.UnknownError
+	}
+
+	return nil
+}
+
+// WithinTransaction runs fn against a repository whose writes are committed
+// together once fn returns nil, or discarded if it returns an error. Calls
+// made inside fn on the passed repository join the same transaction.
+func (r *UsersRepository) WithinTransaction(fn func(repository users.Repository) error) error {
+	if _, ok := r.store.(*inmemory.Txn); ok {
+		return fn(r)
+	}
+
+	tx := r.db.Begin()
+	err := fn(&UsersRepository{
+		db:    r.db,
+		store: tx,
+		log:   r.log,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		switch {
+		case errors.Is(err, inmemory.NotFoundError):
+			return users.UserNotFoundError
+		case errors.Is(err, inmemory.AlreadyExistsError):
+			return users.UserAlreadyExistsError
+		case errors.Is(err, inmemory.EmailAlreadyExistsError):
+			return users.EmailAlreadyExistsError
+		case errors.Is(err, inmemory.VersionMismatchError):
+			return users.UserVersionMismatchError
+		case errors.Is(err, inmemory.UnknownRoleError):
+			return users.UnknownRoleError
+		}
+		r.log.Err(err).Msg("failed to commit transaction")
+		return users.UnknownError
+	}
+
+	return nil
 }
 
 func castUsersFromDB(inmemoryUsers []inmemory.User) []*users.User {
 	res := make([]*users.User, len(inmemoryUsers))
 	for i, user := range inmemoryUsers {
 		res[i] = &users.User{
-			Id:       user.ID,
-			Email:    user.Email,
-			Username: user.Username,
-			Admin:    user.Admin,
+			Id:             user.ID,
+			Email:          user.Email,
+			Username:       user.Username,
+			Roles:          user.Roles,
+			ServiceAccount: user.ServiceAccount,
+			EmailVerified:  user.EmailVerified,
+			Pending:        user.Pending,
+			Status:         statusFromDB(user.Status),
+			StatusReason:   user.StatusReason,
+			SuspendedUntil: user.SuspendedUntil,
+			Version:        user.Version,
+			CreatedAt:      user.CreatedAt,
 		}
 	}
 
 	return res
 }
+
+// Active users are stored without a status, like the users stored before
+// there were statuses.
+func statusToDB(status string) string {
+	if status == users.StatusActive {
+		return ""
+	}
+
+	return status
+}
+
+func statusFromDB(status string) string {
+	if status == "" {
+		return users.StatusActive
+	}
+
+	return status
+}
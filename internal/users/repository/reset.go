@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// ResetTokenRepository keeps password reset tokens in memory. They are lost
+// on restart, after which users ask for new ones.
+type ResetTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*users.ResetToken
+	byUser map[uuid.UUID]string
+}
+
+func NewResetTokenRepository() *ResetTokenRepository {
+	return &ResetTokenRepository{
+		tokens: make(map[string]*users.ResetToken),
+		byUser: make(map[uuid.UUID]string),
+	}
+}
+
+func (r *ResetTokenRepository) CreateResetToken(token *users.ResetToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Tokens nobody used are dropped here, so they do not pile up.
+	now := time.Now()
+	for key, stored := range r.tokens {
+		if !stored.ExpiresAt.After(now) {
+			r.delete(key)
+		}
+	}
+	if key, ok := r.byUser[token.UserId]; ok {
+		r.delete(key)
+	}
+
+	stored := *token
+	key := hex.EncodeToString(token.Hash)
+	r.tokens[key] = &stored
+	r.byUser[token.UserId] = key
+
+	return nil
+}
+
+func (r *ResetTokenRepository) GetResetToken(hash []byte) (*users.ResetToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, users.ResetTokenNotFoundError
+	}
+
+	res := *token
+	return &res, nil
+}
+
+func (r *ResetTokenRepository) GetUserResetToken(userId uuid.UUID) (*users.ResetToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.byUser[userId]
+	if !ok {
+		return nil, users.ResetTokenNotFoundError
+	}
+
+	res := *r.tokens[key]
+	return &res, nil
+}
+
+func (r *ResetTokenRepository) DeleteResetToken(hash []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	if _, ok := r.tokens[key]; !ok {
+		return users.ResetTokenNotFoundError
+	}
+	r.delete(key)
+
+	return nil
+}
+
+func (r *ResetTokenRepository) delete(key string) {
+	delete(r.byUser, r.tokens[key].UserId)
+	delete(r.tokens, key)
+}
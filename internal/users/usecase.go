@@ -1,11 +1,66 @@
 package users
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/pkg/mail"
+)
 
 type Usecase interface {
 	CreateUser(user *User) (uuid.UUID, error)
+	CreateUsers(users []*User) ([]uuid.UUID, error)
 	GetUser(id uuid.UUID) (*User, error)
 	GetUsers() []*User
+	FindUsers(query UsersQuery) (*UsersPage, error)
 	UpdateUser(user *User) error
-	DeleteUser(id uuid.UUID)
+	PatchUser(id uuid.UUID, version uint64, patch func(user *User) error) error
+	DeleteUser(id uuid.UUID, version uint64) error
+	ResetPassword(id uuid.UUID, password string) error
+}
+
+type PasswordResetUsecase interface {
+	RequestPasswordReset(email string) error
+	ConfirmPasswordReset(token, password string) error
+}
+
+type EmailVerificationUsecase interface {
+	SendVerification(id uuid.UUID) error
+	VerifyEmail(token string) error
+}
+
+type InvitationUsecase interface {
+	Invite(email string, roles []string) (uuid.UUID, error)
+	GetInvitations() ([]*Invitation, error)
+	ResendInvite(id uuid.UUID) error
+	RevokeInvite(id uuid.UUID) error
+	AcceptInvite(token, username, password string) (uuid.UUID, error)
+}
+
+type AccountStatusUsecase interface {
+	Suspend(id uuid.UUID, reason string, until time.Time) error
+	Deactivate(id uuid.UUID, reason string) error
+	Reactivate(id uuid.UUID) error
+}
+
+// EmailVerifier mails users the link that verifies their email. It reports
+// failures itself, as the change that needs verifying is stored already and
+// users can ask for another mail.
+type EmailVerifier interface {
+	RequestVerification(id uuid.UUID)
+}
+
+// SessionRevoker signs users out of every session.
+type SessionRevoker interface {
+	RevokeUserSessions(userId uuid.UUID) error
+}
+
+// BreachedPasswords tells passwords known from breaches.
+type BreachedPasswords interface {
+	Breached(password string) (bool, error)
+}
+
+// Mailer delivers emails to users.
+type Mailer interface {
+	Send(message mail.Message) error
 }
@@ -0,0 +1,253 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/config"
+	"github.com/omelaymy/users/internal/users"
+	"github.com/omelaymy/users/pkg/mail"
+)
+
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+// Invitations lets admins invite users by email. An invite creates a pending
+// user without a password, which the invitee turns into a regular user by
+// picking a username and password with the token mailed to them. It reads
+// the time from clock, so tests can control when invites expire.
+type Invitations struct {
+	cfg        *config.Config
+	repository users.Repository
+	invites    users.InviteRepository
+	users      *Users
+	mailer     users.Mailer
+	clock      func() time.Time
+}
+
+func NewInvitations(
+	cfg *config.Config,
+	repository users.Repository,
+	invites users.InviteRepository,
+	usersUsecase *Users,
+	mailer users.Mailer,
+	clock func() time.Time,
+) *Invitations {
+	return &Invitations{
+		cfg:        cfg,
+		repository: repository,
+		invites:    invites,
+		users:      usersUsecase,
+		mailer:     mailer,
+		clock:      clock,
+	}
+}
+
+// Invite creates a pending user with the given email and roles and mails it
+// an invite. The user is removed again if the mail cannot be sent, as nobody
+// could accept the invite.
+func (i *Invitations) Invite(email string, roles []string) (uuid.UUID, error) {
+	// The email doubles as the username of pending users, so a taken email
+	// would otherwise be reported as a taken username.
+	if _, err := i.repository.GetUserByEmail(email); err == nil {
+		return uuid.UUID{}, users.EmailAlreadyExistsError
+	} else if !errors.Is(err, users.UserNotFoundError) {
+		return uuid.UUID{}, err
+	}
+
+	user := &users.User{
+		Email:    email,
+		Username: email,
+		Roles:    roles,
+		Pending:  true,
+	}
+	id, err := i.repository.CreateUser(user)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	user.Id = id
+
+	if err = i.send(user); err != nil {
+		_ = i.repository.DeleteUser(id, 0)
+		_ = i.invites.DeleteUserInvite(id)
+		return uuid.UUID{}, err
+	}
+
+	return id, nil
+}
+
+// GetInvitations lists the pending users, oldest first.
+func (i *Invitations) GetInvitations() ([]*users.Invitation, error) {
+	var invitations []*users.Invitation
+	for _, user := range i.repository.GetUsers() {
+		if !user.Pending {
+			continue
+		}
+
+		invitation := &users.Invitation{
+			UserId:    user.Id,
+			Email:     user.Email,
+			Roles:     user.Roles,
+			CreatedAt: user.CreatedAt,
+		}
+		invite, err := i.invites.GetUserInvite(user.Id)
+		if err != nil && !errors.Is(err, users.InviteNotFoundError) {
+			return nil, err
+		}
+		if invite != nil {
+			invitation.SentAt = invite.CreatedAt
+			invitation.ExpiresAt = invite.ExpiresAt
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	sort.Slice(invitations, func(a, b int) bool {
+		if !invitations[a].CreatedAt.Equal(invitations[b].CreatedAt) {
+			return invitations[a].CreatedAt.Before(invitations[b].CreatedAt)
+		}
+		return invitations[a].Email < invitations[b].Email
+	})
+
+	return invitations, nil
+}
+
+// ResendInvite mails a pending user a new invite, which replaces the one the
+// user had.
+func (i *Invitations) ResendInvite(id uuid.UUID) error {
+	user, err := i.pendingUser(i.repository, id)
+	if err != nil {
+		return err
+	}
+
+	return i.send(user)
+}
+
+// RevokeInvite deletes a pending user together with its invite.
+func (i *Invitations) RevokeInvite(id uuid.UUID) error {
+	err := i.repository.WithinTransaction(func(repository users.Repository) error {
+		user, err := i.pendingUser(repository, id)
+		if err != nil {
+			return err
+		}
+
+		return repository.DeleteUser(id, user.Version)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = i.invites.DeleteUserInvite(id); err != nil && !errors.Is(err, users.InviteNotFoundError) {
+		return err
+	}
+	return nil
+}
+
+// AcceptInvite gives the pending user of an invite the username and password
+// of the invitee. The email counts as verified, as the invite was mailed to
+// it. A username that is taken or a password that breaks the password policy
+// leaves the invite for another try; otherwise it is used up.
+func (i *Invitations) AcceptInvite(token, username, password string) (uuid.UUID, error) {
+	invite, err := i.invites.GetInvite(hashToken(token))
+	if err != nil {
+		if errors.Is(err, users.InviteNotFoundError) {
+			return uuid.UUID{}, users.InvalidInviteTokenError
+		}
+		return uuid.UUID{}, err
+	}
+	if !invite.ExpiresAt.After(i.clock()) {
+		return uuid.UUID{}, users.InvalidInviteTokenError
+	}
+
+	err = i.repository.WithinTransaction(func(repository users.Repository) error {
+		user, err := i.pendingUser(repository, invite.UserId)
+		if err != nil {
+			if errors.Is(err, users.InviteNotFoundError) {
+				return users.InvalidInviteTokenError
+			}
+			return err
+		}
+
+		user.Username = username
+		user.Password = password
+		user.Pending = false
+		user.EmailVerified = true
+		if err = i.users.hashPassword(user); err != nil {
+			return err
+		}
+
+		return repository.UpdateUser(user)
+	})
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if err = i.invites.DeleteUserInvite(invite.UserId); err != nil && !errors.Is(err, users.InviteNotFoundError) {
+		return uuid.UUID{}, err
+	}
+	return invite.UserId, nil
+}
+
+// pendingUser returns the user with the given id if it is pending, and
+// InviteNotFoundError otherwise.
+func (i *Invitations) pendingUser(repository users.Repository, id uuid.UUID) (*users.User, error) {
+	user, err := repository.GetUserById(id)
+	if err != nil {
+		if errors.Is(err, users.UserNotFoundError) {
+			return nil, users.InviteNotFoundError
+		}
+		return nil, err
+	}
+	if !user.Pending {
+		return nil, users.InviteNotFoundError
+	}
+
+	return user, nil
+}
+
+func (i *Invitations) send(user *users.User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	now := i.clock()
+	ttl := i.cfg.Auth.Invitations.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultInviteTTL
+	}
+	err = i.invites.CreateInvite(&users.Invite{
+		Hash:      hashToken(token),
+		UserId:    user.Id,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return i.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "You have been invited",
+		Body:    i.inviteBody(token, ttl),
+	})
+}
+
+func (i *Invitations) inviteBody(token string, ttl time.Duration) string {
+	body := "You have been invited to create an account.\n\n"
+
+	if link, err := url.Parse(i.cfg.Auth.Invitations.URL); err == nil && link.Host != "" {
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		body += fmt.Sprintf("Pick a username and password at %s\n\nor with this token: %s\n\n", link, token)
+	} else {
+		body += fmt.Sprintf("Pick a username and password with this token: %s\n\n", token)
+	}
+
+	return body + fmt.Sprintf(
+		"The invite can be used once within %s. If you did not expect it, ignore this mail.\n", ttl,
+	)
+}
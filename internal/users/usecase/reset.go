@@ -0,0 +1,176 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/omelaymy/users/config"
+	"github.com/omelaymy/users/internal/users"
+	"github.com/omelaymy/users/pkg/mail"
+)
+
+const (
+	defaultResetTokenTTL = time.Hour
+	tokenLength          = 32
+	// resetRequestInterval is how long after a reset token was mailed to a
+	// user the next one is, so repeated requests cannot flood mailboxes.
+	resetRequestInterval = time.Minute
+)
+
+// PasswordReset lets users who forgot their password set a new one with a
+// token mailed to them. It reads the time from clock, so tests can control
+// when tokens expire.
+type PasswordReset struct {
+	cfg        *config.Config
+	repository users.Repository
+	tokens     users.ResetTokenRepository
+	users      *Users
+	mailer     users.Mailer
+	clock      func() time.Time
+}
+
+func NewPasswordReset(
+	cfg *config.Config,
+	repository users.Repository,
+	tokens users.ResetTokenRepository,
+	usersUsecase *Users,
+	mailer users.Mailer,
+	clock func() time.Time,
+) *PasswordReset {
+	return &PasswordReset{
+		cfg:        cfg,
+		repository: repository,
+		tokens:     tokens,
+		users:      usersUsecase,
+		mailer:     mailer,
+		clock:      clock,
+	}
+}
+
+// RequestPasswordReset mails a reset token to the user with the given email,
+// replacing the token the user had. Unknown emails, service accounts and
+// pending users, who accept their invite instead, get no mail and no error,
+// so callers cannot tell which emails have users.
+func (p *PasswordReset) RequestPasswordReset(email string) error {
+	user, err := p.repository.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, users.UserNotFoundError) {
+			return nil
+		}
+		return err
+	}
+	if user.ServiceAccount || user.Pending {
+		return nil
+	}
+
+	now := p.clock()
+	last, err := p.tokens.GetUserResetToken(user.Id)
+	if err != nil && !errors.Is(err, users.ResetTokenNotFoundError) {
+		return err
+	}
+	if last != nil && now.Sub(last.CreatedAt) < resetRequestInterval {
+		return nil
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := p.cfg.Auth.PasswordReset.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultResetTokenTTL
+	}
+	err = p.tokens.CreateResetToken(&users.ResetToken{
+		Hash:      hashToken(token),
+		UserId:    user.Id,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    p.resetBody(user, token, ttl),
+	})
+}
+
+// ConfirmPasswordReset sets a new password with a reset token and signs the
+// user out everywhere. A password that breaks the password policy leaves the
+// token for another try; otherwise the token is used up.
+func (p *PasswordReset) ConfirmPasswordReset(token, password string) error {
+	hash := hashToken(token)
+	stored, err := p.tokens.GetResetToken(hash)
+	if err != nil {
+		if errors.Is(err, users.ResetTokenNotFoundError) {
+			return users.InvalidResetTokenError
+		}
+		return err
+	}
+	if !stored.ExpiresAt.After(p.clock()) {
+		return users.InvalidResetTokenError
+	}
+
+	user, err := p.repository.GetUserById(stored.UserId)
+	if err != nil {
+		if errors.Is(err, users.UserNotFoundError) {
+			return users.InvalidResetTokenError
+		}
+		return err
+	}
+	user.Password = password
+	if err = p.users.checkPassword(user); err != nil {
+		return err
+	}
+
+	if err = p.tokens.DeleteResetToken(hash); err != nil {
+		if errors.Is(err, users.ResetTokenNotFoundError) {
+			return users.InvalidResetTokenError
+		}
+		return err
+	}
+
+	return p.users.ResetPassword(user.Id, password)
+}
+
+func (p *PasswordReset) resetBody(user *users.User, token string, ttl time.Duration) string {
+	body := fmt.Sprintf("Somebody asked to reset the password of %s.\n\n", user.Username)
+
+	if link, err := url.Parse(p.cfg.Auth.PasswordReset.URL); err == nil && link.Host != "" {
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		body += fmt.Sprintf("Set a new password at %s\n\nor with this token: %s\n\n", link, token)
+	} else {
+		body += fmt.Sprintf("Set a new password with this token: %s\n\n", token)
+	}
+
+	return body + fmt.Sprintf(
+		"The token can be used once within %s. If you did not ask for it, ignore this mail.\n", ttl,
+	)
+}
+
+// newToken returns a random token to mail to a user.
+func newToken() (string, error) {
+	secret := make([]byte, tokenLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashToken uses a plain SHA-256, as the tokens of newToken are random and
+// long enough that a slow hash adds nothing.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/internal/users"
+)
+
+// AccountStatus suspends, deactivates and reactivates users. It reads the
+// time from clock, so tests can control when suspensions end.
+type AccountStatus struct {
+	repository users.Repository
+	sessions   users.SessionRevoker
+	clock      func() time.Time
+}
+
+func NewAccountStatus(
+	repository users.Repository,
+	sessions users.SessionRevoker,
+	clock func() time.Time,
+) *AccountStatus {
+	return &AccountStatus{
+		repository: repository,
+		sessions:   sessions,
+		clock:      clock,
+	}
+}
+
+// Suspend keeps a user from signing in until the given time, or until the
+// user is reactivated if it is zero, and signs the user out. Suspending a
+// suspended or deactivated user replaces its status.
+func (s *AccountStatus) Suspend(id uuid.UUID, reason string, until time.Time) error {
+	if !until.IsZero() && !until.After(s.clock()) {
+		return users.SuspensionEndedError
+	}
+
+	return s.setStatus(id, users.StatusSuspended, reason, until)
+}
+
+// Deactivate keeps a user from signing in until the user is reactivated, and
+// signs the user out.
+func (s *AccountStatus) Deactivate(id uuid.UUID, reason string) error {
+	return s.setStatus(id, users.StatusDeactivated, reason, time.Time{})
+}
+
+// Reactivate lets a suspended or deactivated user sign in again.
+func (s *AccountStatus) Reactivate(id uuid.UUID) error {
+	return s.setStatus(id, users.StatusActive, "", time.Time{})
+}
+
+func (s *AccountStatus) setStatus(id uuid.UUID, status, reason string, until time.Time) error {
+	err := s.repository.WithinTransaction(func(repository users.Repository) error {
+		user, err := repository.GetUserById(id)
+		if err != nil {
+			return err
+		}
+
+		user.Status = status
+		user.StatusReason = reason
+		user.SuspendedUntil = until
+
+		return repository.UpdateUser(user)
+	})
+	if err != nil {
+		return err
+	}
+
+	if status == users.StatusActive {
+		return nil
+	}
+	return s.sessions.RevokeUserSessions(id)
+}
@@ -1,6 +1,12 @@
 package usecase
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/google/uuid"
 	"github.com/omelaymy/users/config"
 	"github.com/omelaymy/users/internal/users"
@@ -10,26 +16,77 @@ import (
 type Users struct {
 	cfg        *config.Config
 	repository users.Repository
+	sessions   users.SessionRevoker
+	hasher     *secure.Hasher
+	breached   users.BreachedPasswords
+	verifier   users.EmailVerifier
 }
 
 func NewUsers(
 	cfg *config.Config,
 	repository users.Repository,
+	sessions users.SessionRevoker,
+	hasher *secure.Hasher,
+	breached users.BreachedPasswords,
+	verifier users.EmailVerifier,
 ) *Users {
 	return &Users{
 		cfg:        cfg,
 		repository: repository,
+		sessions:   sessions,
+		hasher:     hasher,
+		breached:   breached,
+		verifier:   verifier,
 	}
 }
 
+// CreateUser creates a user with an unverified email and mails the user a
+// link to verify it.
 func (u *Users) CreateUser(user *users.User) (uuid.UUID, error) {
-	hashedPassword, err := secure.HashPassword(user.Password)
+	if err := u.hashPassword(user); err != nil {
+		return uuid.UUID{}, err
+	}
+	user.EmailVerified = false
+
+	id, err := u.repository.CreateUser(user)
 	if err != nil {
-		return uuid.UUID{}, users.UnknownError
+		return uuid.UUID{}, err
+	}
+	u.verifyEmail(id, user)
+
+	return id, nil
+}
+
+// CreateUsers creates all of the given users or, if any of them cannot be
+// created, none of them.
+func (u *Users) CreateUsers(newUsers []*users.User) ([]uuid.UUID, error) {
+	for _, user := range newUsers {
+		if err := u.hashPassword(user); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = false
+	}
+
+	ids := make([]uuid.UUID, 0, len(newUsers))
+	err := u.repository.WithinTransaction(func(repository users.Repository) error {
+		for _, user := range newUsers {
+			id, err := repository.CreateUser(user)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		u.verifyEmail(id, newUsers[i])
 	}
-	user.Password = hashedPassword
 
-	return u.repository.CreateUser(user)
+	return ids, nil
 }
 
 func (u *Users) GetUser(id uuid.UUID) (*users.User, error) {
@@ -40,16 +97,269 @@ func (u *Users) GetUsers() []*users.User {
 	return u.repository.GetUsers()
 }
 
+func (u *Users) FindUsers(query users.UsersQuery) (*users.UsersPage, error) {
+	return u.repository.FindUsers(query)
+}
+
+// UpdateUser replaces the user; whether it is a service account or pending,
+// and its status, cannot change.
+// Taking away any of the user's roles or setting a new password signs the
+// user out, and a new email has to be verified again.
 func (u *Users) UpdateUser(user *users.User) error {
-	hashedPassword, err := secure.HashPassword(user.Password)
+	var demoted, emailChanged, passwordChanged bool
+	err := u.repository.WithinTransaction(func(repository users.Repository) error {
+		current, err := repository.GetUserById(user.Id)
+		if err != nil {
+			return err
+		}
+		demoted = lostRoles(current.Roles, user.Roles)
+		emailChanged = user.Email != current.Email
+
+		user.ServiceAccount = current.ServiceAccount
+		user.Pending = current.Pending
+		user.Status, user.StatusReason, user.SuspendedUntil = current.Status, current.StatusReason, current.SuspendedUntil
+		user.EmailVerified = current.EmailVerified && !emailChanged
+		if err = u.hashPassword(user); err != nil {
+			return err
+		}
+		passwordChanged = user.Password != ""
+
+		return repository.UpdateUser(user)
+	})
+	if err != nil {
+		return err
+	}
+
+	if emailChanged {
+		u.verifyEmail(user.Id, user)
+	}
+	if demoted || passwordChanged {
+		return u.sessions.RevokeUserSessions(user.Id)
+	}
+	return nil
+}
+
+// patchAttempts bounds how many times PatchUser retries after the user was
+// changed concurrently when the caller did not ask for a specific version.
+const patchAttempts = 3
+
+// PatchUser reads the user, lets patch change it and stores the result in one
+// transaction. patch gets the user without its password; a password it sets
+// is hashed and signs the user out, otherwise the current one is kept. With a
+// non-zero version the user must still be at that version.
+func (u *Users) PatchUser(id uuid.UUID, version uint64, patch func(user *users.User) error) error {
+	var (
+		demoted, emailChanged, passwordChanged bool
+		patched                                *users.User
+		err                                    error
+	)
+	for attempt := 0; attempt < patchAttempts; attempt++ {
+		err = u.repository.WithinTransaction(func(repository users.Repository) error {
+			user, err := repository.GetUserById(id)
+			if err != nil {
+				return err
+			}
+			if version != 0 && user.Version != version {
+				return users.UserVersionMismatchError
+			}
+
+			current, roles, email, verified, pending := user.Version, user.Roles, user.Email, user.EmailVerified, user.Pending
+			status, reason, until := user.Status, user.StatusReason, user.SuspendedUntil
+			user.Password = ""
+			if err = patch(user); err != nil {
+				return err
+			}
+			user.Id, user.Version = id, current
+			demoted = lostRoles(roles, user.Roles)
+			emailChanged = user.Email != email
+			user.EmailVerified = verified && !emailChanged
+			user.Pending = pending
+			user.Status, user.StatusReason, user.SuspendedUntil = status, reason, until
+			patched = user
+
+			if err = u.hashPassword(user); err != nil {
+				return err
+			}
+			passwordChanged = user.Password != ""
+
+			return repository.UpdateUser(user)
+		})
+		if version != 0 || !errors.Is(err, users.UserVersionMismatchError) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if emailChanged {
+		u.verifyEmail(id, patched)
+	}
+	if demoted || passwordChanged {
+		return u.sessions.RevokeUserSessions(id)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user if it is still at the given version, or
+// regardless of its version when version is zero, and signs the user out.
+func (u *Users) DeleteUser(id uuid.UUID, version uint64) error {
+	if err := u.repository.DeleteUser(id, version); err != nil {
+		return err
+	}
+
+	return u.sessions.RevokeUserSessions(id)
+}
+
+// ResetPassword replaces the password of a user and signs the user out.
+func (u *Users) ResetPassword(id uuid.UUID, password string) error {
+	err := u.repository.WithinTransaction(func(repository users.Repository) error {
+		user, err := repository.GetUserById(id)
+		if err != nil {
+			return err
+		}
+		if user.ServiceAccount {
+			return users.ServiceAccountPasswordError
+		}
+
+		user.Password = password
+		if err = u.hashPassword(user); err != nil {
+			return err
+		}
+
+		return repository.UpdateUser(user)
+	})
+	if err != nil {
+		return err
+	}
+
+	return u.sessions.RevokeUserSessions(id)
+}
+
+// verifyEmail mails a user a link to verify the email of the user. Service
+// accounts do not read mail, and pending users verify their email by
+// accepting the invite.
+func (u *Users) verifyEmail(id uuid.UUID, user *users.User) {
+	if !user.ServiceAccount && !user.Pending {
+		u.verifier.RequestVerification(id)
+	}
+}
+
+// lostRoles reports whether any of the roles before is missing after.
+func lostRoles(before, after []string) bool {
+	for _, role := range before {
+		kept := false
+		for _, r := range after {
+			kept = kept || r == role
+		}
+		if !kept {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashPassword checks the password of user against the password policy and
+// replaces it with its hash. Service accounts never get a password, and an
+// empty one is left empty, which keeps the stored password on updates.
+func (u *Users) hashPassword(user *users.User) error {
+	if user.ServiceAccount || user.Password == "" {
+		user.Password = ""
+		return nil
+	}
+
+	if err := u.checkPassword(user); err != nil {
+		return err
+	}
+
+	hashedPassword, err := u.hasher.Hash(user.Password)
 	if err != nil {
 		return users.UnknownError
 	}
 	user.Password = hashedPassword
 
-	return u.repository.UpdateUser(user)
+	return nil
+}
+
+// minPersonalInfo is the shortest username or email that passwords may not
+// contain; shorter ones would rule out too many passwords.
+const minPersonalInfo = 3
+
+// checkPassword returns a PasswordPolicyError listing every rule of the
+// password policy the password of user breaks.
+func (u *Users) checkPassword(user *users.User) error {
+	policy := u.cfg.Auth.Password.Policy
+	password := user.Password
+
+	var violations []users.PasswordViolation
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, users.PasswordViolation{
+			Rule:  users.PasswordMinLength,
+			Param: strconv.Itoa(policy.MinLength),
+		})
+	}
+	if characterClasses(password) < policy.CharacterClasses {
+		violations = append(violations, users.PasswordViolation{
+			Rule:  users.PasswordClasses,
+			Param: strconv.Itoa(policy.CharacterClasses),
+		})
+	}
+	if policy.RejectPersonalInfo {
+		lower := strings.ToLower(password)
+		local, _, _ := strings.Cut(user.Email, "@")
+		if containsInfo(lower, user.Username) {
+			violations = append(violations, users.PasswordViolation{Rule: users.PasswordUsername})
+		}
+		if containsInfo(lower, user.Email) || containsInfo(lower, local) {
+			violations = append(violations, users.PasswordViolation{Rule: users.PasswordEmail})
+		}
+	}
+
+	breached, err := u.breached.Breached(password)
+	if err != nil {
+		return users.UnknownError
+	}
+	if breached {
+		violations = append(violations, users.PasswordViolation{Rule: users.PasswordBreached})
+	}
+
+	if len(violations) > 0 {
+		return &users.PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// characterClasses counts which of lowercase letters, uppercase letters,
+// digits and symbols password has. Letters without case count as lowercase.
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLetter(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	n := 0
+	for _, class := range []bool{lower, upper, digit, symbol} {
+		if class {
+			n++
+		}
+	}
+
+	return n
 }
 
-func (u *Users) DeleteUser(id uuid.UUID) {
-	u.repository.DeleteUser(id)
+// containsInfo reports whether the lowercase password contains info, ignoring
+// case.
+func containsInfo(password, info string) bool {
+	return utf8.RuneCountInString(info) >= minPersonalInfo &&
+		strings.Contains(password, strings.ToLower(info))
 }
@@ -1,28 +1,38 @@
 package usecase_test
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/omelaymy/users/config"
 	"github.com/omelaymy/users/internal/users"
 	"github.com/omelaymy/users/internal/users/repository"
 	"github.com/omelaymy/users/internal/users/usecase"
+	"github.com/omelaymy/users/pkg/breached"
+	"github.com/omelaymy/users/pkg/mail"
 	"github.com/omelaymy/users/pkg/secure"
+	"github.com/omelaymy/users/pkg/token"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+var hasher = secure.NewHasher(secure.NewBcrypt(secure.BcryptParams{Cost: 4}))
+
 func TestCreateUser(t *testing.T) {
 	repo := repository.NewFakeRepository()
 
 	cfg := &config.Config{}
-	usersUsecase := usecase.NewUsers(cfg, repo)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
 
 	user := &users.User{
 		Username: "testuser",
 		Password: "password",
 		Email:    "test@example.com",
-		Admin:    true,
+		Roles:    []string{"admin"},
 	}
 
 	id, err := usersUsecase.CreateUser(user)
@@ -30,27 +40,70 @@ func TestCreateUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEqual(t, uuid.UUID{}, id)
 
-	hashedPassword, _ := secure.HashPassword(user.Password)
+	hashedPassword, _ := hasher.Hash(user.Password)
 	assert.NotEqual(t, user.Password, hashedPassword)
 
 	createdUser, err := repo.GetUserById(id)
 	assert.NoError(t, err)
 	assert.Equal(t, user.Username, createdUser.Username)
 	assert.Equal(t, user.Email, createdUser.Email)
-	assert.Equal(t, user.Admin, createdUser.Admin)
+	assert.Equal(t, user.Roles, createdUser.Roles)
+}
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	repo := repository.NewFakeRepository()
+
+	cfg := &config.Config{}
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	_, err := usersUsecase.CreateUser(&users.User{
+		Username: "user1",
+		Password: "password",
+		Email:    "shared@example.com",
+	})
+	assert.NoError(t, err)
+
+	_, err = usersUsecase.CreateUser(&users.User{
+		Username: "user2",
+		Password: "password",
+		Email:    "shared@example.com",
+	})
+	assert.Equal(t, users.EmailAlreadyExistsError, err)
+}
+
+func TestCreateUsers(t *testing.T) {
+	repo := repository.NewFakeRepository()
+
+	cfg := &config.Config{}
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	ids, err := usersUsecase.CreateUsers([]*users.User{
+		{Username: "user1", Password: "password1", Email: "user1@example.com"},
+		{Username: "user2", Password: "password2", Email: "user2@example.com"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+	assert.Len(t, usersUsecase.GetUsers(), 2)
+
+	_, err = usersUsecase.CreateUsers([]*users.User{
+		{Username: "user3", Password: "password3", Email: "user3@example.com"},
+		{Username: "user1", Password: "password1", Email: "other@example.com"},
+	})
+	assert.Equal(t, users.UserAlreadyExistsError, err)
+	assert.Len(t, usersUsecase.GetUsers(), 2)
 }
 
 func TestGetUser(t *testing.T) {
 	repo := repository.NewFakeRepository()
 
 	cfg := &config.Config{}
-	usersUsecase := usecase.NewUsers(cfg, repo)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
 
 	user := &users.User{
 		Username: "testuser",
 		Password: "password",
 		Email:    "test@example.com",
-		Admin:    true,
+		Roles:    []string{"admin"},
 	}
 
 	id, _ := usersUsecase.CreateUser(user)
@@ -60,31 +113,31 @@ func TestGetUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, user.Username, retrievedUser.Username)
 	assert.Equal(t, user.Email, retrievedUser.Email)
-	assert.Equal(t, user.Admin, retrievedUser.Admin)
+	assert.Equal(t, user.Roles, retrievedUser.Roles)
 }
 
 func TestGetUsers(t *testing.T) {
 	repo := repository.NewFakeRepository()
 	cfg := &config.Config{}
-	usersUsecase := usecase.NewUsers(cfg, repo)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
 	usersData := []*users.User{
 		{
 			Username: "user1",
 			Password: "password1",
 			Email:    "user1@example.com",
-			Admin:    true,
+			Roles:    []string{"admin"},
 		},
 		{
 			Username: "user2",
 			Password: "password2",
 			Email:    "user2@example.com",
-			Admin:    false,
+			Roles:    []string{"auditor"},
 		},
 		{
 			Username: "user3",
 			Password: "password3",
 			Email:    "user3@example.com",
-			Admin:    true,
+			Roles:    []string{"admin"},
 		},
 	}
 
@@ -109,43 +162,623 @@ func TestGetUsers(t *testing.T) {
 func TestUpdateUser(t *testing.T) {
 	repo := repository.NewFakeRepository()
 	cfg := &config.Config{}
-	usersUsecase := usecase.NewUsers(cfg, repo)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
 	user := &users.User{
 		Username: "testuser",
 		Password: "password",
 		Email:    "test@example.com",
-		Admin:    true,
+		Roles:    []string{"admin"},
 	}
 
 	id, _ := usersUsecase.CreateUser(user)
 	user.Email = "updated@example.com"
-	user.Admin = false
+	user.Roles = []string{"auditor"}
 	err := usersUsecase.UpdateUser(user)
 	assert.NoError(t, err)
 
 	updatedUser, err := repo.GetUserById(id)
 	assert.NoError(t, err)
 	assert.Equal(t, user.Email, updatedUser.Email)
-	assert.Equal(t, user.Admin, updatedUser.Admin)
+	assert.Equal(t, user.Roles, updatedUser.Roles)
 }
 
 func TestDeleteUser(t *testing.T) {
 	repo := repository.NewFakeRepository()
 
 	cfg := &config.Config{}
-	usersUsecase := usecase.NewUsers(cfg, repo)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
 
 	user := &users.User{
 		Username: "testuser",
 		Password: "password",
 		Email:    "test@example.com",
-		Admin:    true,
+		Roles:    []string{"admin"},
 	}
 
 	id, _ := usersUsecase.CreateUser(user)
 
-	usersUsecase.DeleteUser(id)
+	usersUsecase.DeleteUser(id, 0)
 
 	_, err := repo.GetUserById(id)
 	assert.Equal(t, users.UserNotFoundError, err)
 }
+
+func TestUpdateUserVersionMismatch(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	id, _ := usersUsecase.CreateUser(&users.User{
+		Username: "testuser",
+		Password: "password",
+		Email:    "test@example.com",
+	})
+
+	err := usersUsecase.UpdateUser(&users.User{
+		Id:       id,
+		Username: "first",
+		Password: "password",
+		Email:    "test@example.com",
+		Version:  1,
+	})
+	assert.NoError(t, err)
+
+	err = usersUsecase.UpdateUser(&users.User{
+		Id:       id,
+		Username: "second",
+		Password: "password",
+		Email:    "test@example.com",
+		Version:  1,
+	})
+	assert.Equal(t, users.UserVersionMismatchError, err)
+
+	assert.Equal(t, users.UserVersionMismatchError, usersUsecase.DeleteUser(id, 1))
+	assert.NoError(t, usersUsecase.DeleteUser(id, 2))
+}
+
+func TestFindUsers(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	for _, username := range []string{"carol", "alice", "bob", "alex"} {
+		_, err := usersUsecase.CreateUser(&users.User{
+			Username: username,
+			Password: "password",
+			Email:    username + "@example.com",
+		})
+		assert.NoError(t, err)
+	}
+
+	page, err := usersUsecase.FindUsers(users.UsersQuery{
+		Filter: users.UsersFilter{UsernamePrefix: "al"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.Equal(t, "alex", page.Users[0].Username)
+
+	page, err = usersUsecase.FindUsers(users.UsersQuery{SortBy: users.SortByEmail, Limit: 3})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 3)
+	assert.NotEmpty(t, page.NextCursor)
+
+	page, err = usersUsecase.FindUsers(users.UsersQuery{SortBy: users.SortByEmail, Limit: 3, Cursor: page.NextCursor})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+	assert.Equal(t, "carol", page.Users[0].Username)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = usersUsecase.FindUsers(users.UsersQuery{SortBy: "password"})
+	assert.Equal(t, users.InvalidSortFieldError, err)
+}
+
+func TestPatchUser(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	id, _ := usersUsecase.CreateUser(&users.User{
+		Username: "testuser",
+		Password: "password",
+		Email:    "test@example.com",
+	})
+	hash := repo.GetPassword(id)
+
+	err := usersUsecase.PatchUser(id, 0, func(user *users.User) error {
+		assert.Empty(t, user.Password)
+		user.Email = "patched@example.com"
+		return nil
+	})
+	assert.NoError(t, err)
+
+	user, _ := repo.GetUserById(id)
+	assert.Equal(t, "patched@example.com", user.Email)
+	assert.Equal(t, "testuser", user.Username)
+	assert.Equal(t, hash, repo.GetPassword(id))
+
+	err = usersUsecase.PatchUser(id, user.Version, func(user *users.User) error {
+		user.Password = "new password"
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, hasher.Compare(repo.GetPassword(id), "new password"))
+
+	err = usersUsecase.PatchUser(id, user.Version, func(user *users.User) error {
+		user.Roles = []string{"admin"}
+		return nil
+	})
+	assert.Equal(t, users.UserVersionMismatchError, err)
+
+	err = usersUsecase.PatchUser(uuid.New(), 0, func(user *users.User) error {
+		return nil
+	})
+	assert.Equal(t, users.UserNotFoundError, err)
+}
+
+type fakeSessions struct {
+	revoked []uuid.UUID
+}
+
+func (f *fakeSessions) RevokeUserSessions(userId uuid.UUID) error {
+	f.revoked = append(f.revoked, userId)
+	return nil
+}
+
+func TestSessionsRevoked(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	sessions := &fakeSessions{}
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, sessions, hasher, &breached.List{}, &fakeVerifier{})
+
+	admin := &users.User{Username: "admin", Password: "password", Email: "admin@example.com", Roles: []string{"admin"}}
+	adminId, _ := usersUsecase.CreateUser(admin)
+	user := &users.User{Username: "user", Password: "password", Email: "user@example.com"}
+	userId, _ := usersUsecase.CreateUser(user)
+	// Updates without a password keep the current one.
+	admin.Password, user.Password = "", ""
+
+	user.Email = "changed@example.com"
+	assert.NoError(t, usersUsecase.UpdateUser(user))
+	assert.Empty(t, sessions.revoked)
+
+	admin.Roles = []string{"admin", "auditor"}
+	assert.NoError(t, usersUsecase.UpdateUser(admin))
+	assert.Empty(t, sessions.revoked)
+	admin.Roles = []string{"auditor"}
+	assert.NoError(t, usersUsecase.UpdateUser(admin))
+	assert.Equal(t, []uuid.UUID{adminId}, sessions.revoked)
+
+	sessions.revoked = nil
+	assert.NoError(t, usersUsecase.PatchUser(adminId, 0, func(user *users.User) error {
+		user.Roles = []string{"admin", "auditor"}
+		return nil
+	}))
+	assert.Empty(t, sessions.revoked)
+	assert.NoError(t, usersUsecase.PatchUser(adminId, 0, func(user *users.User) error {
+		user.Roles = nil
+		return nil
+	}))
+	assert.Equal(t, []uuid.UUID{adminId}, sessions.revoked)
+
+	// A new password set by an admin signs out whoever may know the old one.
+	sessions.revoked = nil
+	user.Password = "new password"
+	assert.NoError(t, usersUsecase.UpdateUser(user))
+	assert.Equal(t, []uuid.UUID{userId}, sessions.revoked)
+	sessions.revoked = nil
+	assert.NoError(t, usersUsecase.PatchUser(userId, 0, func(user *users.User) error {
+		user.Password = "another password"
+		return nil
+	}))
+	assert.Equal(t, []uuid.UUID{userId}, sessions.revoked)
+
+	sessions.revoked = nil
+	assert.Equal(t, users.UserVersionMismatchError, usersUsecase.DeleteUser(userId, 1))
+	assert.Empty(t, sessions.revoked)
+	assert.NoError(t, usersUsecase.DeleteUser(userId, 0))
+	assert.Equal(t, []uuid.UUID{userId}, sessions.revoked)
+}
+
+func TestCreateServiceAccount(t *testing.T) {
+	repo := repository.NewFakeRepository()
+
+	cfg := &config.Config{}
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, &fakeVerifier{})
+
+	id, err := usersUsecase.CreateUser(&users.User{
+		Username:       "robot",
+		Password:       "password",
+		Email:          "robot@example.com",
+		ServiceAccount: true,
+	})
+	assert.NoError(t, err)
+
+	created, err := repo.GetUserById(id)
+	assert.NoError(t, err)
+	assert.True(t, created.ServiceAccount)
+	assert.Empty(t, created.Password)
+
+	// An update cannot turn the service account into a regular user.
+	err = usersUsecase.UpdateUser(&users.User{
+		Id:       id,
+		Username: "robot",
+		Password: "password",
+		Email:    "robot@example.com",
+	})
+	assert.NoError(t, err)
+
+	updated, err := repo.GetUserById(id)
+	assert.NoError(t, err)
+	assert.True(t, updated.ServiceAccount)
+	assert.Empty(t, updated.Password)
+}
+
+func TestResetPassword(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	sessions := &fakeSessions{}
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, sessions, hasher, &breached.List{}, &fakeVerifier{})
+
+	id, _ := usersUsecase.CreateUser(&users.User{Username: "user", Password: "password", Email: "user@example.com"})
+	robotId, _ := usersUsecase.CreateUser(&users.User{Username: "robot", Email: "robot@example.com", ServiceAccount: true})
+
+	assert.NoError(t, usersUsecase.ResetPassword(id, "new password"))
+	assert.NoError(t, hasher.Compare(repo.GetPassword(id), "new password"))
+	assert.Equal(t, []uuid.UUID{id}, sessions.revoked)
+
+	assert.Equal(t, users.ServiceAccountPasswordError, usersUsecase.ResetPassword(robotId, "password"))
+	assert.Equal(t, users.UserNotFoundError, usersUsecase.ResetPassword(uuid.New(), "password"))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	cfg := &config.Config{}
+	cfg.Auth.Password.Policy.MinLength = 10
+	cfg.Auth.Password.Policy.CharacterClasses = 3
+	cfg.Auth.Password.Policy.RejectPersonalInfo = true
+	list, err := breached.New(strings.NewReader(sha1Hex("Correct-Horse-1")))
+	require.NoError(t, err)
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, list, &fakeVerifier{})
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"Str0ng-enough", nil},
+		{"a", []string{users.PasswordMinLength, users.PasswordClasses}},
+		{"alllowercase", []string{users.PasswordClasses}},
+		{"My-Alice-Pass1", []string{users.PasswordUsername}},
+		{"Liddell-Pass-1", []string{users.PasswordEmail}},
+		{"Correct-Horse-1", []string{users.PasswordBreached}},
+	}
+	for _, tt := range tests {
+		_, err := usersUsecase.CreateUser(&users.User{Username: "alice", Email: "liddell@example.com", Password: tt.password})
+		if tt.rules == nil {
+			assert.NoError(t, err, tt.password)
+			continue
+		}
+
+		var policyErr *users.PasswordPolicyError
+		require.ErrorAs(t, err, &policyErr, tt.password)
+		var rules []string
+		for _, violation := range policyErr.Violations {
+			rules = append(rules, violation.Rule)
+		}
+		assert.Equal(t, tt.rules, rules, tt.password)
+	}
+
+	id, err := usersUsecase.CreateUser(&users.User{Username: "bob", Email: "bob@example.com", Password: "Str0ng-enough"})
+	require.NoError(t, err)
+	assert.ErrorAs(t, usersUsecase.ResetPassword(id, "short"), new(*users.PasswordPolicyError))
+	err = usersUsecase.PatchUser(id, 0, func(user *users.User) error {
+		user.Password = "Correct-Horse-1"
+		return nil
+	})
+	assert.ErrorAs(t, err, new(*users.PasswordPolicyError))
+	assert.NoError(t, hasher.Compare(repo.GetPassword(id), "Str0ng-enough"))
+}
+
+type fakeMailer struct {
+	sent []mail.Message
+}
+
+func (f *fakeMailer) Send(message mail.Message) error {
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+// token returns the reset token of the last mail sent.
+func (f *fakeMailer) token(t *testing.T) string {
+	require.NotEmpty(t, f.sent)
+	body := f.sent[len(f.sent)-1].Body
+	_, token, ok := strings.Cut(body, "token: ")
+	require.True(t, ok, body)
+	token, _, _ = strings.Cut(token, "\n")
+
+	return token
+}
+
+func TestPasswordReset(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	sessions := &fakeSessions{}
+	cfg := &config.Config{}
+	cfg.Auth.Password.Policy.MinLength = 10
+	cfg.Auth.PasswordReset.TokenTTL = time.Hour
+	usersUsecase := usecase.NewUsers(cfg, repo, sessions, hasher, &breached.List{}, &fakeVerifier{})
+	mailer := &fakeMailer{}
+	now := time.Unix(1700000000, 0)
+	resetUsecase := usecase.NewPasswordReset(
+		cfg, repo, repository.NewResetTokenRepository(), usersUsecase, mailer,
+		func() time.Time { return now },
+	)
+
+	id, err := usersUsecase.CreateUser(&users.User{Username: "user", Password: "old password", Email: "user@example.com"})
+	require.NoError(t, err)
+	_, err = usersUsecase.CreateUser(&users.User{Username: "robot", Email: "robot@example.com", ServiceAccount: true})
+	require.NoError(t, err)
+
+	// Unknown emails and service accounts get no mail, and no error either.
+	require.NoError(t, resetUsecase.RequestPasswordReset("nobody@example.com"))
+	require.NoError(t, resetUsecase.RequestPasswordReset("robot@example.com"))
+	assert.Empty(t, mailer.sent)
+
+	require.NoError(t, resetUsecase.RequestPasswordReset("user@example.com"))
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "user@example.com", mailer.sent[0].To)
+	first := mailer.token(t)
+
+	require.NoError(t, resetUsecase.RequestPasswordReset("user@example.com"))
+	assert.Len(t, mailer.sent, 1, "requests right after another are not mailed")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, resetUsecase.RequestPasswordReset("user@example.com"))
+	require.Len(t, mailer.sent, 2)
+	token := mailer.token(t)
+	assert.Equal(t, users.InvalidResetTokenError, resetUsecase.ConfirmPasswordReset(first, "new password"),
+		"a new token replaces the old one")
+
+	assert.ErrorAs(t, resetUsecase.ConfirmPasswordReset(token, "short"), new(*users.PasswordPolicyError))
+	require.NoError(t, resetUsecase.ConfirmPasswordReset(token, "new password"))
+	assert.NoError(t, hasher.Compare(repo.GetPassword(id), "new password"))
+	assert.Equal(t, []uuid.UUID{id}, sessions.revoked)
+	assert.Equal(t, users.InvalidResetTokenError, resetUsecase.ConfirmPasswordReset(token, "newer password"),
+		"tokens are single-use")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, resetUsecase.RequestPasswordReset("user@example.com"))
+	now = now.Add(time.Hour)
+	assert.Equal(t, users.InvalidResetTokenError, resetUsecase.ConfirmPasswordReset(mailer.token(t), "newer password"),
+		"tokens expire")
+}
+
+func TestInvitations(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	cfg := &config.Config{}
+	cfg.Auth.Password.Policy.MinLength = 10
+	cfg.Auth.Invitations.TokenTTL = time.Hour
+	verifier := &fakeVerifier{}
+	usersUsecase := usecase.NewUsers(cfg, repo, &fakeSessions{}, hasher, &breached.List{}, verifier)
+	mailer := &fakeMailer{}
+	now := time.Unix(1700000000, 0)
+	invitations := usecase.NewInvitations(
+		cfg, repo, repository.NewInviteRepository(), usersUsecase, mailer,
+		func() time.Time { return now },
+	)
+	resetUsecase := usecase.NewPasswordReset(
+		cfg, repo, repository.NewResetTokenRepository(), usersUsecase, mailer,
+		func() time.Time { return now },
+	)
+
+	id, err := invitations.Invite("new@example.com", []string{"user"})
+	require.NoError(t, err)
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "new@example.com", mailer.sent[0].To)
+	first := mailer.token(t)
+	assert.Empty(t, verifier.requested, "accepting the invite verifies the email")
+
+	pending, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.True(t, pending.Pending)
+	assert.Empty(t, repo.GetPassword(id))
+
+	_, err = invitations.Invite("new@example.com", nil)
+	assert.Equal(t, users.EmailAlreadyExistsError, err)
+	require.NoError(t, resetUsecase.RequestPasswordReset("new@example.com"))
+	assert.Len(t, mailer.sent, 1, "pending users accept their invite instead")
+
+	// Updates cannot make a pending user regular.
+	pending.Pending = false
+	require.NoError(t, usersUsecase.UpdateUser(pending))
+	pending, err = repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.True(t, pending.Pending)
+
+	invited, err := invitations.GetInvitations()
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, id, invited[0].UserId)
+	assert.Equal(t, []string{"user"}, invited[0].Roles)
+	assert.Equal(t, now.Add(time.Hour), invited[0].ExpiresAt)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, invitations.ResendInvite(id))
+	require.Len(t, mailer.sent, 2)
+	token := mailer.token(t)
+	_, err = invitations.AcceptInvite(first, "newbie", "long enough password")
+	assert.Equal(t, users.InvalidInviteTokenError, err, "a new invite replaces the old one")
+
+	_, err = invitations.AcceptInvite(token, "newbie", "short")
+	assert.ErrorAs(t, err, new(*users.PasswordPolicyError))
+	accepted, err := invitations.AcceptInvite(token, "newbie", "long enough password")
+	require.NoError(t, err)
+	assert.Equal(t, id, accepted)
+	_, err = invitations.AcceptInvite(token, "newbie", "long enough password")
+	assert.Equal(t, users.InvalidInviteTokenError, err, "invites are single-use")
+
+	user, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, "newbie", user.Username)
+	assert.False(t, user.Pending)
+	assert.True(t, user.EmailVerified)
+	assert.NoError(t, hasher.Compare(repo.GetPassword(id), "long enough password"))
+	assert.Equal(t, users.InviteNotFoundError, invitations.ResendInvite(id))
+	assert.Equal(t, users.InviteNotFoundError, invitations.RevokeInvite(id))
+
+	revoked, err := invitations.Invite("revoked@example.com", nil)
+	require.NoError(t, err)
+	token = mailer.token(t)
+	require.NoError(t, invitations.RevokeInvite(revoked))
+	_, err = repo.GetUserById(revoked)
+	assert.Equal(t, users.UserNotFoundError, err)
+	_, err = invitations.AcceptInvite(token, "revoked", "long enough password")
+	assert.Equal(t, users.InvalidInviteTokenError, err)
+
+	_, err = invitations.Invite("late@example.com", nil)
+	require.NoError(t, err)
+	token = mailer.token(t)
+	now = now.Add(time.Hour)
+	_, err = invitations.AcceptInvite(token, "late", "long enough password")
+	assert.Equal(t, users.InvalidInviteTokenError, err, "invites expire")
+	invited, err = invitations.GetInvitations()
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, "late@example.com", invited[0].Email)
+}
+
+func TestAccountStatus(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	sessions := &fakeSessions{}
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, sessions, hasher, &breached.List{}, &fakeVerifier{})
+	now := time.Unix(1700000000, 0)
+	accountStatus := usecase.NewAccountStatus(repo, sessions, func() time.Time { return now })
+
+	id, err := usersUsecase.CreateUser(&users.User{Username: "user", Password: "password", Email: "user@example.com"})
+	require.NoError(t, err)
+	user, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, users.StatusActive, user.Status)
+
+	assert.Equal(t, users.SuspensionEndedError, accountStatus.Suspend(id, "spam", now))
+	assert.Equal(t, users.UserNotFoundError, accountStatus.Suspend(uuid.New(), "spam", time.Time{}))
+	assert.Empty(t, sessions.revoked)
+
+	until := now.Add(time.Hour)
+	require.NoError(t, accountStatus.Suspend(id, "spam", until))
+	assert.Equal(t, []uuid.UUID{id}, sessions.revoked)
+	user, err = repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, users.StatusSuspended, user.Status)
+	assert.Equal(t, "spam", user.StatusReason)
+	assert.Equal(t, until, user.SuspendedUntil)
+	assert.Equal(t, users.StatusSuspended, user.StatusAt(now))
+	assert.Equal(t, users.StatusActive, user.StatusAt(until), "the suspension ended")
+
+	// Updates keep the status.
+	user.Email = "changed@example.com"
+	user.Status = users.StatusActive
+	require.NoError(t, usersUsecase.UpdateUser(user))
+	require.NoError(t, usersUsecase.PatchUser(id, 0, func(user *users.User) error {
+		user.Status = users.StatusActive
+		user.StatusReason = ""
+		return nil
+	}))
+	user, err = repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, "changed@example.com", user.Email)
+	assert.Equal(t, users.StatusSuspended, user.Status)
+	assert.Equal(t, "spam", user.StatusReason)
+
+	sessions.revoked = nil
+	require.NoError(t, accountStatus.Deactivate(id, "left"))
+	assert.Equal(t, []uuid.UUID{id}, sessions.revoked)
+	user, err = repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, users.StatusDeactivated, user.Status)
+	assert.Equal(t, "left", user.StatusReason)
+	assert.True(t, user.SuspendedUntil.IsZero())
+	assert.Equal(t, users.StatusDeactivated, user.StatusAt(until))
+
+	sessions.revoked = nil
+	require.NoError(t, accountStatus.Reactivate(id))
+	assert.Empty(t, sessions.revoked)
+	user, err = repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, users.StatusActive, user.Status)
+	assert.Empty(t, user.StatusReason)
+}
+
+type fakeVerifier struct {
+	requested []uuid.UUID
+}
+
+func (f *fakeVerifier) RequestVerification(id uuid.UUID) {
+	f.requested = append(f.requested, id)
+}
+
+func TestEmailVerification(t *testing.T) {
+	repo := repository.NewFakeRepository()
+	verifier := &fakeVerifier{}
+	usersUsecase := usecase.NewUsers(&config.Config{}, repo, &fakeSessions{}, hasher, &breached.List{}, verifier)
+	tokens, err := token.NewManager(token.Options{
+		Issuer:          "users",
+		Audience:        "users-api",
+		VerificationTTL: time.Hour,
+		SigningKey:      "test",
+		Keys: []token.KeyOptions{
+			{ID: "test", Algorithm: token.HS256, Secret: "0123456789abcdef0123456789abcdef"},
+		},
+	}, time.Now)
+	require.NoError(t, err)
+	mailer := &fakeMailer{}
+	verification := usecase.NewEmailVerification(&config.Config{}, repo, tokens, mailer, nil)
+
+	user := &users.User{Username: "user", Password: "password", Email: "user@example.com", EmailVerified: true}
+	id, err := usersUsecase.CreateUser(user)
+	require.NoError(t, err)
+	_, err = usersUsecase.CreateUser(&users.User{Username: "robot", Email: "robot@example.com", ServiceAccount: true})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{id}, verifier.requested, "service accounts are not verified")
+
+	created, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.False(t, created.EmailVerified, "callers cannot create verified users")
+
+	require.NoError(t, verification.SendVerification(id))
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "user@example.com", mailer.sent[0].To)
+	first := mailer.token(t)
+
+	assert.Equal(t, users.InvalidVerificationTokenError, verification.VerifyEmail("not a token"))
+	require.NoError(t, verification.VerifyEmail(first))
+	verified, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	assert.Equal(t, users.EmailAlreadyVerifiedError, verification.SendVerification(id))
+
+	unverified := false
+	found, err := usersUsecase.FindUsers(users.UsersQuery{Filter: users.UsersFilter{EmailVerified: &unverified}})
+	require.NoError(t, err)
+	require.Len(t, found.Users, 1)
+	assert.Equal(t, "robot", found.Users[0].Username)
+
+	// A new email has to be verified again, and tokens mailed to the old one
+	// no longer count.
+	verifier.requested = nil
+	verified.Email = "new@example.com"
+	require.NoError(t, usersUsecase.UpdateUser(verified))
+	assert.Equal(t, []uuid.UUID{id}, verifier.requested)
+	changed, err := repo.GetUserById(id)
+	require.NoError(t, err)
+	assert.False(t, changed.EmailVerified)
+	assert.Equal(t, users.InvalidVerificationTokenError, verification.VerifyEmail(first))
+
+	require.NoError(t, verification.SendVerification(id))
+	require.NoError(t, verification.VerifyEmail(mailer.token(t)))
+
+	verifier.requested = nil
+	require.NoError(t, usersUsecase.PatchUser(id, 0, func(user *users.User) error {
+		user.Username = "renamed"
+		return nil
+	}))
+	assert.Empty(t, verifier.requested, "other changes keep the email verified")
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,116 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/config"
+	"github.com/omelaymy/users/internal/users"
+	"github.com/omelaymy/users/pkg/mail"
+	"github.com/omelaymy/users/pkg/token"
+	"github.com/rs/zerolog"
+)
+
+// EmailVerification mails users signed links that verify their email. The
+// links hold no state: a token is valid until it expires, for as long as the
+// user has the email it was mailed to.
+type EmailVerification struct {
+	cfg        *config.Config
+	repository users.Repository
+	tokens     *token.Manager
+	mailer     users.Mailer
+	log        *zerolog.Logger
+}
+
+func NewEmailVerification(
+	cfg *config.Config,
+	repository users.Repository,
+	tokens *token.Manager,
+	mailer users.Mailer,
+	log *zerolog.Logger,
+) *EmailVerification {
+	return &EmailVerification{
+		cfg:        cfg,
+		repository: repository,
+		tokens:     tokens,
+		mailer:     mailer,
+		log:        log,
+	}
+}
+
+// RequestVerification is SendVerification for changes that were stored
+// already; failures are logged.
+func (v *EmailVerification) RequestVerification(id uuid.UUID) {
+	if err := v.SendVerification(id); err != nil {
+		v.log.Err(err).Str("user", id.String()).Msg("failed to send email verification")
+	}
+}
+
+// SendVerification mails a user a link that verifies the current email of
+// the user.
+func (v *EmailVerification) SendVerification(id uuid.UUID) error {
+	user, err := v.repository.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return users.EmailAlreadyVerifiedError
+	}
+
+	claims := token.Claims{Email: user.Email}
+	claims.Subject = user.Id.String()
+	signed, _, err := v.tokens.Sign(token.TypeVerification, claims)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Please confirm that %s is the email of %s.\n\n", user.Email, user.Username)
+	if link, err := url.Parse(v.cfg.Auth.EmailVerification.URL); err == nil && link.Host != "" {
+		query := link.Query()
+		query.Set("token", signed)
+		link.RawQuery = query.Encode()
+		body += fmt.Sprintf("Open %s\n\nor verify with this token: %s\n", link, signed)
+	} else {
+		body += fmt.Sprintf("Verify with this token: %s\n", signed)
+	}
+
+	return v.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body:    body,
+	})
+}
+
+// VerifyEmail marks the email of a user verified with a token mailed to it.
+// Tokens mailed to an email the user no longer has are refused.
+func (v *EmailVerification) VerifyEmail(signed string) error {
+	claims, err := v.tokens.Verify(signed, token.TypeVerification)
+	if err != nil {
+		return users.InvalidVerificationTokenError
+	}
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return users.InvalidVerificationTokenError
+	}
+
+	return v.repository.WithinTransaction(func(repository users.Repository) error {
+		user, err := repository.GetUserById(id)
+		if err != nil {
+			if errors.Is(err, users.UserNotFoundError) {
+				return users.InvalidVerificationTokenError
+			}
+			return err
+		}
+		if user.Email != claims.Email {
+			return users.InvalidVerificationTokenError
+		}
+		if user.EmailVerified {
+			return nil
+		}
+
+		user.EmailVerified = true
+		return repository.UpdateUser(user)
+	})
+}
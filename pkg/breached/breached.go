@@ -0,0 +1,106 @@
+package breached
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PrefixLength is the number of hex digits of a SHA-1 hash passwords are
+// looked up by, as in the range API of Have I Been Pwned.
+const PrefixLength = 5
+
+var InvalidListError = errors.New("invalid breached password list")
+
+// List holds SHA-1 hashes of breached passwords grouped by their prefix.
+// Lookups only ever see the suffixes of one prefix, so the list can be
+// replaced by a remote range API without handing it whole hashes. The zero
+// value is an empty list.
+type List struct {
+	suffixes map[string]map[string]struct{}
+}
+
+// Load reads a list from path; an empty path gives an empty list.
+func Load(path string) (*List, error) {
+	if path == "" {
+		return &List{}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list, err := New(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return list, nil
+}
+
+// New reads a list with one uppercase hex SHA-1 hash a line, optionally
+// followed by a colon and a count like in the downloads of Have I Been Pwned.
+// Empty lines and lines starting with # are skipped.
+func New(r io.Reader) (*List, error) {
+	list := &List{suffixes: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%w: line %d is not a SHA-1 hash", InvalidListError, line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%w: line %d is not a SHA-1 hash", InvalidListError, line)
+		}
+
+		prefix, suffix := hash[:PrefixLength], hash[PrefixLength:]
+		if list.suffixes[prefix] == nil {
+			list.suffixes[prefix] = make(map[string]struct{})
+		}
+		list.suffixes[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Range returns the suffixes of the hashes starting with prefix.
+func (l *List) Range(prefix string) []string {
+	suffixes := l.suffixes[strings.ToUpper(prefix)]
+	res := make([]string, 0, len(suffixes))
+	for suffix := range suffixes {
+		res = append(res, suffix)
+	}
+
+	return res
+}
+
+// Breached reports whether password is on the list.
+func (l *List) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	for _, suffix := range l.Range(hash[:PrefixLength]) {
+		if suffix == hash[PrefixLength:] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,53 @@
+package breached_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/breached"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	list, err := breached.New(strings.NewReader(`# SHA-1 of "password" and "P@ssw0rd"
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+
+21bd12dc183f740ee76f27b78eb39c8ad972a757
+`))
+	require.NoError(t, err)
+
+	for password, want := range map[string]bool{
+		"password":  true,
+		"P@ssw0rd":  true,
+		"Password":  false,
+		"something": false,
+	} {
+		got, err := list.Breached(password)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, password)
+	}
+
+	assert.Equal(t, []string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}, list.Range("5baa6"))
+	assert.Empty(t, list.Range("00000"))
+
+	breachedPassword, err := (&breached.List{}).Breached("password")
+	assert.NoError(t, err)
+	assert.False(t, breachedPassword)
+}
+
+func TestLoad(t *testing.T) {
+	list, err := breached.Load("")
+	require.NoError(t, err)
+	assert.Empty(t, list.Range("5BAA6"))
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot a hash\n"), 0o600))
+	_, err = breached.Load(path)
+	assert.ErrorIs(t, err, breached.InvalidListError)
+
+	_, err = breached.Load(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,134 @@
+package inmemory
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InsertAPIKey stores a new key of an existing user and returns its id.
+func (db *InMemoryDatabase) InsertAPIKey(key APIKey) (uuid.UUID, error) {
+	if key.UserID == uuid.Nil || len(key.Hash) == 0 {
+		return uuid.UUID{}, MissingRequiredFieldsError
+	}
+
+	// Holding the user's shards keeps a concurrent DeleteUser from dropping
+	// the user's keys before this one is added.
+	_, unlock, err := db.lockUser(key.UserID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	defer unlock()
+
+	key.ID = uuid.New()
+	key.CreatedAt = time.Now().UTC()
+	key.LastUsedAt = time.Time{}
+
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	if err = db.commitKey(walRecord{Op: walOpPutKey, Key: &key}); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return key.ID, nil
+}
+
+// GetAPIKey reads the latest published version like GetUsers.
+func (db *InMemoryDatabase) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	key, ok := db.current.Load().keys.Get(id)
+	if !ok {
+		return APIKey{}, NotFoundError
+	}
+
+	return *key, nil
+}
+
+// GetUserAPIKeys returns the keys of a user, oldest first. It scans all keys,
+// which are expected to be few.
+func (db *InMemoryDatabase) GetUserAPIKeys(userID uuid.UUID) []APIKey {
+	var keys []APIKey
+	db.current.Load().keys.Ascend(func(_ uuid.UUID, key *APIKey) bool {
+		if key.UserID == userID {
+			keys = append(keys, *key)
+		}
+		return true
+	})
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// TouchAPIKey records that a key was used at the given time.
+func (db *InMemoryDatabase) TouchAPIKey(id uuid.UUID, usedAt time.Time) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	existing, ok := db.keys.Get(id)
+	if !ok {
+		return NotFoundError
+	}
+
+	key := *existing
+	key.LastUsedAt = usedAt.UTC()
+
+	return db.commitKey(walRecord{Op: walOpPutKey, Key: &key})
+}
+
+func (db *InMemoryDatabase) DeleteAPIKey(id uuid.UUID) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	if _, ok := db.keys.Get(id); !ok {
+		return NotFoundError
+	}
+
+	return db.commitKey(walRecord{Op: walOpDeleteKey, Key: &APIKey{ID: id}})
+}
+
+// commitKey logs and applies a key record and publishes the result. The
+// caller must hold commitMu.
+func (db *InMemoryDatabase) commitKey(record walRecord) error {
+	if err := db.log(record); err != nil {
+		return err
+	}
+
+	db.applyKeyRecord(record)
+	db.publish()
+
+	return nil
+}
+
+func (db *InMemoryDatabase) applyKeyRecord(record walRecord) {
+	key := *record.Key
+
+	switch record.Op {
+	case walOpPutKey:
+		db.keys = db.keys.Set(key.ID, &key)
+	case walOpDeleteKey:
+		db.keys = db.keys.Delete(key.ID)
+	}
+}
+
+func (db *InMemoryDatabase) deleteKeysOf(userID uuid.UUID) {
+	var ids []uuid.UUID
+	db.keys.Ascend(func(id uuid.UUID, key *APIKey) bool {
+		if key.UserID == userID {
+			ids = append(ids, id)
+		}
+		return true
+	})
+
+	for _, id := range ids {
+		db.keys = db.keys.Delete(id)
+	}
+}
+
+func compareIDs(a, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
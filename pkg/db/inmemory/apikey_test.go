@@ -0,0 +1,113 @@
+package inmemory_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	userID, _ := db.InsertUser(inmemory.User{Username: "ci", ServiceAccount: true})
+	otherID, _ := db.InsertUser(inmemory.User{Username: "other"})
+
+	_, err := db.InsertAPIKey(inmemory.APIKey{UserID: uuid.New(), Hash: []byte("hash")})
+	assert.Equal(t, inmemory.NotFoundError, err)
+	_, err = db.InsertAPIKey(inmemory.APIKey{UserID: userID})
+	assert.Equal(t, inmemory.MissingRequiredFieldsError, err)
+
+	first, err := db.InsertAPIKey(inmemory.APIKey{UserID: userID, Name: "first", Hash: []byte("first")})
+	require.NoError(t, err)
+	second, err := db.InsertAPIKey(inmemory.APIKey{UserID: userID, Name: "second", Hash: []byte("second")})
+	require.NoError(t, err)
+	_, err = db.InsertAPIKey(inmemory.APIKey{UserID: otherID, Name: "other", Hash: []byte("other")})
+	require.NoError(t, err)
+
+	keys := db.GetUserAPIKeys(userID)
+	require.Len(t, keys, 2)
+	assert.Equal(t, first, keys[0].ID)
+	assert.Equal(t, second, keys[1].ID)
+
+	usedAt := time.Now()
+	require.NoError(t, db.TouchAPIKey(first, usedAt))
+	key, err := db.GetAPIKey(first)
+	require.NoError(t, err)
+	assert.True(t, usedAt.Equal(key.LastUsedAt))
+	assert.Equal(t, []byte("first"), key.Hash)
+
+	require.NoError(t, db.DeleteAPIKey(second))
+	assert.Equal(t, inmemory.NotFoundError, db.DeleteAPIKey(second))
+	assert.Equal(t, inmemory.NotFoundError, db.TouchAPIKey(second, usedAt))
+	_, err = db.GetAPIKey(second)
+	assert.Equal(t, inmemory.NotFoundError, err)
+
+	// Updates keep the user's keys and its type; deleting the user drops them.
+	user, _ := db.GetUserById(userID)
+	user.ServiceAccount = false
+	require.NoError(t, db.UpdateUser(user))
+	user, _ = db.GetUserById(userID)
+	assert.True(t, user.ServiceAccount)
+	assert.Len(t, db.GetUserAPIKeys(userID), 1)
+
+	require.NoError(t, db.DeleteUser(userID, 0))
+	assert.Empty(t, db.GetUserAPIKeys(userID))
+	assert.Len(t, db.GetUserAPIKeys(otherID), 1)
+
+	tx := db.Begin()
+	require.NoError(t, tx.DeleteUser(otherID, 0))
+	require.NoError(t, tx.Commit())
+	assert.Empty(t, db.GetUserAPIKeys(otherID))
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestAPIKeysRecovery(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir: filepath.Join(dir, "snapshots"),
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	userID, _ := db.InsertUser(inmemory.User{Username: "ci", ServiceAccount: true})
+	deletedID, _ := db.InsertUser(inmemory.User{Username: "deleted"})
+	snapshotted, err := db.InsertAPIKey(inmemory.APIKey{UserID: userID, Name: "snapshotted", Hash: []byte("a")})
+	require.NoError(t, err)
+	_, err = db.InsertAPIKey(inmemory.APIKey{UserID: deletedID, Name: "deleted", Hash: []byte("b")})
+	require.NoError(t, err)
+	require.NoError(t, db.Snapshot())
+
+	logged, err := db.InsertAPIKey(inmemory.APIKey{UserID: userID, Name: "logged", Hash: []byte("c")})
+	require.NoError(t, err)
+	usedAt := time.Now().UTC()
+	require.NoError(t, db.TouchAPIKey(snapshotted, usedAt))
+	require.NoError(t, db.DeleteUser(deletedID, 0))
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := db.GetUserAPIKeys(userID)
+	require.Len(t, keys, 2)
+	assert.Equal(t, snapshotted, keys[0].ID)
+	assert.True(t, usedAt.Equal(keys[0].LastUsedAt))
+	assert.Equal(t, logged, keys[1].ID)
+	assert.Empty(t, db.GetUserAPIKeys(deletedID))
+
+	user, err := db.GetUserById(userID)
+	require.NoError(t, err)
+	assert.True(t, user.ServiceAccount)
+	require.NoError(t, db.CheckIndexes())
+}
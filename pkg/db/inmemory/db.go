@@ -1,29 +1,176 @@
 package inmemory
 
 import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// InMemoryDatabase keeps users in three hash indexes, by id, username and
+// email, split into lock-striped shards. Every entry lives in the shard chosen
+// by hashing its own key, so a write locks the shards of all keys it reads or
+// changes, in ascending order, and uniqueness holds across shards.
 type InMemoryDatabase struct {
+	shards        []*shard
+	normalization Normalization
+	wal           *wal
+	snapshots     *snapshotter
+
+	// commitMu serializes the final step of every write: appending to the log,
+	// updating the indexes and publishing a new version. Together with the
+	// shard locks it keeps the log order identical to the publish order.
+	commitMu sync.Mutex
+	// closed is set under commitMu once Close has closed the log. Writes
+	// fail from then on.
+	closed bool
+
+	// ordered holds the same users as the maps in persistent trees: writers
+	// build new ones under commitMu and publish them in current, which scans
+	// read without taking any lock.
+	ordered orderedIndexes
+	// keys holds the API keys by id. Like ordered, it is only changed under
+	// commitMu and published with every version.
+	keys *ptree[uuid.UUID, *APIKey]
+	// roles holds the roles by name, maintained like keys.
+	roles *ptree[string, *Role]
+	// totps holds the second factors by user id, maintained like keys.
+	totps   *ptree[uuid.UUID, *TOTP]
+	current atomic.Pointer[version]
+}
+
+type shard struct {
+	mu            sync.RWMutex
 	idIndex       map[uuid.UUID]*User
 	usernameIndex map[string]*User
-	mu            *sync.RWMutex
+	emailIndex    map[string]*User
+}
+
+// version is an immutable, consistent view of the whole database as of the
+// log record lsn. Users reachable from it are never modified.
+type version struct {
+	lsn     uint64
+	ordered orderedIndexes
+	keys    *ptree[uuid.UUID, *APIKey]
+	roles   *ptree[string, *Role]
+	totps   *ptree[uuid.UUID, *TOTP]
+}
+
+type Options struct {
+	// WAL enables the write-ahead log when WAL.Path is set.
+	WAL WALOptions
+	// Snapshot enables snapshots of a logged database when Snapshot.Dir is set.
+	Snapshot SnapshotOptions
+	// Normalization is applied to usernames and emails before indexing.
+	Normalization Normalization
+	// Shards is the number of lock stripes the indexes are split into.
+	Shards int
 }
 
 func NewInMemoryDatabase() *InMemoryDatabase {
-	return &InMemoryDatabase{
-		idIndex:       make(map[uuid.UUID]*User),
-		usernameIndex: make(map[string]*User),
-		mu:            &sync.RWMutex{},
+	return newInMemoryDatabase(Options{})
+}
+
+func newInMemoryDatabase(opts Options) *InMemoryDatabase {
+	if opts.Shards <= 0 {
+		opts.Shards = 1
+	}
+
+	db := &InMemoryDatabase{
+		shards:        make([]*shard, opts.Shards),
+		normalization: opts.Normalization,
+		ordered:       newOrderedIndexes(),
+		keys:          newPtree[uuid.UUID, *APIKey](compareIDs),
+		roles:         newPtree[string, *Role](strings.Compare),
+		totps:         newPtree[uuid.UUID, *TOTP](compareIDs),
+	}
+	for i := range db.shards {
+		db.shards[i] = &shard{
+			idIndex:       make(map[uuid.UUID]*User),
+			usernameIndex: make(map[string]*User),
+			emailIndex:    make(map[string]*User),
+		}
+	}
+	db.publish()
+
+	return db
+}
+
+// OpenInMemoryDatabase creates a database and, when a write-ahead log is
+// configured, rebuilds its indexes from the newest snapshot followed by the
+// log records written after it.
+func OpenInMemoryDatabase(opts Options) (*InMemoryDatabase, error) {
+	db := newInMemoryDatabase(opts)
+	if opts.WAL.Path == "" {
+		return db, nil
+	}
+
+	var lsn uint64
+	if opts.Snapshot.Dir != "" {
+		snapshots, err := newSnapshotter(opts.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		db.snapshots = snapshots
+
+		snap, err := loadLatestSnapshot(opts.Snapshot.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if snap != nil {
+			db.restore(snap)
+			lsn = snap.LSN
+		}
+	}
+
+	w, err := openWAL(opts.WAL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.replay(lsn, db.applyRecord); err != nil {
+		_ = w.close()
+		return nil, err
+	}
+	db.wal = w
+	db.publish()
+
+	if db.snapshots != nil {
+		db.startSnapshots()
 	}
+
+	return db, nil
+}
+
+func (db *InMemoryDatabase) Close() error {
+	if db.wal == nil {
+		return nil
+	}
+
+	db.stopSnapshots()
+
+	// Roles, API keys and second factors are written under commitMu alone.
+	unlock := db.lockAll()
+	defer unlock()
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	db.closed = true
+	return db.wal.close()
 }
 
 func (db *InMemoryDatabase) GetUserByUsername(username string) (User, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	user, ok := db.usernameIndex[username]
+	key := db.normalization.key(username)
+	s := db.shards[db.keyShard(key)]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.usernameIndex[key]
 	if !ok {
 		return User{}, NotFoundError
 	}
@@ -31,106 +178,407 @@ func (db *InMemoryDatabase) GetUserByUsername(username string) (User, error) {
 	return *user, nil
 }
 
-func (db *InMemoryDatabase) InsertUser(user User) (uuid.UUID, error) {
-	if !db.validateUniqueUsername(user.Username) {
-		return uuid.UUID{}, AlreadyExistsError
+func (db *InMemoryDatabase) GetUserByEmail(email string) (User, error) {
+	key := db.normalization.key(email)
+	s := db.shards[db.keyShard(key)]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.emailIndex[key]
+	if !ok {
+		return User{}, NotFoundError
 	}
 
-	id := uuid.New()
+	return *user, nil
+}
+
+func (db *InMemoryDatabase) InsertUser(user User) (uuid.UUID, error) {
+	user.ID = uuid.New()
+	user.Version = 1
+	user.CreatedAt = time.Now().UTC()
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	unlock := db.lockShards(db.shardsOf(&user)...)
+	defer unlock()
 
-	user.ID = id
-	db.idIndex[id] = &user
-	db.usernameIndex[user.Username] = &user
+	if err := db.validateUnique(&user); err != nil {
+		return uuid.UUID{}, err
+	}
 
-	return id, nil
+	err := db.commit(walRecord{Op: walOpInsert, User: user}, nil, []*User{&user})
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return user.ID, nil
 }
 
 func (db *InMemoryDatabase) GetUserById(id uuid.UUID) (User, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	s := db.shards[db.idShard(id)]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	user, ok := db.idIndex[id]
+	user, ok := s.idIndex[id]
 	if !ok {
 		return User{}, NotFoundError
 	}
 
-	return User{
-		ID:       user.ID,
-		Email:    user.Email,
-		Username: user.Username,
-		Admin:    user.Admin,
-	}, nil
+	return withoutPassword(user), nil
 }
 
+// GetUsers returns every user ordered by username. It reads the latest
+// published version and never blocks, nor is blocked by, writers.
 func (db *InMemoryDatabase) GetUsers() []User {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	v := db.current.Load()
 
-	i := 0
-	users := make([]User, len(db.idIndex))
-	for id, user := range db.idIndex {
-		users[i] = User{
-			ID:       id,
-			Email:    user.Email,
-			Username: user.Username,
-			Admin:    user.Admin,
-		}
-		i++
-	}
+	users := make([]User, 0, v.ordered.usernames.Len())
+	v.ordered.usernames.Ascend(func(_ string, user *User) bool {
+		users = append(users, withoutPassword(user))
+		return true
+	})
 
 	return users
 }
 
+// UpdateUser replaces the user with the same id, keeping its password if
+// userUpdated.Password is empty. A non-zero userUpdated.Version must match the
+// stored version, otherwise the update is rejected with VersionMismatchError.
 func (db *InMemoryDatabase) UpdateUser(userUpdated User) error {
-	db.mu.RLock()
-	user, ok := db.idIndex[userUpdated.ID]
-	if !ok {
-		db.mu.RUnlock()
-		return NotFoundError
+	user, unlock, err := db.lockUser(userUpdated.ID, db.shardsOf(&userUpdated)...)
+	if err != nil {
+		return err
 	}
-	db.mu.RUnlock()
+	defer unlock()
 
-	if user.Username != userUpdated.Username &&
-		!db.validateUniqueUsername(userUpdated.Username) {
-		return AlreadyExistsError
+	if err = checkVersion(user, userUpdated.Version); err != nil {
+		return err
+	}
+	if err = db.validateUnique(&userUpdated); err != nil {
+		return err
+	}
+	userUpdated.Version = user.Version + 1
+	userUpdated.CreatedAt = user.CreatedAt
+	if userUpdated.Password == "" {
+		userUpdated.Password = user.Password
+	}
+	userUpdated.ServiceAccount = user.ServiceAccount
+
+	return db.commit(walRecord{Op: walOpUpdate, User: userUpdated}, []*User{user}, []*User{&userUpdated})
+}
+
+// DeleteUser removes the user with the given id together with its API keys
+// and second factor.
+// Like UpdateUser, it only deletes a user at the given version unless version
+// is zero; deleting a missing user succeeds only without a version.
+func (db *InMemoryDatabase) DeleteUser(id uuid.UUID, version uint64) error {
+	user, unlock, err := db.lockUser(id)
+	if err != nil {
+		if err == NotFoundError {
+			return checkVersion(nil, version)
+		}
+		return err
+	}
+	defer unlock()
+
+	if err = checkVersion(user, version); err != nil {
+		return err
+	}
+
+	return db.commit(walRecord{Op: walOpDelete, User: User{ID: id}}, []*User{user}, nil)
+}
+
+// lockUser locks the shards holding the user with the given id, its username
+// and its email, together with the extra shards, and returns the user. If the
+// user changes between the lookup and the locking, it retries.
+func (db *InMemoryDatabase) lockUser(id uuid.UUID, extra ...int) (*User, func(), error) {
+	s := db.shards[db.idShard(id)]
+
+	for {
+		s.mu.RLock()
+		user, ok := s.idIndex[id]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, nil, NotFoundError
+		}
+
+		unlock := db.lockShards(append(db.shardsOf(user), extra...)...)
+		if current, ok := s.idIndex[id]; ok && current == user {
+			return user, unlock, nil
+		}
+		unlock()
+	}
+}
+
+// commit logs record, replaces the removed users with the added ones in every
+// index, drops the API keys and second factors of users that were removed for
+// good and publishes the result. The caller must hold the locks of all shards the users live in.
+//
+// Roles only change under commitMu, so the added users are checked to have
+// existing roles here rather than by the caller.
+func (db *InMemoryDatabase) commit(record walRecord, removed, added []*User) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	for _, user := range added {
+		for _, role := range user.Roles {
+			if _, ok := db.roles.Get(role); !ok {
+				return UnknownRoleError
+			}
+		}
+	}
+
+	if err := db.log(record); err != nil {
+		return err
+	}
+
+	for _, user := range removed {
+		db.unindex(user)
 	}
+	for _, user := range added {
+		db.index(user)
+	}
+	for _, user := range removed {
+		if _, ok := db.byID(user.ID); !ok {
+			db.deleteKeysOf(user.ID)
+			db.totps = db.totps.Delete(user.ID)
+		}
+	}
+	db.publish()
+
+	return nil
+}
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// checkVersion reports VersionMismatchError unless expected is zero or the
+// version of user, which may be nil if the user does not exist.
+func checkVersion(user *User, expected uint64) error {
+	if expected == 0 {
+		return nil
+	}
+	if user == nil || user.Version != expected {
+		return VersionMismatchError
+	}
+
+	return nil
+}
 
-	delete(db.usernameIndex, user.Username)
-	db.usernameIndex[userUpdated.Username] = user
+// validateUnique checks that the username and email of user are either free
+// or already owned by user itself. The caller must hold the locks of the
+// shards they map to, so the check and the following write happen atomically.
+func (db *InMemoryDatabase) validateUnique(user *User) error {
+	if owner, ok := db.byUsername(user.Username); ok && owner.ID != user.ID {
+		return AlreadyExistsError
+	}
 
-	user.Username = userUpdated.Username
-	user.Email = userUpdated.Email
-	user.Admin = userUpdated.Admin
-	user.Password = userUpdated.Password
+	if user.Email == "" {
+		return nil
+	}
+	if owner, ok := db.byEmail(user.Email); ok && owner.ID != user.ID {
+		return EmailAlreadyExistsError
+	}
 
 	return nil
 }
 
-func (db *InMemoryDatabase) DeleteUser(id uuid.UUID) {
-	db.mu.RLock()
-	user, ok := db.idIndex[id]
-	if !ok {
-		db.mu.RUnlock()
-		return
+// byID, byUsername and byEmail look a user up without locking; the caller
+// must hold the lock of the shard the key maps to.
+func (db *InMemoryDatabase) byID(id uuid.UUID) (*User, bool) {
+	user, ok := db.shards[db.idShard(id)].idIndex[id]
+	return user, ok
+}
+
+func (db *InMemoryDatabase) byUsername(username string) (*User, bool) {
+	key := db.normalization.key(username)
+	user, ok := db.shards[db.keyShard(key)].usernameIndex[key]
+	return user, ok
+}
+
+func (db *InMemoryDatabase) byEmail(email string) (*User, bool) {
+	if email == "" {
+		return nil, false
+	}
+
+	key := db.normalization.key(email)
+	user, ok := db.shards[db.keyShard(key)].emailIndex[key]
+	return user, ok
+}
+
+func (db *InMemoryDatabase) index(user *User) {
+	key := db.normalization.key(user.Username)
+	db.shards[db.idShard(user.ID)].idIndex[user.ID] = user
+	db.shards[db.keyShard(key)].usernameIndex[key] = user
+	db.ordered = db.ordered.with(user, db.normalization)
+	if user.Email != "" {
+		emailKey := db.normalization.key(user.Email)
+		db.shards[db.keyShard(emailKey)].emailIndex[emailKey] = user
+	}
+}
+
+func (db *InMemoryDatabase) unindex(user *User) {
+	key := db.normalization.key(user.Username)
+	delete(db.shards[db.idShard(user.ID)].idIndex, user.ID)
+	delete(db.shards[db.keyShard(key)].usernameIndex, key)
+	db.ordered = db.ordered.without(user, db.normalization)
+	if user.Email != "" {
+		emailKey := db.normalization.key(user.Email)
+		delete(db.shards[db.keyShard(emailKey)].emailIndex, emailKey)
+	}
+}
+
+// shardsOf returns the shards holding the id, username and email of user.
+func (db *InMemoryDatabase) shardsOf(user *User) []int {
+	shards := []int{
+		db.idShard(user.ID),
+		db.keyShard(db.normalization.key(user.Username)),
+	}
+	if user.Email != "" {
+		shards = append(shards, db.keyShard(db.normalization.key(user.Email)))
+	}
+
+	return shards
+}
+
+func (db *InMemoryDatabase) idShard(id uuid.UUID) int {
+	return int(binary.BigEndian.Uint32(id[:4]) % uint32(len(db.shards)))
+}
+
+// keyShard hashes a normalized username or email with FNV-1a.
+func (db *InMemoryDatabase) keyShard(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return int(hash % uint32(len(db.shards)))
+}
+
+// lockShards write-locks the given shards in ascending order, which keeps
+// writers that need several shards from deadlocking each other.
+func (db *InMemoryDatabase) lockShards(indexes ...int) func() {
+	sort.Ints(indexes)
+
+	locked := make([]*shard, 0, len(indexes))
+	for i, index := range indexes {
+		if i > 0 && indexes[i-1] == index {
+			continue
+		}
+		db.shards[index].mu.Lock()
+		locked = append(locked, db.shards[index])
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}
+}
+
+func (db *InMemoryDatabase) lockAll() func() {
+	for _, s := range db.shards {
+		s.mu.Lock()
+	}
+
+	return func() {
+		for i := len(db.shards) - 1; i >= 0; i-- {
+			db.shards[i].mu.Unlock()
+		}
+	}
+}
+
+func (db *InMemoryDatabase) rlockAll() func() {
+	for _, s := range db.shards {
+		s.mu.RLock()
+	}
+
+	return func() {
+		for i := len(db.shards) - 1; i >= 0; i-- {
+			db.shards[i].mu.RUnlock()
+		}
+	}
+}
+
+// publish makes the current state visible to GetUsers and snapshots. Writers
+// call it once per write, after all of its index changes, while holding
+// commitMu.
+func (db *InMemoryDatabase) publish() {
+	v := &version{ordered: db.ordered, keys: db.keys, roles: db.roles, totps: db.totps}
+	if db.wal != nil {
+		v.lsn = db.wal.lastLSN()
+	}
+	db.current.Store(v)
+}
+
+func (db *InMemoryDatabase) log(record walRecord) error {
+	if db.wal == nil {
+		return nil
+	}
+	if db.closed {
+		return DatabaseClosedError
 	}
-	db.mu.RUnlock()
 
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	if err := db.wal.append(record); err != nil {
+		return err
+	}
+	db.maybeSnapshot()
 
-	delete(db.idIndex, id)
-	delete(db.usernameIndex, user.Username)
+	return nil
+}
+
+func (db *InMemoryDatabase) restore(snap *snapshot) {
+	for i := range snap.Users {
+		user := snap.Users[i]
+		db.index(&user)
+	}
+	for i := range snap.Keys {
+		key := snap.Keys[i]
+		db.keys = db.keys.Set(key.ID, &key)
+	}
+	for i := range snap.Roles {
+		role := snap.Roles[i]
+		db.roles = db.roles.Set(role.Name, &role)
+	}
+	for i := range snap.TOTPs {
+		totp := snap.TOTPs[i]
+		db.totps = db.totps.Set(totp.UserID, &totp)
+	}
 }
 
-func (db *InMemoryDatabase) validateUniqueUsername(username string) bool {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	_, ok := db.usernameIndex[username]
-	return !ok
+func (db *InMemoryDatabase) applyRecord(record walRecord) error {
+	user := record.User
+
+	switch record.Op {
+	case walOpInsert:
+		db.index(&user)
+	case walOpUpdate:
+		existing, ok := db.byID(user.ID)
+		if !ok {
+			return NotFoundError
+		}
+		db.unindex(existing)
+		db.index(&user)
+	case walOpDelete:
+		existing, ok := db.byID(user.ID)
+		if !ok {
+			return nil
+		}
+		db.unindex(existing)
+		db.deleteKeysOf(user.ID)
+		db.totps = db.totps.Delete(user.ID)
+	case walOpPutKey, walOpDeleteKey:
+		db.applyKeyRecord(record)
+	case walOpPutRole, walOpDeleteRole:
+		db.applyRoleRecord(record)
+	case walOpPutTOTP, walOpDeleteTOTP:
+		db.applyTOTPRecord(record)
+	case walOpTxn:
+		for _, op := range record.Batch {
+			if err := db.applyRecord(op); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown wal operation %q", record.Op)
+	}
+
+	return nil
 }
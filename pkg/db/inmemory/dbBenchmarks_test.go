@@ -22,7 +22,14 @@ func BenchmarkInsertUser(b *testing.B) {
 }
 
 func BenchmarkConcurrentInsertUser(b *testing.B) {
-	db := inmemory.NewInMemoryDatabase()
+	benchmarkConcurrentInsertUser(b, inmemory.NewInMemoryDatabase())
+}
+
+func BenchmarkConcurrentInsertUserSharded(b *testing.B) {
+	benchmarkConcurrentInsertUser(b, newShardedDatabase(64))
+}
+
+func benchmarkConcurrentInsertUser(b *testing.B, db *inmemory.InMemoryDatabase) {
 	users := generateTestUsers(b.N)
 
 	b.ResetTimer()
@@ -81,6 +88,113 @@ func BenchmarkConcurrentGetUserByUsername(b *testing.B) {
 	wg.Wait()
 }
 
+const benchmarkPopulation = 10000
+
+func BenchmarkGetUsers(b *testing.B) {
+	db := populatedDatabase(b, benchmarkPopulation)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		db.GetUsers()
+	}
+}
+
+func BenchmarkFindUsersPage(b *testing.B) {
+	db := populatedDatabase(b, benchmarkPopulation)
+	query := inmemory.Query{
+		Filter: inmemory.UserFilter{EmailPrefix: "a"},
+		SortBy: inmemory.SortByEmail,
+		Limit:  50,
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		page, err := db.FindUsers(query)
+		assert.NoError(b, err)
+		query.Cursor = page.NextCursor
+	}
+}
+
+func BenchmarkInsertUserDuringGetUsers(b *testing.B) {
+	db := populatedDatabase(b, benchmarkPopulation)
+	users := generateTestUsers(b.N)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					db.GetUsers()
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := db.InsertUser(users[i])
+		assert.NoError(b, err)
+	}
+
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkMixedReadWrite(b *testing.B) {
+	db := populatedDatabase(b, benchmarkPopulation)
+	ids := make([]uuid.UUID, 0, benchmarkPopulation)
+	for _, user := range db.GetUsers() {
+		ids = append(ids, user.ID)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			switch {
+			case i%100 == 0:
+				db.GetUsers()
+			case i%10 == 0:
+				_, err := db.InsertUser(inmemory.User{
+					Username: uuid.New().String(),
+					Email:    uuid.New().String() + "@example.com",
+				})
+				assert.NoError(b, err)
+			case i%10 == 1:
+				user, err := db.GetUserById(ids[i%len(ids)])
+				assert.NoError(b, err)
+				user.Admin = !user.Admin
+				assert.NoError(b, db.UpdateUser(user))
+			default:
+				_, err := db.GetUserById(ids[i%len(ids)])
+				assert.NoError(b, err)
+			}
+		}
+	})
+}
+
+func populatedDatabase(b *testing.B, count int) *inmemory.InMemoryDatabase {
+	db := inmemory.NewInMemoryDatabase()
+	for _, user := range generateTestUsers(count) {
+		_, err := db.InsertUser(user)
+		assert.NoError(b, err)
+	}
+
+	return db
+}
+
 func generateTestUsers(count int) []inmemory.User {
 	users := make([]inmemory.User, count)
 	for i := 0; i < count; i++ {
@@ -150,12 +150,146 @@ func TestDeleteUser(t *testing.T) {
 	}
 	id, _ := db.InsertUser(testUser)
 
-	db.DeleteUser(id)
+	db.DeleteUser(id, 0)
 
 	_, err := db.GetUserById(id)
 	assert.Error(t, err)
 	assert.Equal(t, inmemory.NotFoundError, err)
 
 	nonexistentID := uuid.New()
-	db.DeleteUser(nonexistentID)
+	db.DeleteUser(nonexistentID, 0)
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	testUser := inmemory.User{
+		Username: "testuser",
+		Email:    "testuser@example.com",
+		Password: "password",
+	}
+	id, _ := db.InsertUser(testUser)
+
+	user, err := db.GetUserByEmail("testuser@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, testUser.Username, user.Username)
+
+	_, err = db.GetUserByEmail("nonexistent@example.com")
+	assert.Equal(t, inmemory.NotFoundError, err)
+}
+
+func TestUniqueEmail(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, err := db.InsertUser(inmemory.User{Username: "user1", Email: "shared@example.com"})
+	assert.NoError(t, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "user2", Email: "shared@example.com"})
+	assert.Equal(t, inmemory.EmailAlreadyExistsError, err)
+
+	otherID, err := db.InsertUser(inmemory.User{Username: "user2", Email: "other@example.com"})
+	assert.NoError(t, err)
+
+	err = db.UpdateUser(inmemory.User{ID: otherID, Username: "user2", Email: "shared@example.com"})
+	assert.Equal(t, inmemory.EmailAlreadyExistsError, err)
+
+	err = db.UpdateUser(inmemory.User{ID: id, Username: "user1", Email: "moved@example.com"})
+	assert.NoError(t, err)
+	_, err = db.GetUserByEmail("shared@example.com")
+	assert.Equal(t, inmemory.NotFoundError, err)
+
+	err = db.UpdateUser(inmemory.User{ID: otherID, Username: "user2", Email: "shared@example.com"})
+	assert.NoError(t, err)
+}
+
+func TestNormalizedLookups(t *testing.T) {
+	db, err := inmemory.OpenInMemoryDatabase(inmemory.Options{
+		Normalization: inmemory.Normalization{
+			CaseFold: true,
+			NFKC:     true,
+		},
+	})
+	assert.NoError(t, err)
+
+	id, err := db.InsertUser(inmemory.User{Username: "Admin", Email: "Admin@Example.com"})
+	assert.NoError(t, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "admin", Email: "other@example.com"})
+	assert.Equal(t, inmemory.AlreadyExistsError, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "Ａｄｍｉｎ", Email: "other@example.com"})
+	assert.Equal(t, inmemory.AlreadyExistsError, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "other", Email: "ADMIN@example.COM"})
+	assert.Equal(t, inmemory.EmailAlreadyExistsError, err)
+
+	user, err := db.GetUserByUsername("ADMIN")
+	assert.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, "Admin", user.Username)
+
+	user, err = db.GetUserByEmail("admin@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, "Admin@Example.com", user.Email)
+}
+
+func TestUpdateUserVersion(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user", Email: "user@example.com"})
+	user, err := db.GetUserById(id)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), user.Version)
+
+	user.Admin = true
+	assert.NoError(t, db.UpdateUser(user))
+
+	user.Admin = false
+	assert.Equal(t, inmemory.VersionMismatchError, db.UpdateUser(user))
+
+	user, err = db.GetUserById(id)
+	assert.NoError(t, err)
+	assert.True(t, user.Admin)
+	assert.Equal(t, uint64(2), user.Version)
+
+	user.Version = 0
+	assert.NoError(t, db.UpdateUser(user))
+	user, _ = db.GetUserById(id)
+	assert.Equal(t, uint64(3), user.Version)
+}
+
+func TestDeleteUserVersion(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user"})
+	assert.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "renamed", Version: 1}))
+
+	assert.Equal(t, inmemory.VersionMismatchError, db.DeleteUser(id, 1))
+	_, err := db.GetUserById(id)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.DeleteUser(id, 2))
+	_, err = db.GetUserById(id)
+	assert.Equal(t, inmemory.NotFoundError, err)
+
+	assert.Equal(t, inmemory.VersionMismatchError, db.DeleteUser(id, 2))
+	assert.NoError(t, db.DeleteUser(id, 0))
+}
+
+func TestUpdateUserKeepsPassword(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user", Password: "hash"})
+
+	assert.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "renamed"}))
+	user, err := db.GetUserByUsername("renamed")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash", user.Password)
+
+	assert.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "renamed", Password: "new"}))
+	user, err = db.GetUserByUsername("renamed")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", user.Password)
 }
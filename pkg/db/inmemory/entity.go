@@ -1,6 +1,8 @@
 package inmemory
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -9,5 +11,81 @@ type User struct {
 	Email    string
 	Username string
 	Password string
-	Admin    bool
+	// Admin is the flag that granted every permission before roles existed.
+	// It is kept so that older data can still be read and migrated.
+	Admin bool
+	// Roles name the roles whose permissions the user has. Every one of them
+	// must exist when the user is written.
+	Roles []string
+	// ServiceAccount users have no password and authenticate with API keys.
+	// It is fixed when the user is inserted.
+	ServiceAccount bool
+	// EmailVerified is set once the user has shown to receive mail at Email.
+	EmailVerified bool
+	// Pending users were invited and have not accepted the invite yet.
+	Pending bool
+	// Status is empty for active users, "suspended" or "deactivated".
+	// Suspended users are suspended until SuspendedUntil, or for good if it
+	// is zero.
+	Status         string
+	StatusReason   string
+	SuspendedUntil time.Time
+	// Version starts at 1 and grows with every update of the user.
+	Version   uint64
+	CreatedAt time.Time
+}
+
+// APIKey is a credential of a user. Only a hash of its secret is stored.
+type APIKey struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Name      string
+	Hash      []byte
+	Scopes    []string
+	CreatedAt time.Time
+	// ExpiresAt is zero for keys that never expire.
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Role is a named set of permissions.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+	// RequireTOTP makes a second factor mandatory for the users of the role.
+	RequireTOTP bool
+	CreatedAt   time.Time
+}
+
+// TOTP is the second factor of a user. Secret is stored as the caller passes
+// it, which is expected to be encrypted; RecoveryCodes holds hashes of the
+// recovery codes that have not been used.
+type TOTP struct {
+	UserID    uuid.UUID
+	Secret    []byte
+	Confirmed bool
+	// LastStep is the time step of the last code accepted, so no code can
+	// be used twice.
+	LastStep      uint64
+	RecoveryCodes [][]byte
+	CreatedAt     time.Time
+}
+
+func withoutPassword(user *User) User {
+	return User{
+		ID:             user.ID,
+		Email:          user.Email,
+		Username:       user.Username,
+		Admin:          user.Admin,
+		Roles:          user.Roles,
+		ServiceAccount: user.ServiceAccount,
+		EmailVerified:  user.EmailVerified,
+		Pending:        user.Pending,
+		Status:         user.Status,
+		StatusReason:   user.StatusReason,
+		SuspendedUntil: user.SuspendedUntil,
+		Version:        user.Version,
+		CreatedAt:      user.CreatedAt,
+	}
 }
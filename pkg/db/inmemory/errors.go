@@ -6,4 +6,24 @@ var NotFoundError = errors.New("not found")
 
 var AlreadyExistsError = errors.New("already exists")
 
+var EmailAlreadyExistsError = errors.New("email already exists")
+
 var MissingRequiredFieldsError = errors.New("missing required fields")
+
+var VersionMismatchError = errors.New("version mismatch")
+
+var InvalidCursorError = errors.New("invalid cursor")
+
+var InvalidSortFieldError = errors.New("invalid sort field")
+
+var TransactionDoneError = errors.New("transaction already committed or rolled back")
+
+var SnapshotsDisabledError = errors.New("snapshots disabled")
+
+var DatabaseClosedError = errors.New("database closed")
+
+var CorruptSnapshotError = errors.New("corrupt snapshot")
+
+var UnknownRoleError = errors.New("unknown role")
+
+var RoleInUseError = errors.New("role is assigned to users")
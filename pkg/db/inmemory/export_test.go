@@ -0,0 +1,156 @@
+package inmemory
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// CheckIndexes verifies that every index points at the same set of users, that
+// every entry lives in the shard its key maps to, that every API key belongs
+// to a stored user and that every role of a user exists.
+func (db *InMemoryDatabase) CheckIndexes() error {
+	unlock := db.rlockAll()
+	defer unlock()
+
+	idIndex := make(map[uuid.UUID]*User)
+	usernameIndex := make(map[string]*User)
+	emailIndex := make(map[string]*User)
+	for i, s := range db.shards {
+		for id, user := range s.idIndex {
+			if db.idShard(id) != i {
+				return fmt.Errorf("id %s is stored in shard %d", id, i)
+			}
+			idIndex[id] = user
+		}
+		for key, user := range s.usernameIndex {
+			if db.keyShard(key) != i {
+				return fmt.Errorf("username %q is stored in shard %d", key, i)
+			}
+			usernameIndex[key] = user
+		}
+		for key, user := range s.emailIndex {
+			if db.keyShard(key) != i {
+				return fmt.Errorf("email %q is stored in shard %d", key, i)
+			}
+			emailIndex[key] = user
+		}
+	}
+
+	emails := 0
+	for id, user := range idIndex {
+		if user.ID != id {
+			return fmt.Errorf("user %s is indexed under id %s", user.ID, id)
+		}
+		if usernameIndex[db.normalization.key(user.Username)] != user {
+			return fmt.Errorf("user %s is missing from the username index", id)
+		}
+		if user.Email != "" {
+			emails++
+			if emailIndex[db.normalization.key(user.Email)] != user {
+				return fmt.Errorf("user %s is missing from the email index", id)
+			}
+		}
+	}
+
+	for key, user := range usernameIndex {
+		if idIndex[user.ID] != user {
+			return fmt.Errorf("username %q points at a user missing from the id index", key)
+		}
+	}
+	for key, user := range emailIndex {
+		if idIndex[user.ID] != user {
+			return fmt.Errorf("email %q points at a user missing from the id index", key)
+		}
+	}
+
+	for name, length := range map[string]int{
+		"username": db.ordered.usernames.Len(),
+		"email":    db.ordered.emails.Len(),
+		"creation": db.ordered.created.Len(),
+	} {
+		if length != len(idIndex) {
+			return fmt.Errorf("%s order holds %d users, id index %d", name, length, len(idIndex))
+		}
+	}
+	if v := db.current.Load(); v.ordered != db.ordered || v.keys != db.keys || v.roles != db.roles || v.totps != db.totps {
+		return fmt.Errorf("latest version is not published")
+	}
+
+	var ordered error
+	db.ordered.usernames.Ascend(func(key string, user *User) bool {
+		if usernameIndex[key] != user {
+			ordered = fmt.Errorf("ordered index entry %q differs from the username index", key)
+			return false
+		}
+		return true
+	})
+	db.ordered.emails.Ascend(func(key sortKey, user *User) bool {
+		if idIndex[key.id] != user || key != emailSortKey(user, db.normalization) {
+			ordered = fmt.Errorf("email order entry of %s differs from the id index", key.id)
+			return false
+		}
+		return true
+	})
+	db.ordered.created.Ascend(func(key sortKey, user *User) bool {
+		if idIndex[key.id] != user || key != createdSortKey(user) {
+			ordered = fmt.Errorf("creation order entry of %s differs from the id index", key.id)
+			return false
+		}
+		return true
+	})
+	if ordered != nil {
+		return ordered
+	}
+
+	var keys error
+	db.keys.Ascend(func(id uuid.UUID, key *APIKey) bool {
+		if key.ID != id || idIndex[key.UserID] == nil {
+			keys = fmt.Errorf("API key %s belongs to missing user %s", id, key.UserID)
+			return false
+		}
+		return true
+	})
+	if keys != nil {
+		return keys
+	}
+	db.totps.Ascend(func(id uuid.UUID, totp *TOTP) bool {
+		if totp.UserID != id || idIndex[id] == nil {
+			keys = fmt.Errorf("second factor of missing user %s", id)
+			return false
+		}
+		return true
+	})
+	if keys != nil {
+		return keys
+	}
+
+	for id, user := range idIndex {
+		for _, role := range user.Roles {
+			if _, ok := db.roles.Get(role); !ok {
+				return fmt.Errorf("user %s has missing role %q", id, role)
+			}
+		}
+	}
+
+	if len(usernameIndex) != len(idIndex) || len(emailIndex) != emails {
+		return fmt.Errorf(
+			"index sizes differ: %d ids, %d usernames, %d emails for %d users with email",
+			len(idIndex), len(usernameIndex), len(emailIndex), emails,
+		)
+	}
+
+	return nil
+}
+
+// FailSyncDir makes syncing dir fail until the returned function is called.
+func FailSyncDir(dir string, err error) (restore func()) {
+	syncDir = func(d string) error {
+		if d == dir {
+			return err
+		}
+		return fsyncDir(d)
+	}
+
+	return func() { syncDir = fsyncDir }
+}
@@ -0,0 +1,31 @@
+package inmemory
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization controls how usernames and emails are turned into index keys.
+// The stored values are never modified, only the keys used for uniqueness
+// checks and lookups.
+type Normalization struct {
+	// CaseFold applies Unicode case folding so that "Admin" and "admin" match.
+	CaseFold bool
+	// NFKC applies Unicode NFKC normalization so that compatibility forms
+	// such as full-width letters match their canonical equivalents.
+	NFKC bool
+}
+
+func (n Normalization) key(s string) string {
+	if n.NFKC {
+		s = norm.NFKC.String(s)
+	}
+	if n.CaseFold {
+		s = cases.Fold().String(s)
+		if n.NFKC {
+			s = norm.NFKC.String(s)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,190 @@
+package inmemory
+
+import "math/rand"
+
+// ptree is a persistent ordered map implemented as a treap. Every update
+// returns a new tree that shares all untouched nodes with the old one, so a
+// tree that has been handed to readers is never modified and can be walked
+// without locks while writers keep producing newer trees.
+type ptree[K any, V any] struct {
+	root *pnode[K, V]
+	len  int
+	cmp  func(a, b K) int
+}
+
+type pnode[K any, V any] struct {
+	key      K
+	value    V
+	priority uint32
+	left     *pnode[K, V]
+	right    *pnode[K, V]
+}
+
+func newPtree[K any, V any](cmp func(a, b K) int) *ptree[K, V] {
+	return &ptree[K, V]{cmp: cmp}
+}
+
+func (t *ptree[K, V]) Len() int {
+	return t.len
+}
+
+func (t *ptree[K, V]) Get(key K) (V, bool) {
+	n := t.root
+	for n != nil {
+		switch c := t.cmp(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n.value, true
+		}
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Set returns a tree in which key maps to value.
+func (t *ptree[K, V]) Set(key K, value V) *ptree[K, V] {
+	root, added := t.insert(t.root, key, value)
+
+	length := t.len
+	if added {
+		length++
+	}
+
+	return &ptree[K, V]{root: root, len: length, cmp: t.cmp}
+}
+
+// Delete returns a tree without key.
+func (t *ptree[K, V]) Delete(key K) *ptree[K, V] {
+	root, removed := t.delete(t.root, key)
+	if !removed {
+		return t
+	}
+
+	return &ptree[K, V]{root: root, len: t.len - 1, cmp: t.cmp}
+}
+
+// Ascend calls fn for every entry in key order until fn returns false.
+func (t *ptree[K, V]) Ascend(fn func(key K, value V) bool) {
+	ascend(t.root, fn)
+}
+
+// AscendFrom calls fn for every entry with a key greater than or equal to
+// from, in key order, until fn returns false.
+func (t *ptree[K, V]) AscendFrom(from K, fn func(key K, value V) bool) {
+	t.ascendFrom(t.root, from, fn)
+}
+
+func (t *ptree[K, V]) insert(n *pnode[K, V], key K, value V) (*pnode[K, V], bool) {
+	if n == nil {
+		return &pnode[K, V]{key: key, value: value, priority: rand.Uint32()}, true
+	}
+
+	copied := *n
+	c := t.cmp(key, n.key)
+	switch {
+	case c < 0:
+		left, added := t.insert(n.left, key, value)
+		copied.left = left
+		if left.priority > copied.priority {
+			return rotateRight(&copied), added
+		}
+		return &copied, added
+	case c > 0:
+		right, added := t.insert(n.right, key, value)
+		copied.right = right
+		if right.priority > copied.priority {
+			return rotateLeft(&copied), added
+		}
+		return &copied, added
+	default:
+		copied.value = value
+		return &copied, false
+	}
+}
+
+func (t *ptree[K, V]) delete(n *pnode[K, V], key K) (*pnode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	c := t.cmp(key, n.key)
+	if c == 0 {
+		return merge(n.left, n.right), true
+	}
+
+	var removed bool
+	copied := *n
+	if c < 0 {
+		copied.left, removed = t.delete(n.left, key)
+	} else {
+		copied.right, removed = t.delete(n.right, key)
+	}
+	if !removed {
+		return n, false
+	}
+
+	return &copied, true
+}
+
+func (t *ptree[K, V]) ascendFrom(n *pnode[K, V], from K, fn func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	if t.cmp(n.key, from) < 0 {
+		return t.ascendFrom(n.right, from, fn)
+	}
+
+	return t.ascendFrom(n.left, from, fn) &&
+		fn(n.key, n.value) &&
+		ascend(n.right, fn)
+}
+
+func ascend[K any, V any](n *pnode[K, V], fn func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	return ascend(n.left, fn) &&
+		fn(n.key, n.value) &&
+		ascend(n.right, fn)
+}
+
+// rotateRight and rotateLeft only modify n and its replaced child, both of
+// which must be fresh copies owned by the caller.
+func rotateRight[K any, V any](n *pnode[K, V]) *pnode[K, V] {
+	left := n.left
+	n.left = left.right
+	left.right = n
+	return left
+}
+
+func rotateLeft[K any, V any](n *pnode[K, V]) *pnode[K, V] {
+	right := n.right
+	n.right = right.left
+	right.left = n
+	return right
+}
+
+func merge[K any, V any](a, b *pnode[K, V]) *pnode[K, V] {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	if a.priority > b.priority {
+		copied := *a
+		copied.right = merge(a.right, b)
+		return &copied
+	}
+
+	copied := *b
+	copied.left = merge(a, b.left)
+	return &copied
+}
@@ -0,0 +1,78 @@
+package inmemory
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPtreeMatchesMap(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tree := newPtree[string, int](strings.Compare)
+	expected := make(map[string]int)
+
+	for i := 0; i < 5000; i++ {
+		key := string(rune('a' + rnd.Intn(26)))
+		key += string(rune('a' + rnd.Intn(26)))
+
+		if rnd.Intn(3) == 0 {
+			tree = tree.Delete(key)
+			delete(expected, key)
+		} else {
+			tree = tree.Set(key, i)
+			expected[key] = i
+		}
+	}
+
+	assert.Equal(t, len(expected), tree.Len())
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+		value, ok := tree.Get(key)
+		assert.True(t, ok)
+		assert.Equal(t, expected[key], value)
+	}
+	sort.Strings(keys)
+
+	var ascended []string
+	tree.Ascend(func(key string, value int) bool {
+		ascended = append(ascended, key)
+		return true
+	})
+	assert.Equal(t, keys, ascended)
+
+	from := keys[len(keys)/2]
+	ascended = ascended[:0]
+	tree.AscendFrom(from, func(key string, value int) bool {
+		ascended = append(ascended, key)
+		return len(ascended) < 10
+	})
+	assert.Equal(t, keys[len(keys)/2:len(keys)/2+10], ascended)
+}
+
+func TestPtreeIsPersistent(t *testing.T) {
+	empty := newPtree[string, int](strings.Compare)
+	one := empty.Set("a", 1)
+	two := one.Set("b", 2)
+	updated := two.Set("a", 10)
+	deleted := updated.Delete("b")
+
+	assert.Equal(t, 0, empty.Len())
+	assert.Equal(t, 1, one.Len())
+	assert.Equal(t, 2, two.Len())
+	assert.Equal(t, 1, deleted.Len())
+
+	value, _ := two.Get("a")
+	assert.Equal(t, 1, value)
+	value, _ = updated.Get("a")
+	assert.Equal(t, 10, value)
+	_, ok := deleted.Get("b")
+	assert.False(t, ok)
+	_, ok = updated.Get("b")
+	assert.True(t, ok)
+	assert.Same(t, deleted, deleted.Delete("missing"))
+}
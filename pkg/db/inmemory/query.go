@@ -0,0 +1,247 @@
+package inmemory
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SortField string
+
+const (
+	SortByUsername  SortField = "username"
+	SortByEmail     SortField = "email"
+	SortByCreatedAt SortField = "createdAt"
+)
+
+// UserFilter selects users by all of its non-zero fields.
+type UserFilter struct {
+	// Role keeps users that have the role, WithoutRole those that do not.
+	Role           string
+	WithoutRole    string
+	UsernamePrefix string
+	EmailPrefix    string
+	// CreatedAfter keeps users created strictly after it.
+	CreatedAfter  time.Time
+	EmailVerified *bool
+}
+
+type Query struct {
+	Filter UserFilter
+	// SortBy orders users ascending by the field; username by default.
+	SortBy SortField
+	// Cursor continues a previous query from its Page.NextCursor.
+	Cursor string
+	// Limit caps the number of users in the page; zero means no limit.
+	Limit int
+}
+
+type Page struct {
+	Users []User
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// orderedIndexes are persistent trees holding every user in the orders
+// FindUsers can return them in. Emails and creation times are not unique, so
+// their keys end with the user id.
+type orderedIndexes struct {
+	usernames *ptree[string, *User]
+	emails    *ptree[sortKey, *User]
+	created   *ptree[sortKey, *User]
+}
+
+type sortKey struct {
+	value   string
+	created time.Time
+	id      uuid.UUID
+}
+
+func newOrderedIndexes() orderedIndexes {
+	return orderedIndexes{
+		usernames: newPtree[string, *User](strings.Compare),
+		emails:    newPtree[sortKey, *User](compareByEmail),
+		created:   newPtree[sortKey, *User](compareByCreated),
+	}
+}
+
+func (o orderedIndexes) with(user *User, n Normalization) orderedIndexes {
+	return orderedIndexes{
+		usernames: o.usernames.Set(n.key(user.Username), user),
+		emails:    o.emails.Set(emailSortKey(user, n), user),
+		created:   o.created.Set(createdSortKey(user), user),
+	}
+}
+
+func (o orderedIndexes) without(user *User, n Normalization) orderedIndexes {
+	return orderedIndexes{
+		usernames: o.usernames.Delete(n.key(user.Username)),
+		emails:    o.emails.Delete(emailSortKey(user, n)),
+		created:   o.created.Delete(createdSortKey(user)),
+	}
+}
+
+func emailSortKey(user *User, n Normalization) sortKey {
+	return sortKey{value: n.key(user.Email), id: user.ID}
+}
+
+func createdSortKey(user *User) sortKey {
+	return sortKey{created: user.CreatedAt, id: user.ID}
+}
+
+func compareByEmail(a, b sortKey) int {
+	if c := strings.Compare(a.value, b.value); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.id[:], b.id[:])
+}
+
+func compareByCreated(a, b sortKey) int {
+	if c := a.created.Compare(b.created); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.id[:], b.id[:])
+}
+
+// FindUsers returns one page of the users matching query, read from the
+// latest published version like GetUsers. The scan starts at the filter
+// prefix or the cursor, whichever comes later, in the index of the sort field.
+func (db *InMemoryDatabase) FindUsers(query Query) (Page, error) {
+	return findUsers(db.current.Load().ordered, db.normalization, query)
+}
+
+func findUsers(o orderedIndexes, n Normalization, query Query) (Page, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortByUsername
+	}
+
+	var after *cursor
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil || c.SortBy != query.SortBy {
+			return Page{}, InvalidCursorError
+		}
+		after = &c
+	}
+
+	filter := query.Filter
+	usernamePrefix := n.key(filter.UsernamePrefix)
+	emailPrefix := n.key(filter.EmailPrefix)
+
+	var page Page
+	// visit adds user to the page if it matches the filter and reports
+	// whether to go on scanning.
+	visit := func(user *User) bool {
+		if filter.Role != "" && !hasRole(user, filter.Role) ||
+			filter.WithoutRole != "" && hasRole(user, filter.WithoutRole) ||
+			!strings.HasPrefix(n.key(user.Username), usernamePrefix) ||
+			!strings.HasPrefix(n.key(user.Email), emailPrefix) ||
+			!filter.CreatedAfter.IsZero() && !user.CreatedAt.After(filter.CreatedAfter) ||
+			filter.EmailVerified != nil && user.EmailVerified != *filter.EmailVerified {
+			return true
+		}
+
+		if query.Limit > 0 && len(page.Users) == query.Limit {
+			page.NextCursor = encodeCursor(query.SortBy, &page.Users[len(page.Users)-1], n)
+			return false
+		}
+		page.Users = append(page.Users, withoutPassword(user))
+		return true
+	}
+
+	switch query.SortBy {
+	case SortByUsername:
+		from := usernamePrefix
+		if after != nil && after.Value >= from {
+			from = after.Value
+		}
+		o.usernames.AscendFrom(from, func(key string, user *User) bool {
+			if !strings.HasPrefix(key, usernamePrefix) {
+				return false
+			}
+			if after != nil && key == after.Value {
+				return true
+			}
+			return visit(user)
+		})
+	case SortByEmail:
+		from := sortKey{value: emailPrefix}
+		last := sortKey{}
+		if after != nil {
+			last = sortKey{value: after.Value, id: after.ID}
+			if compareByEmail(last, from) >= 0 {
+				from = last
+			}
+		}
+		o.emails.AscendFrom(from, func(key sortKey, user *User) bool {
+			if !strings.HasPrefix(key.value, emailPrefix) {
+				return false
+			}
+			if after != nil && key == last {
+				return true
+			}
+			return visit(user)
+		})
+	case SortByCreatedAt:
+		from := sortKey{created: filter.CreatedAfter}
+		last := sortKey{}
+		if after != nil {
+			last = sortKey{created: after.CreatedAt, id: after.ID}
+			if compareByCreated(last, from) >= 0 {
+				from = last
+			}
+		}
+		o.created.AscendFrom(from, func(key sortKey, user *User) bool {
+			if after != nil && compareByCreated(key, last) == 0 {
+				return true
+			}
+			return visit(user)
+		})
+	default:
+		return Page{}, InvalidSortFieldError
+	}
+
+	return page, nil
+}
+
+// cursor is the sort key of the last user of a page. Clients get it encoded
+// and must treat it as opaque.
+type cursor struct {
+	SortBy    SortField `json:"s"`
+	Value     string    `json:"v,omitempty"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	ID        uuid.UUID `json:"i"`
+}
+
+func encodeCursor(sortBy SortField, user *User, n Normalization) string {
+	c := cursor{SortBy: sortBy, ID: user.ID}
+	switch sortBy {
+	case SortByUsername:
+		c.Value = n.key(user.Username)
+	case SortByEmail:
+		c.Value = n.key(user.Email)
+	case SortByCreatedAt:
+		c.CreatedAt = user.CreatedAt
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return cursor{}, err
+	}
+
+	return c, nil
+}
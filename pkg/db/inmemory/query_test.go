@@ -0,0 +1,169 @@
+package inmemory_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUsersFilters(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "admin"}))
+	_, _ = db.InsertUser(inmemory.User{Username: "alice", Email: "alice@example.com", Roles: []string{"admin"}})
+	_, _ = db.InsertUser(inmemory.User{Username: "alex", Email: "alex@corp.com"})
+	_, _ = db.InsertUser(inmemory.User{Username: "bob", Email: "bob@corp.com", Roles: []string{"admin"}, EmailVerified: true})
+
+	page, err := db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{Role: "admin"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, usernames(page.Users))
+
+	page, err = db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{UsernamePrefix: "al"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alex", "alice"}, usernames(page.Users))
+
+	page, err = db.FindUsers(inmemory.Query{
+		Filter: inmemory.UserFilter{EmailPrefix: "b", Role: "admin"},
+		SortBy: inmemory.SortByEmail,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, usernames(page.Users))
+	assert.Empty(t, page.Users[0].Password)
+
+	all, err := db.FindUsers(inmemory.Query{SortBy: inmemory.SortByCreatedAt})
+	require.NoError(t, err)
+	require.Len(t, all.Users, 3)
+
+	page, err = db.FindUsers(inmemory.Query{
+		Filter: inmemory.UserFilter{CreatedAfter: all.Users[0].CreatedAt},
+		SortBy: inmemory.SortByCreatedAt,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, usernames(all.Users[1:]), usernames(page.Users))
+
+	verified := true
+	page, err = db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{EmailVerified: &verified}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, usernames(page.Users))
+	assert.True(t, page.Users[0].EmailVerified)
+	verified = false
+	page, err = db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{EmailVerified: &verified}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alex", "alice"}, usernames(page.Users))
+}
+
+func TestFindUsersPagination(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	for i := 0; i < 25; i++ {
+		user := inmemory.User{Username: fmt.Sprintf("user%02d", i)}
+		// Users without email tie in the email order.
+		if i%3 != 0 {
+			user.Email = fmt.Sprintf("user%02d@example.com", 24-i)
+		}
+		_, err := db.InsertUser(user)
+		require.NoError(t, err)
+	}
+
+	for _, sortBy := range []inmemory.SortField{
+		inmemory.SortByUsername,
+		inmemory.SortByEmail,
+		inmemory.SortByCreatedAt,
+	} {
+		t.Run(string(sortBy), func(t *testing.T) {
+			all, err := db.FindUsers(inmemory.Query{SortBy: sortBy})
+			require.NoError(t, err)
+			require.Len(t, all.Users, 25)
+			assert.Empty(t, all.NextCursor)
+
+			var paged []inmemory.User
+			query := inmemory.Query{SortBy: sortBy, Limit: 7}
+			for {
+				page, err := db.FindUsers(query)
+				require.NoError(t, err)
+				paged = append(paged, page.Users...)
+				if page.NextCursor == "" {
+					break
+				}
+				assert.Len(t, page.Users, 7)
+				query.Cursor = page.NextCursor
+			}
+
+			assert.Equal(t, usernames(all.Users), usernames(paged))
+		})
+	}
+}
+
+func TestFindUsersOrder(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	for _, username := range []string{"carol", "alice", "bob"} {
+		_, _ = db.InsertUser(inmemory.User{Username: username, Email: username + "@example.com"})
+		time.Sleep(time.Millisecond)
+	}
+
+	page, err := db.FindUsers(inmemory.Query{SortBy: inmemory.SortByCreatedAt})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"carol", "alice", "bob"}, usernames(page.Users))
+
+	page, err = db.FindUsers(inmemory.Query{SortBy: inmemory.SortByEmail})
+	require.NoError(t, err)
+	assert.True(t, sort.SliceIsSorted(page.Users, func(i, j int) bool {
+		return page.Users[i].Email < page.Users[j].Email
+	}))
+}
+
+func TestFindUsersInvalidQuery(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	for i := 0; i < 3; i++ {
+		_, _ = db.InsertUser(inmemory.User{Username: fmt.Sprintf("user%d", i)})
+	}
+
+	_, err := db.FindUsers(inmemory.Query{SortBy: "password"})
+	assert.Equal(t, inmemory.InvalidSortFieldError, err)
+
+	_, err = db.FindUsers(inmemory.Query{Cursor: "not a cursor"})
+	assert.Equal(t, inmemory.InvalidCursorError, err)
+
+	page, err := db.FindUsers(inmemory.Query{Limit: 1})
+	require.NoError(t, err)
+	_, err = db.FindUsers(inmemory.Query{SortBy: inmemory.SortByEmail, Cursor: page.NextCursor})
+	assert.Equal(t, inmemory.InvalidCursorError, err)
+}
+
+func TestTxnFindUsers(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "alice", Email: "alice@example.com"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: id, Username: "zed", Email: "zed@example.com"}))
+	_, err := tx.InsertUser(inmemory.User{Username: "bob", Email: "bob@example.com"})
+	require.NoError(t, err)
+
+	page, err := tx.FindUsers(inmemory.Query{SortBy: inmemory.SortByEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob", "zed"}, usernames(page.Users))
+
+	page, err = db.FindUsers(inmemory.Query{SortBy: inmemory.SortByEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, usernames(page.Users))
+
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.CheckIndexes())
+}
+
+func usernames(users []inmemory.User) []string {
+	res := make([]string, len(users))
+	for i, user := range users {
+		res[i] = user.Username
+	}
+
+	return res
+}
@@ -0,0 +1,114 @@
+package inmemory
+
+import (
+	"time"
+)
+
+// InsertRole stores a new role. Role names are unique.
+func (db *InMemoryDatabase) InsertRole(role Role) error {
+	if role.Name == "" {
+		return MissingRequiredFieldsError
+	}
+
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	if _, ok := db.roles.Get(role.Name); ok {
+		return AlreadyExistsError
+	}
+	role.CreatedAt = time.Now().UTC()
+
+	return db.commitRole(walRecord{Op: walOpPutRole, Role: &role})
+}
+
+func (db *InMemoryDatabase) GetRole(name string) (Role, error) {
+	role, ok := db.current.Load().roles.Get(name)
+	if !ok {
+		return Role{}, NotFoundError
+	}
+
+	return *role, nil
+}
+
+// GetRoles returns every role ordered by name.
+func (db *InMemoryDatabase) GetRoles() []Role {
+	v := db.current.Load()
+
+	roles := make([]Role, 0, v.roles.Len())
+	v.roles.Ascend(func(_ string, role *Role) bool {
+		roles = append(roles, *role)
+		return true
+	})
+
+	return roles
+}
+
+// UpdateRole replaces the description and permissions of an existing role.
+func (db *InMemoryDatabase) UpdateRole(role Role) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	existing, ok := db.roles.Get(role.Name)
+	if !ok {
+		return NotFoundError
+	}
+	role.CreatedAt = existing.CreatedAt
+
+	return db.commitRole(walRecord{Op: walOpPutRole, Role: &role})
+}
+
+// DeleteRole removes a role that no user has, otherwise it fails with
+// RoleInUseError. It scans all users.
+func (db *InMemoryDatabase) DeleteRole(name string) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	if _, ok := db.roles.Get(name); !ok {
+		return NotFoundError
+	}
+
+	inUse := false
+	db.ordered.usernames.Ascend(func(_ string, user *User) bool {
+		inUse = hasRole(user, name)
+		return !inUse
+	})
+	if inUse {
+		return RoleInUseError
+	}
+
+	return db.commitRole(walRecord{Op: walOpDeleteRole, Role: &Role{Name: name}})
+}
+
+// commitRole logs and applies a role record and publishes the result. The
+// caller must hold commitMu.
+func (db *InMemoryDatabase) commitRole(record walRecord) error {
+	if err := db.log(record); err != nil {
+		return err
+	}
+
+	db.applyRoleRecord(record)
+	db.publish()
+
+	return nil
+}
+
+func (db *InMemoryDatabase) applyRoleRecord(record walRecord) {
+	role := *record.Role
+
+	switch record.Op {
+	case walOpPutRole:
+		db.roles = db.roles.Set(role.Name, &role)
+	case walOpDeleteRole:
+		db.roles = db.roles.Delete(role.Name)
+	}
+}
+
+func hasRole(user *User, name string) bool {
+	for _, role := range user.Roles {
+		if role == name {
+			return true
+		}
+	}
+
+	return false
+}
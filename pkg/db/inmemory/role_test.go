@@ -0,0 +1,107 @@
+package inmemory_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "auditor", Permissions: []string{"users:read"}}))
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "admin", Permissions: []string{"users:read", "users:write"}}))
+	assert.Equal(t, inmemory.AlreadyExistsError, db.InsertRole(inmemory.Role{Name: "admin"}))
+	assert.Equal(t, inmemory.MissingRequiredFieldsError, db.InsertRole(inmemory.Role{}))
+
+	roles := db.GetRoles()
+	require.Len(t, roles, 2)
+	assert.Equal(t, "admin", roles[0].Name)
+	assert.Equal(t, "auditor", roles[1].Name)
+
+	_, err := db.InsertUser(inmemory.User{Username: "alice", Roles: []string{"missing"}})
+	assert.Equal(t, inmemory.UnknownRoleError, err)
+
+	id, err := db.InsertUser(inmemory.User{Username: "alice", Roles: []string{"auditor"}})
+	require.NoError(t, err)
+	_, err = db.InsertUser(inmemory.User{Username: "bob", Roles: []string{"admin", "auditor"}})
+	require.NoError(t, err)
+
+	page, err := db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{Role: "admin"}})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "bob", page.Users[0].Username)
+	page, err = db.FindUsers(inmemory.Query{Filter: inmemory.UserFilter{Role: "auditor", WithoutRole: "admin"}})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "alice", page.Users[0].Username)
+
+	require.NoError(t, db.UpdateRole(inmemory.Role{Name: "auditor", Permissions: []string{"users:read", "roles:read"}}))
+	role, err := db.GetRole("auditor")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users:read", "roles:read"}, role.Permissions)
+	assert.False(t, role.CreatedAt.IsZero())
+	assert.Equal(t, inmemory.NotFoundError, db.UpdateRole(inmemory.Role{Name: "missing"}))
+
+	assert.Equal(t, inmemory.RoleInUseError, db.DeleteRole("auditor"))
+
+	// A transaction cannot assign a role that does not exist either.
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: id, Username: "alice", Roles: []string{"missing"}}))
+	assert.Equal(t, inmemory.UnknownRoleError, tx.Commit())
+
+	require.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "alice"}))
+	assert.Equal(t, inmemory.RoleInUseError, db.DeleteRole("auditor"))
+	bob, _ := db.GetUserByUsername("bob")
+	require.NoError(t, db.DeleteUser(bob.ID, 0))
+	require.NoError(t, db.DeleteRole("auditor"))
+	assert.Equal(t, inmemory.NotFoundError, db.DeleteRole("auditor"))
+	_, err = db.GetRole("auditor")
+	assert.Equal(t, inmemory.NotFoundError, err)
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestRolesRecovery(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir: filepath.Join(dir, "snapshots"),
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "snapshotted", Permissions: []string{"users:read"}}))
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "deleted"}))
+	id, err := db.InsertUser(inmemory.User{Username: "alice", Roles: []string{"snapshotted"}})
+	require.NoError(t, err)
+	require.NoError(t, db.Snapshot())
+
+	require.NoError(t, db.InsertRole(inmemory.Role{Name: "logged", Permissions: []string{"users:write"}}))
+	require.NoError(t, db.UpdateRole(inmemory.Role{Name: "snapshotted", Permissions: []string{"users:delete"}}))
+	require.NoError(t, db.DeleteRole("deleted"))
+	require.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "alice", Roles: []string{"snapshotted", "logged"}}))
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	roles := db.GetRoles()
+	require.Len(t, roles, 2)
+	assert.Equal(t, "logged", roles[0].Name)
+	assert.Equal(t, "snapshotted", roles[1].Name)
+	assert.Equal(t, []string{"users:delete"}, roles[1].Permissions)
+
+	user, err := db.GetUserById(id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snapshotted", "logged"}, user.Roles)
+	require.NoError(t, db.CheckIndexes())
+}
@@ -0,0 +1,443 @@
+package inmemory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+	snapshotMagic  = "USRSNAP1"
+
+	defaultSnapshotRetain = 2
+)
+
+type SnapshotOptions struct {
+	// Dir is where snapshot files are written. Snapshots are disabled when empty.
+	Dir string
+	// Interval takes a snapshot periodically when positive.
+	Interval time.Duration
+	// Threshold takes a snapshot once the log holds that many records.
+	Threshold int
+	// Retain is the number of snapshot files kept on disk. The log is only
+	// truncated up to the oldest retained snapshot so that any of them can be
+	// used for recovery.
+	Retain int
+}
+
+// snapshot is a copy of every user, API key, role and second factor as of the
+// log record LSN.
+//
+// On disk it is stored as
+// | magic (8 bytes) | lsn (8 bytes) | users | keys | roles | totps | crc32c (4 bytes) |
+// where every section is an 8 byte count followed by as many records, every
+// record is a 4 byte length followed by its JSON encoding and the trailing
+// checksum covers everything before it.
+type snapshot struct {
+	LSN   uint64
+	Users []User
+	Keys  []APIKey
+	Roles []Role
+	TOTPs []TOTP
+}
+
+type snapshotter struct {
+	opts    SnapshotOptions
+	mu      sync.Mutex
+	running atomic.Bool
+	wg      sync.WaitGroup
+	stop    chan struct{}
+	// closeMu guards closed, which is set once the database is closing.
+	// No snapshot is started from then on.
+	closeMu sync.Mutex
+	closed  bool
+}
+
+func newSnapshotter(opts SnapshotOptions) (*snapshotter, error) {
+	if opts.Retain <= 0 {
+		opts.Retain = defaultSnapshotRetain
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create snapshot directory: %w", err)
+	}
+
+	partial, err := filepath.Glob(filepath.Join(opts.Dir, snapshotPrefix+"*.tmp"))
+	if err != nil {
+		return nil, fmt.Errorf("list partial snapshots: %w", err)
+	}
+	for _, name := range partial {
+		_ = os.Remove(name)
+	}
+
+	return &snapshotter{
+		opts: opts,
+		stop: make(chan struct{}),
+	}, nil
+}
+
+// Snapshot writes the latest published version of the database to a new
+// snapshot file and drops the log records covered by the retained snapshots.
+// Neither reads nor writes are held back while the snapshot is taken.
+func (db *InMemoryDatabase) Snapshot() error {
+	if db.snapshots == nil {
+		return SnapshotsDisabledError
+	}
+
+	db.snapshots.mu.Lock()
+	defer db.snapshots.mu.Unlock()
+
+	if db.snapshots.isClosed() {
+		return DatabaseClosedError
+	}
+
+	v := db.current.Load()
+	snap := snapshot{
+		LSN:   v.lsn,
+		Users: make([]User, 0, v.ordered.usernames.Len()),
+	}
+	v.ordered.usernames.Ascend(func(_ string, user *User) bool {
+		snap.Users = append(snap.Users, *user)
+		return true
+	})
+	v.keys.Ascend(func(_ uuid.UUID, key *APIKey) bool {
+		snap.Keys = append(snap.Keys, *key)
+		return true
+	})
+	v.roles.Ascend(func(_ string, role *Role) bool {
+		snap.Roles = append(snap.Roles, *role)
+		return true
+	})
+	v.totps.Ascend(func(_ uuid.UUID, totp *TOTP) bool {
+		snap.TOTPs = append(snap.TOTPs, *totp)
+		return true
+	})
+
+	if err := writeSnapshot(db.snapshots.opts.Dir, snap); err != nil {
+		return err
+	}
+
+	oldest, err := removeOldSnapshots(db.snapshots.opts.Dir, db.snapshots.opts.Retain)
+	if err != nil {
+		return err
+	}
+
+	return db.wal.compact(oldest)
+}
+
+func (db *InMemoryDatabase) startSnapshots() {
+	if db.snapshots.opts.Interval <= 0 {
+		return
+	}
+
+	db.snapshots.wg.Add(1)
+	go func() {
+		defer db.snapshots.wg.Done()
+
+		ticker := time.NewTicker(db.snapshots.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_ = db.Snapshot()
+			case <-db.snapshots.stop:
+				return
+			}
+		}
+	}()
+}
+
+// maybeSnapshot starts a background snapshot once the log has grown past the
+// configured threshold. It never blocks the caller.
+func (db *InMemoryDatabase) maybeSnapshot() {
+	if db.snapshots == nil || db.snapshots.opts.Threshold <= 0 ||
+		db.wal.size() < db.snapshots.opts.Threshold {
+		return
+	}
+
+	// Close waits for the snapshots started before it, so none may be
+	// started once it does.
+	db.snapshots.closeMu.Lock()
+	defer db.snapshots.closeMu.Unlock()
+	if db.snapshots.closed {
+		return
+	}
+
+	if !db.snapshots.running.CompareAndSwap(false, true) {
+		return
+	}
+
+	db.snapshots.wg.Add(1)
+	go func() {
+		defer db.snapshots.wg.Done()
+		defer db.snapshots.running.Store(false)
+
+		_ = db.Snapshot()
+	}()
+}
+
+// stopSnapshots keeps new snapshots from being taken and waits for those
+// being taken, in the background or by callers of Snapshot.
+func (db *InMemoryDatabase) stopSnapshots() {
+	if db.snapshots == nil {
+		return
+	}
+
+	db.snapshots.closeMu.Lock()
+	db.snapshots.closed = true
+	db.snapshots.closeMu.Unlock()
+
+	close(db.snapshots.stop)
+	db.snapshots.wg.Wait()
+
+	db.snapshots.mu.Lock()
+	db.snapshots.mu.Unlock()
+}
+
+func (s *snapshotter) isClosed() bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+
+	return s.closed
+}
+
+func writeSnapshot(dir string, snap snapshot) error {
+	file, err := os.CreateTemp(dir, snapshotPrefix+"*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+
+	err = encodeSnapshot(file, snap)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, snapshotFileName(snap.LSN)))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+func encodeSnapshot(w io.Writer, snap snapshot) error {
+	crc := crc32.New(crcTable)
+	buf := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	header := make([]byte, len(snapshotMagic)+8)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint64(header[8:16], snap.LSN)
+	if _, err := buf.Write(header); err != nil {
+		return err
+	}
+
+	if err := encodeSnapshotRecords(buf, snap.Users); err != nil {
+		return err
+	}
+	if err := encodeSnapshotRecords(buf, snap.Keys); err != nil {
+		return err
+	}
+	if err := encodeSnapshotRecords(buf, snap.Roles); err != nil {
+		return err
+	}
+	if err := encodeSnapshotRecords(buf, snap.TOTPs); err != nil {
+		return err
+	}
+
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+func encodeSnapshotRecords[T any](buf *bufio.Writer, records []T) error {
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, uint64(len(records)))
+	if _, err := buf.Write(count); err != nil {
+		return err
+	}
+
+	length := make([]byte, 4)
+	for _, record := range records {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		binary.BigEndian.PutUint32(length, uint32(len(payload)))
+		if _, err = buf.Write(length); err != nil {
+			return err
+		}
+		if _, err = buf.Write(payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeSnapshot(data []byte) (snapshot, error) {
+	headerSize := len(snapshotMagic) + 8
+	if len(data) < headerSize+4 {
+		return snapshot{}, CorruptSnapshotError
+	}
+
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(trailer) {
+		return snapshot{}, CorruptSnapshotError
+	}
+
+	if string(body[:len(snapshotMagic)]) != snapshotMagic {
+		return snapshot{}, CorruptSnapshotError
+	}
+
+	snap := snapshot{LSN: binary.BigEndian.Uint64(body[8:16])}
+	rest := body[headerSize:]
+
+	var err error
+	if snap.Users, rest, err = decodeSnapshotRecords[User](rest); err != nil {
+		return snapshot{}, err
+	}
+	if snap.Keys, rest, err = decodeSnapshotRecords[APIKey](rest); err != nil {
+		return snapshot{}, err
+	}
+	if snap.Roles, rest, err = decodeSnapshotRecords[Role](rest); err != nil {
+		return snapshot{}, err
+	}
+	if snap.TOTPs, rest, err = decodeSnapshotRecords[TOTP](rest); err != nil {
+		return snapshot{}, err
+	}
+	if len(rest) != 0 {
+		return snapshot{}, CorruptSnapshotError
+	}
+
+	return snap, nil
+}
+
+func decodeSnapshotRecords[T any](data []byte) ([]T, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, CorruptSnapshotError
+	}
+	count := binary.BigEndian.Uint64(data[:8])
+	data = data[8:]
+
+	var records []T
+	for uint64(len(records)) < count {
+		if len(data) < 4 {
+			return nil, nil, CorruptSnapshotError
+		}
+		length := int(binary.BigEndian.Uint32(data[:4]))
+		if len(data)-4 < length {
+			return nil, nil, CorruptSnapshotError
+		}
+
+		var record T
+		if err := json.Unmarshal(data[4:4+length], &record); err != nil {
+			return nil, nil, CorruptSnapshotError
+		}
+		records = append(records, record)
+		data = data[4+length:]
+	}
+
+	return records, data, nil
+}
+
+// loadLatestSnapshot returns the newest snapshot in dir that decodes cleanly.
+// Corrupt or partially written files are skipped in favour of older ones.
+func loadLatestSnapshot(dir string) (*snapshot, error) {
+	names, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(filepath.Join(dir, names[i]))
+		if err != nil {
+			continue
+		}
+
+		snap, err := decodeSnapshot(data)
+		if err != nil {
+			continue
+		}
+
+		return &snap, nil
+	}
+
+	return nil, nil
+}
+
+// removeOldSnapshots keeps the newest retain snapshots in dir and returns the
+// LSN of the oldest one kept.
+func removeOldSnapshots(dir string, retain int) (uint64, error) {
+	names, err := listSnapshots(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for len(names) > retain {
+		if err = os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return 0, fmt.Errorf("remove snapshot: %w", err)
+		}
+		names = names[1:]
+	}
+
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	return snapshotLSN(names[0]), nil
+}
+
+// listSnapshots returns the snapshot file names in dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read snapshot directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func snapshotFileName(lsn uint64) string {
+	return fmt.Sprintf("%s%020d%s", snapshotPrefix, lsn, snapshotSuffix)
+}
+
+func snapshotLSN(name string) uint64 {
+	lsn, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix), 10, 64)
+	return lsn
+}
@@ -0,0 +1,153 @@
+package inmemory_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRecovery(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir:    filepath.Join(dir, "snapshots"),
+			Retain: 1,
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err = db.InsertUser(inmemory.User{Username: fmt.Sprintf("user%d", i)})
+		require.NoError(t, err)
+	}
+
+	before, err := os.Stat(opts.WAL.Path)
+	require.NoError(t, err)
+	require.NoError(t, db.Snapshot())
+	after, err := os.Stat(opts.WAL.Path)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	user, err := db.GetUserByUsername("user0")
+	require.NoError(t, err)
+	user.Username = "renamed"
+	require.NoError(t, db.UpdateUser(user))
+	_, err = db.InsertUser(inmemory.User{Username: "tail"})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Len(t, db.GetUsers(), 11)
+	_, err = db.GetUserByUsername("renamed")
+	assert.NoError(t, err)
+	_, err = db.GetUserByUsername("user0")
+	assert.Equal(t, inmemory.NotFoundError, err)
+	_, err = db.GetUserByUsername("tail")
+	assert.NoError(t, err)
+}
+
+func TestSnapshotSkipsCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir:    filepath.Join(dir, "snapshots"),
+			Retain: 2,
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "first"})
+	require.NoError(t, err)
+	require.NoError(t, db.Snapshot())
+
+	_, err = db.InsertUser(inmemory.User{Username: "second"})
+	require.NoError(t, err)
+	require.NoError(t, db.Snapshot())
+	require.NoError(t, db.Close())
+
+	names, err := filepath.Glob(filepath.Join(opts.Snapshot.Dir, "snapshot-*.snap"))
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+
+	newest := names[len(names)-1]
+	data, err := os.ReadFile(newest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(newest, data[:len(data)/2], 0o600))
+
+	err = os.WriteFile(filepath.Join(opts.Snapshot.Dir, "snapshot-99999999999999999999.snap"), []byte("garbage"), 0o600)
+	require.NoError(t, err)
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Len(t, db.GetUsers(), 2)
+	_, err = db.GetUserByUsername("first")
+	assert.NoError(t, err)
+	_, err = db.GetUserByUsername("second")
+	assert.NoError(t, err)
+}
+
+// TestSnapshotClose closes the database while writes keep starting
+// background snapshots. None may outlive Close, and none may be taken after
+// it.
+func TestSnapshotClose(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir:       filepath.Join(dir, "snapshots"),
+			Threshold: 1,
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				// Writes fail once the database is closed.
+				_, _ = db.InsertUser(inmemory.User{Username: fmt.Sprintf("user%d-%d", i, j)})
+			}
+		}(i)
+	}
+	require.NoError(t, db.Close())
+	wg.Wait()
+
+	assert.Equal(t, inmemory.DatabaseClosedError, db.Snapshot())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestSnapshotDisabled(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+	assert.Equal(t, inmemory.SnapshotsDisabledError, db.Snapshot())
+}
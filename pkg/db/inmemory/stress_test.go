@@ -0,0 +1,284 @@
+package inmemory_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stressShards lists the shard counts every stress test runs with: a single
+// lock, and enough stripes for concurrent writers to land on different ones.
+var stressShards = []int{1, 8}
+
+const (
+	stressWorkers    = 32
+	stressIterations = 200
+	stressRounds     = 20
+)
+
+func TestStressConcurrentInsertSameUsername(t *testing.T) {
+	runWithShards(t, testStressConcurrentInsertSameUsername)
+}
+
+func testStressConcurrentInsertSameUsername(t *testing.T, shards int) {
+	for round := 0; round < stressRounds; round++ {
+		db := newShardedDatabase(shards)
+
+		var created atomic.Int32
+		runConcurrently(stressWorkers, func(worker int) {
+			_, err := db.InsertUser(inmemory.User{
+				Username: "duplicate",
+				Email:    fmt.Sprintf("user%d@example.com", worker),
+			})
+			if err == nil {
+				created.Add(1)
+				return
+			}
+			assert.Equal(t, inmemory.AlreadyExistsError, err)
+		})
+
+		assert.Equal(t, int32(1), created.Load())
+		assert.Len(t, db.GetUsers(), 1)
+		require.NoError(t, db.CheckIndexes())
+	}
+}
+
+func TestStressConcurrentInsertSameEmail(t *testing.T) {
+	runWithShards(t, testStressConcurrentInsertSameEmail)
+}
+
+func testStressConcurrentInsertSameEmail(t *testing.T, shards int) {
+	for round := 0; round < stressRounds; round++ {
+		db := newShardedDatabase(shards)
+
+		var created atomic.Int32
+		runConcurrently(stressWorkers, func(worker int) {
+			_, err := db.InsertUser(inmemory.User{
+				Username: fmt.Sprintf("user%d", worker),
+				Email:    "duplicate@example.com",
+			})
+			if err == nil {
+				created.Add(1)
+				return
+			}
+			assert.Equal(t, inmemory.EmailAlreadyExistsError, err)
+		})
+
+		assert.Equal(t, int32(1), created.Load())
+		require.NoError(t, db.CheckIndexes())
+	}
+}
+
+func TestStressConcurrentRenameToSameUsername(t *testing.T) {
+	runWithShards(t, testStressConcurrentRenameToSameUsername)
+}
+
+func testStressConcurrentRenameToSameUsername(t *testing.T, shards int) {
+	for round := 0; round < stressRounds; round++ {
+		db := newShardedDatabase(shards)
+
+		ids := make([]uuid.UUID, stressWorkers)
+		for i := range ids {
+			id, err := db.InsertUser(inmemory.User{Username: fmt.Sprintf("user%d", i)})
+			require.NoError(t, err)
+			ids[i] = id
+		}
+
+		var renamed atomic.Int32
+		runConcurrently(stressWorkers, func(worker int) {
+			err := db.UpdateUser(inmemory.User{ID: ids[worker], Username: "target"})
+			if err == nil {
+				renamed.Add(1)
+				return
+			}
+			assert.Equal(t, inmemory.AlreadyExistsError, err)
+		})
+
+		assert.Equal(t, int32(1), renamed.Load())
+		assert.Len(t, db.GetUsers(), stressWorkers)
+		require.NoError(t, db.CheckIndexes())
+	}
+}
+
+func TestStressConcurrentDeleteAndUpdate(t *testing.T) {
+	runWithShards(t, testStressConcurrentDeleteAndUpdate)
+}
+
+func testStressConcurrentDeleteAndUpdate(t *testing.T, shards int) {
+	for round := 0; round < stressRounds; round++ {
+		db := newShardedDatabase(shards)
+
+		id, err := db.InsertUser(inmemory.User{Username: "user", Email: "user@example.com"})
+		require.NoError(t, err)
+
+		runConcurrently(stressWorkers, func(worker int) {
+			if worker%2 == 0 {
+				assert.NoError(t, db.DeleteUser(id, 0))
+				return
+			}
+			err := db.UpdateUser(inmemory.User{
+				ID:       id,
+				Username: fmt.Sprintf("renamed%d", worker),
+				Email:    fmt.Sprintf("renamed%d@example.com", worker),
+			})
+			if err != nil {
+				assert.Equal(t, inmemory.NotFoundError, err)
+			}
+		})
+
+		_, err = db.GetUserById(id)
+		assert.Equal(t, inmemory.NotFoundError, err)
+		assert.Empty(t, db.GetUsers())
+		require.NoError(t, db.CheckIndexes())
+	}
+}
+
+func TestStressMixedOperations(t *testing.T) {
+	runWithShards(t, testStressMixedOperations)
+}
+
+func testStressMixedOperations(t *testing.T, shards int) {
+	const keySpace = 16
+
+	db := newShardedDatabase(shards)
+
+	runConcurrently(stressWorkers, func(worker int) {
+		rnd := rand.New(rand.NewSource(int64(worker)))
+
+		for i := 0; i < stressIterations; i++ {
+			username := fmt.Sprintf("user%d", rnd.Intn(keySpace))
+			email := fmt.Sprintf("user%d@example.com", rnd.Intn(keySpace))
+
+			switch rnd.Intn(4) {
+			case 0:
+				_, _ = db.InsertUser(inmemory.User{Username: username, Email: email})
+			case 1:
+				user, err := db.GetUserByUsername(username)
+				if err == nil {
+					user.Username = fmt.Sprintf("user%d", rnd.Intn(keySpace))
+					user.Email = email
+					_ = db.UpdateUser(user)
+				}
+			case 2:
+				user, err := db.GetUserByEmail(email)
+				if err == nil {
+					assert.NoError(t, db.DeleteUser(user.ID, 0))
+				}
+			default:
+				seen := make(map[string]bool)
+				for _, user := range db.GetUsers() {
+					assert.False(t, seen[user.Username], "duplicate username %q", user.Username)
+					seen[user.Username] = true
+				}
+			}
+		}
+	})
+
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestStressGetUsersSeesConsistentVersions(t *testing.T) {
+	runWithShards(t, testStressGetUsersSeesConsistentVersions)
+}
+
+func testStressGetUsersSeesConsistentVersions(t *testing.T, shards int) {
+	const population = 64
+
+	db := newShardedDatabase(shards)
+
+	ids := make([]uuid.UUID, population)
+	for i := range ids {
+		id, err := db.InsertUser(inmemory.User{Username: fmt.Sprintf("user%d", i)})
+		require.NoError(t, err)
+		ids[i] = id
+	}
+
+	runConcurrently(stressWorkers, func(worker int) {
+		rnd := rand.New(rand.NewSource(int64(worker)))
+
+		for i := 0; i < stressIterations; i++ {
+			if worker%2 == 0 {
+				users := db.GetUsers()
+				assert.Len(t, users, population)
+				for j := 1; j < len(users); j++ {
+					assert.Less(t, users[j-1].Username, users[j].Username)
+				}
+				continue
+			}
+
+			first, second := ids[rnd.Intn(population)], ids[rnd.Intn(population)]
+			tx := db.Begin()
+			a, errA := tx.GetUserById(first)
+			b, errB := tx.GetUserById(second)
+			if errA != nil || errB != nil || first == second {
+				tx.Rollback()
+				continue
+			}
+
+			// Other swaps may commit between the reads and the writes of
+			// this one, so any write may conflict and abandon the swap.
+			// Versions are checked too, so b is read again after its
+			// first update.
+			usernameA, usernameB := a.Username, b.Username
+			b.Username = usernameB + "-swap"
+			err := tx.UpdateUser(b)
+			if err == nil {
+				a.Username = usernameB
+				err = tx.UpdateUser(a)
+			}
+			if err == nil {
+				b, err = tx.GetUserById(second)
+			}
+			if err == nil {
+				b.Username = usernameA
+				err = tx.UpdateUser(b)
+			}
+			if err != nil {
+				assert.Contains(t, []error{inmemory.AlreadyExistsError, inmemory.VersionMismatchError}, err)
+				tx.Rollback()
+				continue
+			}
+			_ = tx.Commit()
+		}
+	})
+
+	assert.Len(t, db.GetUsers(), population)
+	require.NoError(t, db.CheckIndexes())
+}
+
+func runConcurrently(workers int, fn func(worker int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			<-start
+			fn(worker)
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+}
+
+func runWithShards(t *testing.T, test func(t *testing.T, shards int)) {
+	for _, shards := range stressShards {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			test(t, shards)
+		})
+	}
+}
+
+func newShardedDatabase(shards int) *inmemory.InMemoryDatabase {
+	db, _ := inmemory.OpenInMemoryDatabase(inmemory.Options{Shards: shards})
+	return db
+}
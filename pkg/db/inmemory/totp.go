@@ -0,0 +1,83 @@
+package inmemory
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetTOTP reads the latest published version like GetUsers.
+func (db *InMemoryDatabase) GetTOTP(userID uuid.UUID) (TOTP, error) {
+	totp, ok := db.current.Load().totps.Get(userID)
+	if !ok {
+		return TOTP{}, NotFoundError
+	}
+
+	return *totp, nil
+}
+
+// UpdateTOTP applies update to the second factor of an existing user, or to
+// a new one if the user has none, and stores the result. The check of a code
+// and the record that it was used happen atomically, so concurrent sign-ins
+// cannot use one code twice. An error of update is returned unchanged and
+// nothing is stored.
+func (db *InMemoryDatabase) UpdateTOTP(userID uuid.UUID, update func(totp *TOTP) error) error {
+	// Holding the user's shards keeps a concurrent DeleteUser from dropping
+	// the user's second factor before this one is stored.
+	_, unlock, err := db.lockUser(userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	totp := TOTP{UserID: userID, CreatedAt: time.Now().UTC()}
+	if existing, ok := db.totps.Get(userID); ok {
+		totp = *existing
+		totp.RecoveryCodes = append([][]byte(nil), existing.RecoveryCodes...)
+	}
+
+	if err = update(&totp); err != nil {
+		return err
+	}
+	totp.UserID = userID
+
+	return db.commitTOTP(walRecord{Op: walOpPutTOTP, TOTP: &totp})
+}
+
+func (db *InMemoryDatabase) DeleteTOTP(userID uuid.UUID) error {
+	db.commitMu.Lock()
+	defer db.commitMu.Unlock()
+
+	if _, ok := db.totps.Get(userID); !ok {
+		return NotFoundError
+	}
+
+	return db.commitTOTP(walRecord{Op: walOpDeleteTOTP, TOTP: &TOTP{UserID: userID}})
+}
+
+// commitTOTP logs and applies a second factor record and publishes the
+// result. The caller must hold commitMu.
+func (db *InMemoryDatabase) commitTOTP(record walRecord) error {
+	if err := db.log(record); err != nil {
+		return err
+	}
+
+	db.applyTOTPRecord(record)
+	db.publish()
+
+	return nil
+}
+
+func (db *InMemoryDatabase) applyTOTPRecord(record walRecord) {
+	totp := *record.TOTP
+
+	switch record.Op {
+	case walOpPutTOTP:
+		db.totps = db.totps.Set(totp.UserID, &totp)
+	case walOpDeleteTOTP:
+		db.totps = db.totps.Delete(totp.UserID)
+	}
+}
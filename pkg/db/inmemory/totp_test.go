@@ -0,0 +1,130 @@
+package inmemory_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	userID, _ := db.InsertUser(inmemory.User{Username: "alice"})
+	otherID, _ := db.InsertUser(inmemory.User{Username: "bob"})
+
+	err := db.UpdateTOTP(uuid.New(), func(*inmemory.TOTP) error { return nil })
+	assert.Equal(t, inmemory.NotFoundError, err)
+	_, err = db.GetTOTP(userID)
+	assert.Equal(t, inmemory.NotFoundError, err)
+
+	require.NoError(t, db.UpdateTOTP(userID, func(totp *inmemory.TOTP) error {
+		assert.Equal(t, userID, totp.UserID)
+		assert.Nil(t, totp.Secret)
+		totp.Secret = []byte("secret")
+		totp.RecoveryCodes = [][]byte{[]byte("a"), []byte("b")}
+		return nil
+	}))
+	require.NoError(t, db.UpdateTOTP(otherID, func(totp *inmemory.TOTP) error {
+		totp.Secret = []byte("other")
+		return nil
+	}))
+
+	// A failing update stores nothing and leaves what was read untouched.
+	failed := errors.New("failed")
+	err = db.UpdateTOTP(userID, func(totp *inmemory.TOTP) error {
+		totp.Confirmed = true
+		totp.RecoveryCodes[0] = []byte("changed")
+		totp.RecoveryCodes = totp.RecoveryCodes[1:]
+		return failed
+	})
+	assert.Equal(t, failed, err)
+	totp, err := db.GetTOTP(userID)
+	require.NoError(t, err)
+	assert.False(t, totp.Confirmed)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, totp.RecoveryCodes)
+
+	require.NoError(t, db.UpdateTOTP(userID, func(totp *inmemory.TOTP) error {
+		totp.Confirmed = true
+		totp.LastStep = 42
+		return nil
+	}))
+	totp, err = db.GetTOTP(userID)
+	require.NoError(t, err)
+	assert.True(t, totp.Confirmed)
+	assert.Equal(t, uint64(42), totp.LastStep)
+	assert.Equal(t, []byte("secret"), totp.Secret)
+
+	require.NoError(t, db.DeleteTOTP(otherID))
+	assert.Equal(t, inmemory.NotFoundError, db.DeleteTOTP(otherID))
+
+	// Deleting the user drops its second factor.
+	require.NoError(t, db.DeleteUser(userID, 0))
+	_, err = db.GetTOTP(userID)
+	assert.Equal(t, inmemory.NotFoundError, err)
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestTOTPRecovery(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir: filepath.Join(dir, "snapshots"),
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	snapshottedID, _ := db.InsertUser(inmemory.User{Username: "snapshotted"})
+	loggedID, _ := db.InsertUser(inmemory.User{Username: "logged"})
+	deletedID, _ := db.InsertUser(inmemory.User{Username: "deleted"})
+	for _, id := range []uuid.UUID{snapshottedID, deletedID} {
+		require.NoError(t, db.UpdateTOTP(id, func(totp *inmemory.TOTP) error {
+			totp.Secret = []byte("snapshotted")
+			return nil
+		}))
+	}
+	require.NoError(t, db.Snapshot())
+
+	require.NoError(t, db.UpdateTOTP(snapshottedID, func(totp *inmemory.TOTP) error {
+		totp.Confirmed = true
+		return nil
+	}))
+	require.NoError(t, db.UpdateTOTP(loggedID, func(totp *inmemory.TOTP) error {
+		totp.Secret = []byte("logged")
+		return nil
+	}))
+	require.NoError(t, db.DeleteUser(deletedID, 0))
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	totp, err := db.GetTOTP(snapshottedID)
+	require.NoError(t, err)
+	assert.True(t, totp.Confirmed)
+	assert.Equal(t, []byte("snapshotted"), totp.Secret)
+	totp, err = db.GetTOTP(loggedID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("logged"), totp.Secret)
+	_, err = db.GetTOTP(deletedID)
+	assert.Equal(t, inmemory.NotFoundError, err)
+	require.NoError(t, db.CheckIndexes())
+
+	// A snapshot written now holds the second factors too.
+	require.NoError(t, db.Snapshot())
+	require.NoError(t, db.Close())
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	_, err = db.GetTOTP(loggedID)
+	assert.NoError(t, err)
+	require.NoError(t, db.Close())
+}
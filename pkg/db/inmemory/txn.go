@@ -0,0 +1,306 @@
+package inmemory
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Txn groups several writes that are committed all-or-nothing. Reads through a
+// transaction see its own uncommitted writes on top of the committed data;
+// other readers see none of them until Commit. Uniqueness is checked as every
+// write is made and checked again against the latest data on Commit.
+//
+// A Txn must not be used from several goroutines at once.
+type Txn struct {
+	db   *InMemoryDatabase
+	ops  []walRecord
+	view *txnView
+	done bool
+}
+
+func (db *InMemoryDatabase) Begin() *Txn {
+	return &Txn{
+		db:   db,
+		view: newTxnView(db),
+	}
+}
+
+func (tx *Txn) GetUserById(id uuid.UUID) (User, error) {
+	unlock := tx.db.rlockAll()
+	defer unlock()
+
+	user, ok := tx.view.byID(id)
+	if !ok {
+		return User{}, NotFoundError
+	}
+
+	return withoutPassword(user), nil
+}
+
+func (tx *Txn) GetUserByUsername(username string) (User, error) {
+	unlock := tx.db.rlockAll()
+	defer unlock()
+
+	user, ok := tx.view.byUsername(username)
+	if !ok {
+		return User{}, NotFoundError
+	}
+
+	return *user, nil
+}
+
+func (tx *Txn) GetUserByEmail(email string) (User, error) {
+	unlock := tx.db.rlockAll()
+	defer unlock()
+
+	user, ok := tx.view.byEmail(email)
+	if !ok {
+		return User{}, NotFoundError
+	}
+
+	return *user, nil
+}
+
+// GetUsers merges the writes of the transaction into the latest published
+// version, ordered by username like InMemoryDatabase.GetUsers.
+func (tx *Txn) GetUsers() []User {
+	ordered := tx.ordered()
+
+	users := make([]User, 0, ordered.usernames.Len())
+	ordered.usernames.Ascend(func(_ string, user *User) bool {
+		users = append(users, withoutPassword(user))
+		return true
+	})
+
+	return users
+}
+
+func (tx *Txn) FindUsers(query Query) (Page, error) {
+	return findUsers(tx.ordered(), tx.db.normalization, query)
+}
+
+// ordered returns the ordered indexes of the latest published version with
+// the writes of the transaction applied.
+func (tx *Txn) ordered() orderedIndexes {
+	db := tx.db
+	unlock := db.rlockAll()
+	defer unlock()
+
+	// No write can be half-applied while all shards are read-locked, so the
+	// published version matches the maps here.
+	// Like commit, every replaced user is removed before any user is added, so
+	// a key moved between users in the transaction is not removed again.
+	ordered := db.current.Load().ordered
+	for id := range tx.view.ids {
+		if existing, ok := db.byID(id); ok {
+			ordered = ordered.without(existing, db.normalization)
+		}
+	}
+	for _, user := range tx.view.ids {
+		if user != nil {
+			ordered = ordered.with(user, db.normalization)
+		}
+	}
+
+	return ordered
+}
+
+func (tx *Txn) InsertUser(user User) (uuid.UUID, error) {
+	user.ID = uuid.New()
+	user.CreatedAt = time.Now().UTC()
+	if err := tx.write(walRecord{Op: walOpInsert, User: user}); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return user.ID, nil
+}
+
+func (tx *Txn) UpdateUser(user User) error {
+	return tx.write(walRecord{Op: walOpUpdate, User: user})
+}
+
+func (tx *Txn) DeleteUser(id uuid.UUID, version uint64) error {
+	return tx.write(walRecord{Op: walOpDelete, User: User{ID: id, Version: version}})
+}
+
+// Commit validates the transaction against the latest committed data and
+// applies all of its writes at once. On error nothing is applied.
+func (tx *Txn) Commit() error {
+	if tx.done {
+		return TransactionDoneError
+	}
+	tx.done = true
+
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	db := tx.db
+	unlock := db.lockAll()
+	defer unlock()
+
+	// The ops hold the versions the transaction expects; the log gets the
+	// versions they produce.
+	view := newTxnView(db)
+	applied := make([]walRecord, len(tx.ops))
+	for i, op := range tx.ops {
+		record, err := view.apply(op)
+		if err != nil {
+			return err
+		}
+		applied[i] = record
+	}
+
+	var removed, added []*User
+	for id, user := range view.ids {
+		if existing, ok := db.byID(id); ok {
+			removed = append(removed, existing)
+		}
+		if user != nil {
+			added = append(added, user)
+		}
+	}
+
+	return db.commit(walRecord{Op: walOpTxn, Batch: applied}, removed, added)
+}
+
+func (tx *Txn) Rollback() {
+	tx.done = true
+	tx.ops = nil
+}
+
+func (tx *Txn) write(op walRecord) error {
+	if tx.done {
+		return TransactionDoneError
+	}
+
+	unlock := tx.db.rlockAll()
+	defer unlock()
+
+	if _, err := tx.view.apply(op); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, op)
+
+	return nil
+}
+
+// txnView overlays the writes of a transaction on top of the database
+// indexes. A nil entry marks a user, username or email removed by the
+// transaction. Callers must hold the locks of all shards while using it.
+type txnView struct {
+	db        *InMemoryDatabase
+	ids       map[uuid.UUID]*User
+	usernames map[string]*User
+	emails    map[string]*User
+}
+
+func newTxnView(db *InMemoryDatabase) *txnView {
+	return &txnView{
+		db:        db,
+		ids:       make(map[uuid.UUID]*User),
+		usernames: make(map[string]*User),
+		emails:    make(map[string]*User),
+	}
+}
+
+func (v *txnView) byID(id uuid.UUID) (*User, bool) {
+	if user, ok := v.ids[id]; ok {
+		return user, user != nil
+	}
+	return v.db.byID(id)
+}
+
+func (v *txnView) byUsername(username string) (*User, bool) {
+	key := v.db.normalization.key(username)
+	if user, ok := v.usernames[key]; ok {
+		return user, user != nil
+	}
+	return v.db.byUsername(username)
+}
+
+func (v *txnView) byEmail(email string) (*User, bool) {
+	key := v.db.normalization.key(email)
+	if user, ok := v.emails[key]; ok {
+		return user, user != nil
+	}
+	return v.db.byEmail(email)
+}
+
+// apply checks op against the view and applies it, returning op with the
+// version it gives the user.
+func (v *txnView) apply(op walRecord) (walRecord, error) {
+	user := op.User
+
+	switch op.Op {
+	case walOpInsert:
+		if err := v.validateUnique(&user); err != nil {
+			return walRecord{}, err
+		}
+		user.Version = 1
+		v.put(&user)
+	case walOpUpdate:
+		existing, ok := v.byID(user.ID)
+		if !ok {
+			return walRecord{}, NotFoundError
+		}
+		if err := checkVersion(existing, user.Version); err != nil {
+			return walRecord{}, err
+		}
+		if err := v.validateUnique(&user); err != nil {
+			return walRecord{}, err
+		}
+		user.Version = existing.Version + 1
+		user.CreatedAt = existing.CreatedAt
+		user.ServiceAccount = existing.ServiceAccount
+		if user.Password == "" {
+			user.Password = existing.Password
+		}
+		v.remove(existing)
+		v.put(&user)
+	case walOpDelete:
+		existing, ok := v.byID(user.ID)
+		if !ok {
+			return op, checkVersion(nil, user.Version)
+		}
+		if err := checkVersion(existing, user.Version); err != nil {
+			return walRecord{}, err
+		}
+		v.remove(existing)
+	}
+
+	op.User = user
+	return op, nil
+}
+
+func (v *txnView) validateUnique(user *User) error {
+	if owner, ok := v.byUsername(user.Username); ok && owner.ID != user.ID {
+		return AlreadyExistsError
+	}
+
+	if user.Email == "" {
+		return nil
+	}
+	if owner, ok := v.byEmail(user.Email); ok && owner.ID != user.ID {
+		return EmailAlreadyExistsError
+	}
+
+	return nil
+}
+
+func (v *txnView) put(user *User) {
+	v.ids[user.ID] = user
+	v.usernames[v.db.normalization.key(user.Username)] = user
+	if user.Email != "" {
+		v.emails[v.db.normalization.key(user.Email)] = user
+	}
+}
+
+func (v *txnView) remove(user *User) {
+	v.ids[user.ID] = nil
+	v.usernames[v.db.normalization.key(user.Username)] = nil
+	if user.Email != "" {
+		v.emails[v.db.normalization.key(user.Email)] = nil
+	}
+}
@@ -0,0 +1,192 @@
+package inmemory_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnCommit(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	oldAdmin, _ := db.InsertUser(inmemory.User{Username: "old", Email: "old@example.com", Admin: true})
+	newAdmin, _ := db.InsertUser(inmemory.User{Username: "new", Email: "new@example.com"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: oldAdmin, Username: "old", Email: "old@example.com"}))
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: newAdmin, Username: "new", Email: "new@example.com", Admin: true}))
+	insertedID, err := tx.InsertUser(inmemory.User{Username: "team", Email: "team@example.com"})
+	require.NoError(t, err)
+
+	user, err := tx.GetUserById(newAdmin)
+	assert.NoError(t, err)
+	assert.True(t, user.Admin)
+	_, err = tx.GetUserByUsername("team")
+	assert.NoError(t, err)
+	assert.Len(t, tx.GetUsers(), 3)
+
+	user, err = db.GetUserById(newAdmin)
+	assert.NoError(t, err)
+	assert.False(t, user.Admin)
+	_, err = db.GetUserById(insertedID)
+	assert.Equal(t, inmemory.NotFoundError, err)
+
+	require.NoError(t, tx.Commit())
+
+	user, err = db.GetUserById(oldAdmin)
+	assert.NoError(t, err)
+	assert.False(t, user.Admin)
+	user, err = db.GetUserById(newAdmin)
+	assert.NoError(t, err)
+	assert.True(t, user.Admin)
+	_, err = db.GetUserByEmail("team@example.com")
+	assert.NoError(t, err)
+	require.NoError(t, db.CheckIndexes())
+
+	assert.Equal(t, inmemory.TransactionDoneError, tx.Commit())
+}
+
+func TestTxnSwapUsernames(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	first, _ := db.InsertUser(inmemory.User{Username: "first"})
+	second, _ := db.InsertUser(inmemory.User{Username: "second"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: first, Username: "tmp"}))
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: second, Username: "first"}))
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: first, Username: "second"}))
+	require.NoError(t, tx.Commit())
+
+	user, err := db.GetUserByUsername("first")
+	assert.NoError(t, err)
+	assert.Equal(t, second, user.ID)
+	user, err = db.GetUserByUsername("second")
+	assert.NoError(t, err)
+	assert.Equal(t, first, user.ID)
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestTxnRollback(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.DeleteUser(id, 0))
+	_, err := tx.InsertUser(inmemory.User{Username: "other"})
+	require.NoError(t, err)
+	_, err = tx.GetUserById(id)
+	assert.Equal(t, inmemory.NotFoundError, err)
+	tx.Rollback()
+
+	_, err = db.GetUserById(id)
+	assert.NoError(t, err)
+	_, err = db.GetUserByUsername("other")
+	assert.Equal(t, inmemory.NotFoundError, err)
+	assert.Equal(t, inmemory.TransactionDoneError, tx.Commit())
+}
+
+func TestTxnConflict(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	tx := db.Begin()
+	_, err := tx.InsertUser(inmemory.User{Username: "first"})
+	require.NoError(t, err)
+	_, err = tx.InsertUser(inmemory.User{Username: "first"})
+	assert.Equal(t, inmemory.AlreadyExistsError, err)
+	_, err = tx.InsertUser(inmemory.User{Username: "contested"})
+	require.NoError(t, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "contested"})
+	require.NoError(t, err)
+
+	assert.Equal(t, inmemory.AlreadyExistsError, tx.Commit())
+	_, err = db.GetUserByUsername("first")
+	assert.Equal(t, inmemory.NotFoundError, err)
+	assert.Len(t, db.GetUsers(), 1)
+	require.NoError(t, db.CheckIndexes())
+}
+
+func TestTxnReplay(t *testing.T) {
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(t.TempDir(), "users.wal"),
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: id, Username: "renamed"}))
+	_, err = tx.InsertUser(inmemory.User{Username: "user"})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	committed, err := db.GetUserById(id)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	user, err := db.GetUserByUsername("renamed")
+	assert.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+	assert.Equal(t, uint64(2), user.Version)
+	assert.True(t, committed.CreatedAt.Equal(user.CreatedAt))
+	user, err = db.GetUserByUsername("user")
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, user.ID)
+}
+
+func TestTxnVersion(t *testing.T) {
+	db := inmemory.NewInMemoryDatabase()
+
+	id, _ := db.InsertUser(inmemory.User{Username: "user"})
+
+	tx := db.Begin()
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: id, Username: "first", Version: 1}))
+	assert.Equal(t, inmemory.VersionMismatchError, tx.UpdateUser(inmemory.User{ID: id, Username: "second", Version: 1}))
+	require.NoError(t, tx.UpdateUser(inmemory.User{ID: id, Username: "second", Version: 2}))
+
+	require.NoError(t, db.UpdateUser(inmemory.User{ID: id, Username: "outside", Version: 1}))
+
+	assert.Equal(t, inmemory.VersionMismatchError, tx.Commit())
+	user, err := db.GetUserById(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "outside", user.Username)
+	assert.Equal(t, uint64(2), user.Version)
+}
+
+func TestTxnReinsertSameIdentity(t *testing.T) {
+	// The ids of a transaction are merged in map order, so run it often
+	// enough that both orders of the delete and the insert come up.
+	for i := 0; i < 50; i++ {
+		db := inmemory.NewInMemoryDatabase()
+
+		id, _ := db.InsertUser(inmemory.User{Username: "user", Email: "user@example.com"})
+
+		tx := db.Begin()
+		require.NoError(t, tx.DeleteUser(id, 0))
+		insertedID, err := tx.InsertUser(inmemory.User{Username: "user", Email: "user@example.com"})
+		require.NoError(t, err)
+
+		users := tx.GetUsers()
+		require.Len(t, users, 1)
+		assert.Equal(t, insertedID, users[0].ID)
+
+		for _, sortBy := range []inmemory.SortField{inmemory.SortByUsername, inmemory.SortByEmail} {
+			page, err := tx.FindUsers(inmemory.Query{SortBy: sortBy})
+			require.NoError(t, err)
+			require.Len(t, page.Users, 1)
+			assert.Equal(t, insertedID, page.Users[0].ID)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package inmemory
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs the log after every record.
+	SyncAlways SyncPolicy = "always"
+	// SyncBatch fsyncs the log once BatchSize records have been written.
+	SyncBatch SyncPolicy = "batch"
+	// SyncInterval fsyncs the log in the background every SyncInterval.
+	SyncInterval SyncPolicy = "interval"
+)
+
+const (
+	walHeaderSize    = 8
+	walMaxRecordSize = 16 << 20
+)
+
+const (
+	defaultWALBatchSize    = 64
+	defaultWALSyncInterval = time.Second
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walOp string
+
+const (
+	walOpInsert walOp = "insert"
+	walOpUpdate walOp = "update"
+	walOpDelete walOp = "delete"
+	walOpTxn    walOp = "txn"
+
+	walOpPutKey    walOp = "putKey"
+	walOpDeleteKey walOp = "deleteKey"
+
+	walOpPutRole    walOp = "putRole"
+	walOpDeleteRole walOp = "deleteRole"
+
+	walOpPutTOTP    walOp = "putTotp"
+	walOpDeleteTOTP walOp = "deleteTotp"
+)
+
+// walRecord is a single logged mutation. Transactions are logged as one
+// walOpTxn record whose Batch holds their operations, so that they are
+// replayed all-or-nothing.
+type walRecord struct {
+	LSN   uint64      `json:"lsn"`
+	Op    walOp       `json:"op"`
+	User  User        `json:"user"`
+	Key   *APIKey     `json:"key,omitempty"`
+	Role  *Role       `json:"role,omitempty"`
+	TOTP  *TOTP       `json:"totp,omitempty"`
+	Batch []walRecord `json:"batch,omitempty"`
+}
+
+type WALOptions struct {
+	Path         string
+	SyncPolicy   SyncPolicy
+	BatchSize    int
+	SyncInterval time.Duration
+}
+
+// wal is an append-only log of user mutations. Every record is framed as
+// | crc32c (4 bytes) | payload length (4 bytes) | JSON payload |, the checksum
+// covering both the length and the payload.
+type wal struct {
+	opts    WALOptions
+	file    *os.File
+	lsn     uint64
+	records int
+	pending int
+	mu      sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func openWAL(opts WALOptions) (*wal, error) {
+	switch opts.SyncPolicy {
+	case "":
+		opts.SyncPolicy = SyncAlways
+	case SyncAlways, SyncBatch, SyncInterval:
+	default:
+		return nil, fmt.Errorf("unknown wal sync policy %q", opts.SyncPolicy)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultWALBatchSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaultWALSyncInterval
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("create wal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(opts.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+
+	return &wal{
+		opts: opts,
+		file: file,
+	}, nil
+}
+
+// replay reads every intact record from the start of the log and passes the
+// ones newer than after to apply. A torn or corrupt record ends the log: it and
+// everything after it are truncated so that new records are appended after the
+// last good one.
+func (w *wal) replay(after uint64, apply func(walRecord) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek wal: %w", err)
+	}
+
+	w.lsn = after
+	offset, err := readRecords(w.file, func(record walRecord, _ []byte) error {
+		w.records++
+		if record.LSN <= after {
+			return nil
+		}
+		if err := apply(record); err != nil {
+			return fmt.Errorf("apply wal record %d: %w", record.LSN, err)
+		}
+		w.lsn = record.LSN
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek wal: %w", err)
+	}
+
+	if w.opts.SyncPolicy == SyncInterval {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop()
+	}
+
+	return nil
+}
+
+func (w *wal) append(record walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	record.LSN = w.lsn + 1
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode wal record: %w", err)
+	}
+
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[0:4], recordChecksum(buf[4:8], payload))
+	copy(buf[walHeaderSize:], payload)
+
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+
+	w.lsn = record.LSN
+	w.records++
+	w.pending++
+
+	switch w.opts.SyncPolicy {
+	case SyncAlways:
+		return w.syncLocked()
+	case SyncBatch:
+		if w.pending >= w.opts.BatchSize {
+			return w.syncLocked()
+		}
+	}
+
+	return nil
+}
+
+func (w *wal) lastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lsn
+}
+
+func (w *wal) size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.records
+}
+
+// compact rewrites the log keeping only the records newer than upTo. The new
+// log is fully written and synced before it replaces the old one, so a crash
+// at any point leaves either the old or the new log in place.
+func (w *wal) compact(upTo uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	w.pending = 0
+
+	src, err := os.Open(w.opts.Path)
+	if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(w.opts.Path), filepath.Base(w.opts.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create compacted wal: %w", err)
+	}
+
+	records := 0
+	_, err = readRecords(src, func(record walRecord, frame []byte) error {
+		if record.LSN <= upTo {
+			return nil
+		}
+		records++
+		_, err := dst.Write(frame)
+		return err
+	})
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), w.opts.Path)
+	}
+	if err != nil {
+		_ = dst.Close()
+		_ = os.Remove(dst.Name())
+		return fmt.Errorf("compact wal: %w", err)
+	}
+
+	// The old log is unlinked now, so later records must go to the new one
+	// even if its directory entry is not durable yet.
+	_ = w.file.Close()
+	w.file = dst
+	w.records = records
+
+	return syncDir(filepath.Dir(w.opts.Path))
+}
+
+func (w *wal) syncLoop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			_ = w.syncLocked()
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *wal) syncLocked() error {
+	if w.pending == 0 {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	w.pending = 0
+
+	return nil
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// readRecords decodes records from r until the end of the log or the first
+// torn or corrupt record, and returns the offset just past the last good one.
+func readRecords(r io.Reader, fn func(record walRecord, frame []byte) error) (int64, error) {
+	var offset int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("read wal: %w", err)
+		}
+
+		checksum := binary.BigEndian.Uint32(header[0:4])
+		length := binary.BigEndian.Uint32(header[4:8])
+		if length > walMaxRecordSize {
+			return offset, nil
+		}
+
+		frame := make([]byte, walHeaderSize+length)
+		copy(frame, header)
+		if _, err := io.ReadFull(r, frame[walHeaderSize:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return 0, fmt.Errorf("read wal: %w", err)
+		}
+
+		payload := frame[walHeaderSize:]
+		if recordChecksum(header[4:8], payload) != checksum {
+			return offset, nil
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return offset, nil
+		}
+
+		if err := fn(record, frame); err != nil {
+			return 0, err
+		}
+
+		offset += int64(len(frame))
+	}
+}
+
+// syncDir is a variable so that tests can make syncing a directory fail.
+var syncDir = fsyncDir
+
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+
+	return nil
+}
+
+func recordChecksum(length, payload []byte) uint32 {
+	crc := crc32.Update(0, crcTable, length)
+	return crc32.Update(crc, crcTable, payload)
+}
@@ -0,0 +1,185 @@
+package inmemory_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/omelaymy/users/pkg/db/inmemory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALReplay(t *testing.T) {
+	for _, policy := range []inmemory.SyncPolicy{
+		inmemory.SyncAlways,
+		inmemory.SyncBatch,
+		inmemory.SyncInterval,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			opts := inmemory.Options{
+				WAL: inmemory.WALOptions{
+					Path:         filepath.Join(t.TempDir(), "users.wal"),
+					SyncPolicy:   policy,
+					BatchSize:    2,
+					SyncInterval: 10 * time.Millisecond,
+				},
+			}
+
+			db, err := inmemory.OpenInMemoryDatabase(opts)
+			require.NoError(t, err)
+
+			keptID, err := db.InsertUser(inmemory.User{
+				Username: "kept",
+				Email:    "kept@example.com",
+				Password: "password",
+			})
+			require.NoError(t, err)
+
+			deletedID, err := db.InsertUser(inmemory.User{
+				Username: "deleted",
+				Email:    "deleted@example.com",
+			})
+			require.NoError(t, err)
+
+			err = db.UpdateUser(inmemory.User{
+				ID:       keptID,
+				Username: "renamed",
+				Email:    "renamed@example.com",
+				Password: "password",
+				Admin:    true,
+			})
+			require.NoError(t, err)
+			require.NoError(t, db.DeleteUser(deletedID, 0))
+			require.NoError(t, db.Close())
+
+			db, err = inmemory.OpenInMemoryDatabase(opts)
+			require.NoError(t, err)
+			defer db.Close()
+
+			assert.Len(t, db.GetUsers(), 1)
+
+			user, err := db.GetUserByUsername("renamed")
+			assert.NoError(t, err)
+			assert.Equal(t, keptID, user.ID)
+			assert.Equal(t, "renamed@example.com", user.Email)
+			assert.Equal(t, "password", user.Password)
+			assert.True(t, user.Admin)
+
+			_, err = db.GetUserByUsername("kept")
+			assert.Equal(t, inmemory.NotFoundError, err)
+			_, err = db.GetUserById(deletedID)
+			assert.Equal(t, inmemory.NotFoundError, err)
+		})
+	}
+}
+
+// TestWALCompactDirSyncFails checks that records written after a compaction
+// whose directory sync failed still reach the log on disk.
+func TestWALCompactDirSyncFails(t *testing.T) {
+	dir := t.TempDir()
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(dir, "wal", "users.wal"),
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir:    filepath.Join(dir, "snapshots"),
+			Retain: 1,
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = db.InsertUser(inmemory.User{Username: fmt.Sprintf("before%d", i)})
+		require.NoError(t, err)
+	}
+
+	syncErr := errors.New("sync failed")
+	restore := inmemory.FailSyncDir(filepath.Dir(opts.WAL.Path), syncErr)
+	assert.ErrorIs(t, db.Snapshot(), syncErr)
+	restore()
+
+	_, err = db.InsertUser(inmemory.User{Username: "after"})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Len(t, db.GetUsers(), 6)
+	_, err = db.GetUserByUsername("after")
+	assert.NoError(t, err)
+}
+
+func TestWALTornTail(t *testing.T) {
+	opts := inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(t.TempDir(), "users.wal"),
+		},
+	}
+
+	db, err := inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	_, err = db.InsertUser(inmemory.User{Username: "first"})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	file, err := os.OpenFile(opts.WAL.Path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x00, 0x01, 0x00, '{'})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	_, err = db.GetUserByUsername("first")
+	assert.NoError(t, err)
+
+	_, err = db.InsertUser(inmemory.User{Username: "second"})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = inmemory.OpenInMemoryDatabase(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Len(t, db.GetUsers(), 2)
+	_, err = db.GetUserByUsername("second")
+	assert.NoError(t, err)
+}
+
+func TestWALUnknownSyncPolicy(t *testing.T) {
+	_, err := inmemory.OpenInMemoryDatabase(inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path:       filepath.Join(t.TempDir(), "users.wal"),
+			SyncPolicy: "sometimes",
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestWALWriteAfterClose(t *testing.T) {
+	db, err := inmemory.OpenInMemoryDatabase(inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path: filepath.Join(t.TempDir(), "users.wal"),
+		},
+	})
+	require.NoError(t, err)
+
+	userID, err := db.InsertUser(inmemory.User{Username: "ci", ServiceAccount: true})
+	require.NoError(t, err)
+	keyID, err := db.InsertAPIKey(inmemory.APIKey{UserID: userID, Name: "ci", Hash: []byte("hash")})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = db.InsertUser(inmemory.User{Username: "late"})
+	assert.Equal(t, inmemory.DatabaseClosedError, err)
+	assert.Equal(t, inmemory.DatabaseClosedError, db.InsertRole(inmemory.Role{Name: "late"}))
+	assert.Equal(t, inmemory.DatabaseClosedError, db.TouchAPIKey(keyID, time.Now()))
+	assert.Equal(t, inmemory.DatabaseClosedError, db.DeleteAPIKey(keyID))
+}
@@ -1,8 +1,14 @@
 package di
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-playground/locales/en"
 	"github.com/go-playground/validator/v10"
@@ -11,15 +17,21 @@ import (
 	"github.com/omelaymy/users/config"
 	"github.com/omelaymy/users/internal/api"
 	"github.com/omelaymy/users/internal/api/http/delivery"
-	"github.com/omelaymy/users/internal/api/http/errors"
+	apiErrors "github.com/omelaymy/users/internal/api/http/errors"
+	"github.com/omelaymy/users/internal/auth"
+	"github.com/omelaymy/users/internal/users"
+	"github.com/omelaymy/users/pkg/breached"
 	"github.com/omelaymy/users/pkg/db/inmemory"
 	"github.com/omelaymy/users/pkg/flags"
 	"github.com/omelaymy/users/pkg/logger"
+	"github.com/omelaymy/users/pkg/mail"
 	"github.com/omelaymy/users/pkg/secure"
+	"github.com/omelaymy/users/pkg/token"
 	"github.com/rs/zerolog"
 	"github.com/samber/do"
 
 	ut "github.com/go-playground/universal-translator"
+	"github.com/omelaymy/users/internal/auth/policy"
 	authRepo "github.com/omelaymy/users/internal/auth/repository"
 	authUsecase "github.com/omelaymy/users/internal/auth/usecase"
 	usersRepo "github.com/omelaymy/users/internal/users/repository"
@@ -48,12 +60,30 @@ func NewConfig(i *do.Injector) (*config.Config, error) {
 		return nil, fmt.Errorf("parse config error: %w", err)
 	}
 
-	cfg.BaseAdmin.Password, err = secure.HashPassword(cfg.BaseAdmin.Password)
+	return cfg, nil
+}
+
+func NewHasher(i *do.Injector) (*secure.Hasher, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	hasher, err := secure.New(secure.Options{
+		Algorithm: cfg.Auth.Password.Algorithm,
+		Argon2id: secure.Argon2idParams{
+			Memory:      cfg.Auth.Password.Argon2id.Memory,
+			Iterations:  cfg.Auth.Password.Argon2id.Iterations,
+			Parallelism: cfg.Auth.Password.Argon2id.Parallelism,
+			SaltLength:  cfg.Auth.Password.Argon2id.SaltLength,
+			KeyLength:   cfg.Auth.Password.Argon2id.KeyLength,
+		},
+		Bcrypt: secure.BcryptParams{
+			Cost: cfg.Auth.Password.Bcrypt.Cost,
+		},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("hashing admin password error: %w", err)
+		return nil, fmt.Errorf("password hasher error: %w", err)
 	}
 
-	return cfg, nil
+	return hasher, nil
 }
 
 func NewLogger(i *do.Injector) (*zerolog.Logger, error) {
@@ -66,28 +96,218 @@ func NewLogger(i *do.Injector) (*zerolog.Logger, error) {
 func NewInMemoryDatabase(i *do.Injector) (*inmemory.InMemoryDatabase, error) {
 	cfg := do.MustInvoke[*config.Config](i)
 
-	db := inmemory.NewInMemoryDatabase()
-	_, err := db.InsertUser(inmemory.User{
-		Email:    cfg.BaseAdmin.Email,
-		Username: cfg.BaseAdmin.Username,
-		Password: cfg.BaseAdmin.Password,
-		Admin:    true,
+	db, err := inmemory.OpenInMemoryDatabase(inmemory.Options{
+		WAL: inmemory.WALOptions{
+			Path:         cfg.Database.WAL.Path,
+			SyncPolicy:   inmemory.SyncPolicy(cfg.Database.WAL.SyncPolicy),
+			BatchSize:    cfg.Database.WAL.BatchSize,
+			SyncInterval: cfg.Database.WAL.SyncInterval,
+		},
+		Snapshot: inmemory.SnapshotOptions{
+			Dir:       cfg.Database.Snapshot.Dir,
+			Interval:  cfg.Database.Snapshot.Interval,
+			Threshold: cfg.Database.Snapshot.Threshold,
+			Retain:    cfg.Database.Snapshot.Retain,
+		},
+		Normalization: inmemory.Normalization{
+			CaseFold: cfg.Database.Normalization.CaseFold,
+			NFKC:     cfg.Database.Normalization.NFKC,
+		},
+		Shards: cfg.Database.Shards,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open database error: %w", err)
+	}
+
+	if err = migrateRoles(db); err != nil {
+		return nil, fmt.Errorf("migrate roles error: %w", err)
+	}
+
+	_, err = db.GetUserByUsername(cfg.BaseAdmin.Username)
+	if err == nil {
+		return db, nil
+	}
+	if !errors.Is(err, inmemory.NotFoundError) {
+		return nil, err
+	}
+
+	password, err := do.MustInvoke[*secure.Hasher](i).Hash(cfg.BaseAdmin.Password)
+	if err != nil {
+		return nil, fmt.Errorf("hashing admin password error: %w", err)
+	}
+
+	_, err = db.InsertUser(inmemory.User{
+		Email:         cfg.BaseAdmin.Email,
+		Username:      cfg.BaseAdmin.Username,
+		Password:      password,
+		Roles:         []string{auth.AdminRole},
+		EmailVerified: true,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	return db, nil
+}
 
+// defaultRoles are created together with the admin role when a database has
+// no roles yet.
+var defaultRoles = []inmemory.Role{
+	{
+		Name:        "user",
+		Description: "Reads users",
+		Permissions: []string{auth.PermissionUsersRead},
+	},
+	{
+		Name:        "auditor",
+		Description: "Reads users and roles",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionRolesRead},
+	},
+	{
+		Name:        "helpdesk",
+		Description: "Reads users, resets their passwords and unlocks them",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersResetPassword, auth.PermissionUsersUnlock},
+	},
+}
+
+// migrateRoles creates the default roles in a database without roles, which
+// was written before roles existed, and gives its admins the admin role and
+// everybody else the user role, so nobody loses access. On every start the
+// admin role is updated to hold all permissions, keeping whether it requires
+// a second factor.
+func migrateRoles(db *inmemory.InMemoryDatabase) error {
+	admin := inmemory.Role{
+		Name:        auth.AdminRole,
+		Description: "Has every permission",
+		Permissions: auth.Permissions,
+	}
+	if existing, err := db.GetRole(auth.AdminRole); err == nil {
+		if strings.Join(existing.Permissions, " ") == strings.Join(admin.Permissions, " ") {
+			return nil
+		}
+		admin.RequireTOTP = existing.RequireTOTP
+		return db.UpdateRole(admin)
+	}
+	if len(db.GetRoles()) > 0 {
+		return fmt.Errorf("role %q is missing", auth.AdminRole)
+	}
+
+	for _, role := range append([]inmemory.Role{admin}, defaultRoles...) {
+		if err := db.InsertRole(role); err != nil {
+			return err
+		}
+	}
+
+	for _, user := range db.GetUsers() {
+		user.Roles = []string{"user"}
+		if user.Admin {
+			user.Roles = []string{auth.AdminRole}
+		}
+		user.Admin = false
+		if err := db.UpdateUser(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func NewUsers(i *do.Injector) (*usersUsecase.Users, error) {
 	return usersUsecase.NewUsers(
 		do.MustInvoke[*config.Config](i),
 		do.MustInvoke[*usersRepo.UsersRepository](i),
+		do.MustInvoke[*authUsecase.Auth](i),
+		do.MustInvoke[*secure.Hasher](i),
+		do.MustInvoke[*breached.List](i),
+		do.MustInvoke[*usersUsecase.EmailVerification](i),
+	), nil
+}
+
+func NewEmailVerification(i *do.Injector) (*usersUsecase.EmailVerification, error) {
+	return usersUsecase.NewEmailVerification(
+		do.MustInvoke[*config.Config](i),
+		do.MustInvoke[*usersRepo.UsersRepository](i),
+		do.MustInvoke[*token.Manager](i),
+		do.MustInvoke[users.Mailer](i),
+		do.MustInvoke[*zerolog.Logger](i),
+	), nil
+}
+
+func NewPasswordReset(i *do.Injector) (*usersUsecase.PasswordReset, error) {
+	return usersUsecase.NewPasswordReset(
+		do.MustInvoke[*config.Config](i),
+		do.MustInvoke[*usersRepo.UsersRepository](i),
+		do.MustInvoke[*usersRepo.ResetTokenRepository](i),
+		do.MustInvoke[*usersUsecase.Users](i),
+		do.MustInvoke[users.Mailer](i),
+		time.Now,
+	), nil
+}
+
+func NewInvitations(i *do.Injector) (*usersUsecase.Invitations, error) {
+	return usersUsecase.NewInvitations(
+		do.MustInvoke[*config.Config](i),
+		do.MustInvoke[*usersRepo.UsersRepository](i),
+		do.MustInvoke[*usersRepo.InviteRepository](i),
+		do.MustInvoke[*usersUsecase.Users](i),
+		do.MustInvoke[users.Mailer](i),
+		time.Now,
 	), nil
 }
 
+func NewAccountStatus(i *do.Injector) (*usersUsecase.AccountStatus, error) {
+	return usersUsecase.NewAccountStatus(
+		do.MustInvoke[*usersRepo.UsersRepository](i),
+		do.MustInvoke[*authUsecase.Auth](i),
+		time.Now,
+	), nil
+}
+
+func NewInviteRepository(*do.Injector) (*usersRepo.InviteRepository, error) {
+	return usersRepo.NewInviteRepository(), nil
+}
+
+func NewResetTokenRepository(*do.Injector) (*usersRepo.ResetTokenRepository, error) {
+	return usersRepo.NewResetTokenRepository(), nil
+}
+
+// NewMailer delivers mails through SMTP or, for development, writes them to
+// a file or stdout.
+func NewMailer(i *do.Injector) (users.Mailer, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer, err := mail.NewSMTPMailer(mail.SMTPOptions{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			From:     cfg.Mail.From,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("mailer error: %w", err)
+		}
+		return mailer, nil
+	case "file", "":
+		out := io.Writer(os.Stdout)
+		if cfg.Mail.File != "" {
+			file, err := os.OpenFile(cfg.Mail.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("mailer error: %w", err)
+			}
+			out = file
+		}
+		mailer, err := mail.NewFileMailer(out, cfg.Mail.From)
+		if err != nil {
+			return nil, fmt.Errorf("mailer error: %w", err)
+		}
+		return mailer, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+}
+
 func NewUsersRepository(i *do.Injector) (*usersRepo.UsersRepository, error) {
 	return usersRepo.NewUsersRepository(
 		do.MustInvoke[*inmemory.InMemoryDatabase](i),
@@ -96,18 +316,136 @@ func NewUsersRepository(i *do.Injector) (*usersRepo.UsersRepository, error) {
 }
 
 func NewAuth(i *do.Injector) (*authUsecase.Auth, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
 	return authUsecase.NewAuth(
 		do.MustInvoke[*authRepo.AuthRepository](i),
+		do.MustInvoke[*authRepo.SessionRepository](i),
+		do.MustInvoke[*authRepo.LockoutRepository](i),
+		do.MustInvoke[*token.Manager](i),
+		do.MustInvoke[*secure.Hasher](i),
+		auth.LockoutOptions{
+			Threshold:          cfg.Auth.Lockout.Threshold,
+			IPThreshold:        cfg.Auth.Lockout.IPThreshold,
+			BaseDelay:          cfg.Auth.Lockout.BaseDelay,
+			MaxDelay:           cfg.Auth.Lockout.MaxDelay,
+			Window:             cfg.Auth.Lockout.Window,
+			PermanentThreshold: cfg.Auth.Lockout.PermanentThreshold,
+		},
+		auth.TOTPOptions{
+			Issuer:        cfg.Auth.TOTP.Issuer,
+			Skew:          cfg.Auth.TOTP.Skew,
+			RecoveryCodes: cfg.Auth.TOTP.RecoveryCodes,
+		},
+		cfg.Auth.EmailVerification.Required,
+		time.Now,
 	), nil
 }
 
+func NewTokenManager(i *do.Injector) (*token.Manager, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	opts := token.Options{
+		Issuer:          cfg.Auth.JWT.Issuer,
+		Audience:        cfg.Auth.JWT.Audience,
+		AccessTTL:       cfg.Auth.JWT.AccessTTL,
+		RefreshTTL:      cfg.Auth.JWT.RefreshTTL,
+		VerificationTTL: cfg.Auth.EmailVerification.TokenTTL,
+		SigningKey:      cfg.Auth.JWT.SigningKey,
+	}
+	for _, key := range cfg.Auth.JWT.Keys {
+		if key.Algorithm == token.HS256 && key.Secret == "" {
+			return nil, fmt.Errorf("token manager error: secret of key %q is empty", key.ID)
+		}
+		opts.Keys = append(opts.Keys, token.KeyOptions{
+			ID:             key.ID,
+			Algorithm:      key.Algorithm,
+			Secret:         key.Secret,
+			PrivateKeyFile: key.PrivateKeyFile,
+			PublicKeyFile:  key.PublicKeyFile,
+		})
+	}
+
+	manager, err := token.NewManager(opts, time.Now)
+	if err != nil {
+		return nil, fmt.Errorf("token manager error: %w", err)
+	}
+
+	return manager, nil
+}
+
+// NewPolicy loads the access policy file, which is looked up next to the
+// config file unless its path is absolute.
+func NewPolicy(i *do.Injector) (*policy.Engine, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	engine, err := policy.Load(configPath(i, cfg.Auth.PolicyFile))
+	if err != nil {
+		return nil, fmt.Errorf("load policy error: %w", err)
+	}
+
+	return engine, nil
+}
+
+// NewBreachedPasswords loads the list of breached passwords, which is looked
+// up next to the config file unless its path is absolute.
+func NewBreachedPasswords(i *do.Injector) (*breached.List, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	list, err := breached.Load(configPath(i, cfg.Auth.Password.Policy.BreachedFile))
+	if err != nil {
+		return nil, fmt.Errorf("load breached passwords error: %w", err)
+	}
+
+	return list, nil
+}
+
+// configPath resolves a path of the config relative to the config file.
+func configPath(i *do.Injector, path string) string {
+	allFlags := do.MustInvoke[*flags.Flags](i)
+
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(*allFlags.ConfigFile), path)
+	}
+
+	return path
+}
+
 func NewAuthRepository(i *do.Injector) (*authRepo.AuthRepository, error) {
 	return authRepo.NewAuthRepository(
 		do.MustInvoke[*inmemory.InMemoryDatabase](i),
+		do.MustInvoke[*secure.Cipher](i),
 		do.MustInvoke[*zerolog.Logger](i),
 	), nil
 }
 
+func NewCipher(i *do.Injector) (*secure.Cipher, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	if cfg.Auth.TOTP.EncryptionKey == "" {
+		return nil, errors.New("totp encryption key error: auth.totp.encryptionKey is empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.Auth.TOTP.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("totp encryption key error: %w", err)
+	}
+
+	cipher, err := secure.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("totp encryption key error: %w", err)
+	}
+
+	return cipher, nil
+}
+
+func NewSessionRepository(*do.Injector) (*authRepo.SessionRepository, error) {
+	return authRepo.NewSessionRepository(), nil
+}
+
+func NewLockoutRepository(*do.Injector) (*authRepo.LockoutRepository, error) {
+	return authRepo.NewLockoutRepository(), nil
+}
+
 func NewMWManager(i *do.Injector) (*api.MWManager, error) {
 	return api.NewMWManager(
 		do.MustInvoke[*authUsecase.Auth](i),
@@ -117,6 +455,12 @@ func NewMWManager(i *do.Injector) (*api.MWManager, error) {
 func NewHandlers(i *do.Injector) (*delivery.Handlers, error) {
 	return delivery.NewHandlers(
 		do.MustInvoke[*usersUsecase.Users](i),
+		do.MustInvoke[*usersUsecase.PasswordReset](i),
+		do.MustInvoke[*usersUsecase.EmailVerification](i),
+		do.MustInvoke[*usersUsecase.Invitations](i),
+		do.MustInvoke[*usersUsecase.AccountStatus](i),
+		do.MustInvoke[*authUsecase.Auth](i),
+		do.MustInvoke[*policy.Engine](i),
 		do.MustInvoke[*validator.Validate](i),
 		do.MustInvoke[ut.Translator](i),
 	), nil
@@ -130,14 +474,14 @@ func NewRoutes(i *do.Injector) (*delivery.Routes, error) {
 	), nil
 }
 
-func NewHttpErrorHandler(i *do.Injector) (*errors.HttpErrorHandler, error) {
-	return errors.NewHttpErrorHandler(
+func NewHttpErrorHandler(i *do.Injector) (*apiErrors.HttpErrorHandler, error) {
+	return apiErrors.NewHttpErrorHandler(
 		do.MustInvoke[*zerolog.Logger](i),
 	), nil
 }
 
 func NewFiberApp(i *do.Injector) (*fiber.App, error) {
-	h := do.MustInvoke[*errors.HttpErrorHandler](i)
+	h := do.MustInvoke[*apiErrors.HttpErrorHandler](i)
 
 	app := fiber.New(fiber.Config{ErrorHandler: h.Handler})
 	app.Use(cors.New(cors.Config{
@@ -147,6 +491,7 @@ func NewFiberApp(i *do.Injector) (*fiber.App, error) {
 			fiber.MethodGet,
 			fiber.MethodPost,
 			fiber.MethodPut,
+			fiber.MethodPatch,
 			fiber.MethodDelete,
 		}, ","),
 	}))
@@ -161,21 +506,46 @@ func NewTranslator(i *do.Injector) (ut.Translator, error) {
 	uni := ut.New(enTranslator, enTranslator)
 	translator, _ := uni.GetTranslator(cfg.System.DefaultLocale)
 
+	translations := map[string]string{
+		users.PasswordMinLength: "{0} must be at least {1} characters long",
+		users.PasswordClasses:   "{0} must mix at least {1} of lowercase letters, uppercase letters, digits and symbols",
+		users.PasswordUsername:  "{0} must not contain the username",
+		users.PasswordEmail:     "{0} must not contain the email",
+		users.PasswordBreached:  "{0} is known from a data breach, choose another one",
+	}
+	for key, text := range translations {
+		if err := translator.Add(key, text, true); err != nil {
+			return nil, err
+		}
+	}
+
 	return translator, nil
 }
 
 func NewValidate(i *do.Injector) (*validator.Validate, error) {
 	translator := do.MustInvoke[ut.Translator](i)
 
+	translations := map[string]string{
+		"required":        "{0} must have a value!",
+		"required_unless": "{0} must have a value!",
+		"oneof":           "{0} must be one of: {1}",
+		"datetime":        "{0} must be an RFC 3339 time",
+		"min":             "{0} must be at least {1}",
+		"max":             "{0} must be at most {1}",
+	}
+
 	v := validator.New()
-	err := v.RegisterTranslation("required", translator, func(ut ut.Translator) error {
-		return ut.Add("required", "{0} must have a value!", true)
-	}, func(ut ut.Translator, fe validator.FieldError) string {
-		t, _ := ut.T("required", strings.ToLower(fe.Field()))
-		return t
-	})
-	if err != nil {
-		return nil, err
+	for tag, text := range translations {
+		tag, text := tag, text
+		err := v.RegisterTranslation(tag, translator, func(ut ut.Translator) error {
+			return ut.Add(tag, text, true)
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(tag, strings.ToLower(fe.Field()), fe.Param())
+			return t
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return v, nil
@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var InvalidAddressError = errors.New("invalid email address")
+
+var InvalidHeaderError = errors.New("mail headers must not contain line breaks")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(message Message) error
+}
+
+// SMTPOptions configure an SMTPMailer. Username and Password are only sent
+// when Username is set; net/smtp refuses to send them unencrypted to hosts
+// other than localhost.
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer delivers messages through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from *mail.Address
+}
+
+func NewSMTPMailer(opts SMTPOptions) (*SMTPMailer, error) {
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", InvalidAddressError, opts.From)
+	}
+
+	mailer := &SMTPMailer{
+		addr: net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)),
+		from: from,
+	}
+	if opts.Username != "" {
+		mailer.auth = smtp.PlainAuth("", opts.Username, opts.Password, opts.Host)
+	}
+
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	to, data, err := compose(m.from, message)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from.Address, []string{to.Address}, data)
+}
+
+// FileMailer writes messages to a file or stdout instead of delivering them,
+// for development and tests.
+type FileMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from *mail.Address
+}
+
+func NewFileMailer(w io.Writer, from string) (*FileMailer, error) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", InvalidAddressError, from)
+	}
+
+	return &FileMailer{w: w, from: address}, nil
+}
+
+func (m *FileMailer) Send(message Message) error {
+	_, data, err := compose(m.from, message)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = m.w.Write(append(data, "\r\n"...))
+	return err
+}
+
+// compose renders a message as RFC 5322 text with CRLF line endings.
+// Subjects are encoded, so they may hold any text but no line breaks, which
+// would let callers add headers.
+func compose(from *mail.Address, message Message) (*mail.Address, []byte, error) {
+	if strings.ContainsAny(message.To, "\r\n") || strings.ContainsAny(message.Subject, "\r\n") {
+		return nil, nil, InvalidHeaderError
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", InvalidAddressError, message.To)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	for _, line := range strings.Split(body, "\n") {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+
+	return to, buf.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package mail_test
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP accepts one message without authentication or TLS and sends what
+// it got on the returned channel.
+func fakeSMTP(t *testing.T) (int, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var envelope strings.Builder
+		_ = text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				_ = text.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				envelope.WriteString(line + "\n")
+				_ = text.PrintfLine("250 OK")
+			case "DATA":
+				_ = text.PrintfLine("354 Go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- envelope.String() + string(data)
+				_ = text.PrintfLine("250 OK")
+			case "QUIT":
+				_ = text.PrintfLine("221 Bye")
+				return
+			default:
+				_ = text.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	port, received := fakeSMTP(t)
+
+	mailer, err := mail.NewSMTPMailer(mail.SMTPOptions{
+		Host: "127.0.0.1",
+		Port: port,
+		From: "Users <no-reply@example.com>",
+	})
+	require.NoError(t, err)
+
+	err = mailer.Send(mail.Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Your token:\n.abc\n",
+	})
+	require.NoError(t, err)
+
+	message := <-received
+	assert.Contains(t, message, "MAIL FROM:<no-reply@example.com>")
+	assert.Contains(t, message, "RCPT TO:<alice@example.com>")
+	assert.Contains(t, message, "From: \"Users\" <no-reply@example.com>\n")
+	assert.Contains(t, message, "To: <alice@example.com>\n")
+	assert.Contains(t, message, "Subject: Reset your password\n")
+	assert.Contains(t, message, "\n\nYour token:\n.abc\n", "dots at the start of lines survive")
+}
+
+func TestFileMailer(t *testing.T) {
+	var buf bytes.Buffer
+	mailer, err := mail.NewFileMailer(&buf, "no-reply@example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, mailer.Send(mail.Message{To: "bob@example.com", Subject: "Grüße", Body: "Hello"}))
+
+	reader := textproto.NewReader(bufio.NewReader(&buf))
+	header, err := reader.ReadMIMEHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "<no-reply@example.com>", header.Get("From"))
+	assert.Equal(t, "<bob@example.com>", header.Get("To"))
+	assert.Equal(t, "=?utf-8?q?Gr=C3=BC=C3=9Fe?=", header.Get("Subject"))
+	body, err := reader.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", body)
+
+	err = mailer.Send(mail.Message{To: "bob@example.com", Subject: "Hi\r\nBcc: eve@example.com"})
+	assert.ErrorIs(t, err, mail.InvalidHeaderError)
+	err = mailer.Send(mail.Message{To: "not an address"})
+	assert.ErrorIs(t, err, mail.InvalidAddressError)
+
+	_, err = mail.NewFileMailer(&buf, "42")
+	assert.ErrorIs(t, err, mail.InvalidAddressError)
+}
@@ -0,0 +1,54 @@
+package secure
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type BcryptParams struct {
+	Cost int
+}
+
+// Bcrypt hashes in the modular crypt format of bcrypt, like
+// $2a$12$<salt and hash>, which predates PHC strings.
+type Bcrypt struct {
+	params BcryptParams
+}
+
+func NewBcrypt(params BcryptParams) *Bcrypt {
+	if params.Cost == 0 {
+		params.Cost = bcrypt.DefaultCost
+	}
+
+	return &Bcrypt{params: params}
+}
+
+func (b *Bcrypt) Matches(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.params.Cost)
+	return string(hash), err
+}
+
+func (b *Bcrypt) Compare(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return MismatchError
+	}
+	if err != nil {
+		return InvalidHashError
+	}
+
+	return nil
+}
+
+func (b *Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.params.Cost
+}
@@ -0,0 +1,64 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// KeyLength is the size of encryption keys, which select AES-256.
+const KeyLength = 32
+
+var InvalidKeyError = errors.New("encryption key must be 32 bytes")
+
+var DecryptionError = errors.New("ciphertext cannot be decrypted")
+
+// Cipher encrypts secrets that must be read back, unlike passwords, with
+// AES-GCM. Ciphertexts start with their random nonce.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeyLength {
+		return nil, InvalidKeyError
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt seals plaintext. The ciphertext only decrypts with the same
+// additionalData, which binds it to what it belongs to, like the id of its
+// user.
+func (c *Cipher) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size+c.aead.Overhead() {
+		return nil, DecryptionError
+	}
+
+	plaintext, err := c.aead.Open(nil, ciphertext[:size], ciphertext[size:], additionalData)
+	if err != nil {
+		return nil, DecryptionError
+	}
+
+	return plaintext, nil
+}
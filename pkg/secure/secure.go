@@ -1,12 +1,126 @@
 package secure
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 8)
-	return string(bytes), err
+var MismatchError = errors.New("password does not match")
+
+var UnknownAlgorithmError = errors.New("unknown password hash algorithm")
+
+var InvalidHashError = errors.New("invalid password hash")
+
+// Algorithm is one way of hashing passwords. Its hashes name it, so that
+// hashes of different algorithms and parameters can be stored side by side.
+type Algorithm interface {
+	// Matches reports whether hash was made by the algorithm.
+	Matches(hash string) bool
+	Hash(password string) (string, error)
+	Compare(hash, password string) error
+	// Outdated reports whether hash was made with other parameters than
+	// those the algorithm hashes with now.
+	Outdated(hash string) bool
+}
+
+// Hasher hashes passwords with its current algorithm and checks them against
+// hashes of the current and any of the other algorithms.
+//
+// Every comparison does the work of every algorithm once, comparing with
+// hashes of the algorithms that did not make the hash to check, so that
+// response times tell neither whether there was a hash nor which algorithm
+// made it.
+type Hasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+
+	dummyOnce sync.Once
+	// dummyHashes are made by the algorithms at the same positions.
+	dummyHashes []string
+}
+
+func NewHasher(current Algorithm, others ...Algorithm) *Hasher {
+	return &Hasher{
+		current:    current,
+		algorithms: append([]Algorithm{current}, others...),
+	}
+}
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+type Options struct {
+	// Algorithm new hashes are made with, argon2id or bcrypt. Hashes of
+	// either are always checked.
+	Algorithm string
+	Argon2id  Argon2idParams
+	Bcrypt    BcryptParams
+}
+
+// New makes a Hasher for both supported algorithms.
+func New(opts Options) (*Hasher, error) {
+	argon2id := NewArgon2id(opts.Argon2id)
+	bcrypt := NewBcrypt(opts.Bcrypt)
+
+	switch opts.Algorithm {
+	case AlgorithmArgon2id:
+		return NewHasher(argon2id, bcrypt), nil
+	case AlgorithmBcrypt:
+		return NewHasher(bcrypt, argon2id), nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", UnknownAlgorithmError, opts.Algorithm)
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Compare fails with MismatchError if password does not match hash.
+func (h *Hasher) Compare(hash, password string) error {
+	h.makeDummyHashes()
+
+	err := UnknownAlgorithmError
+	matched := false
+	for i, algorithm := range h.algorithms {
+		if !matched && algorithm.Matches(hash) {
+			matched = true
+			err = algorithm.Compare(hash, password)
+			continue
+		}
+		_ = algorithm.Compare(h.dummyHashes[i], password)
+	}
+
+	return err
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than new hashes are. A password that matches such a hash
+// should be hashed and stored again.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.current.Matches(hash) || h.current.Outdated(hash)
+}
+
+// CompareDummy does the work of Compare for callers without a hash to
+// compare with, like sign-ins of unknown users, so that response times do not
+// tell whether there was one.
+func (h *Hasher) CompareDummy(password string) {
+	h.makeDummyHashes()
+
+	for i, algorithm := range h.algorithms {
+		_ = algorithm.Compare(h.dummyHashes[i], password)
+	}
 }
 
-func ComparePasswords(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// makeDummyHashes hashes a password with every algorithm on first use.
+func (h *Hasher) makeDummyHashes() {
+	h.dummyOnce.Do(func() {
+		h.dummyHashes = make([]string, len(h.algorithms))
+		for i, algorithm := range h.algorithms {
+			h.dummyHashes[i], _ = algorithm.Hash("dummy password")
+		}
+	})
 }
@@ -0,0 +1,118 @@
+package secure_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omelaymy/users/pkg/secure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cheap = secure.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestArgon2id(t *testing.T) {
+	argon2id := secure.NewArgon2id(cheap)
+
+	hash, err := argon2id.Hash("password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+	assert.True(t, argon2id.Matches(hash))
+
+	other, err := argon2id.Hash("password")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "every hash has its own salt")
+
+	assert.NoError(t, argon2id.Compare(hash, "password"))
+	assert.Equal(t, secure.MismatchError, argon2id.Compare(hash, "wrongpassword"))
+	assert.False(t, argon2id.Outdated(hash))
+
+	stronger := cheap
+	stronger.Iterations = 2
+	assert.True(t, secure.NewArgon2id(stronger).Outdated(hash))
+	// Hashes are checked with their own parameters.
+	assert.NoError(t, secure.NewArgon2id(stronger).Compare(hash, "password"))
+
+	for _, invalid := range []string{
+		"$argon2id$",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+		"$argon2id$v=19$m=64,t=1,p=1$!$a2V5",
+	} {
+		assert.Equal(t, secure.InvalidHashError, argon2id.Compare(invalid, "password"), invalid)
+		assert.True(t, argon2id.Outdated(invalid), invalid)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	bcrypt := secure.NewBcrypt(secure.BcryptParams{Cost: 4})
+
+	hash, err := bcrypt.Hash("password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"), hash)
+	assert.True(t, bcrypt.Matches(hash))
+	assert.NoError(t, bcrypt.Compare(hash, "password"))
+	assert.Equal(t, secure.MismatchError, bcrypt.Compare(hash, "wrongpassword"))
+	assert.False(t, bcrypt.Outdated(hash))
+	assert.True(t, secure.NewBcrypt(secure.BcryptParams{Cost: 5}).Outdated(hash))
+	assert.Equal(t, secure.InvalidHashError, bcrypt.Compare("$2a$04$", "password"))
+}
+
+func TestHasher(t *testing.T) {
+	_, err := secure.New(secure.Options{Algorithm: "md5"})
+	assert.ErrorIs(t, err, secure.UnknownAlgorithmError)
+
+	hasher, err := secure.New(secure.Options{
+		Algorithm: secure.AlgorithmArgon2id,
+		Argon2id:  cheap,
+		Bcrypt:    secure.BcryptParams{Cost: 4},
+	})
+	require.NoError(t, err)
+
+	legacy, err := secure.NewBcrypt(secure.BcryptParams{Cost: 4}).Hash("password")
+	require.NoError(t, err)
+	assert.NoError(t, hasher.Compare(legacy, "password"))
+	assert.Equal(t, secure.MismatchError, hasher.Compare(legacy, "wrongpassword"))
+	assert.True(t, hasher.NeedsRehash(legacy))
+
+	hash, err := hasher.Hash("password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"), hash)
+	assert.NoError(t, hasher.Compare(hash, "password"))
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	assert.Equal(t, secure.UnknownAlgorithmError, hasher.Compare("plaintext", "plaintext"))
+	hasher.CompareDummy("password")
+}
+
+func TestCipher(t *testing.T) {
+	_, err := secure.NewCipher([]byte("short"))
+	assert.ErrorIs(t, err, secure.InvalidKeyError)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	c, err := secure.NewCipher(key)
+	require.NoError(t, err)
+
+	ciphertext, err := c.Encrypt([]byte("secret"), []byte("user"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "secret")
+
+	other, err := c.Encrypt([]byte("secret"), []byte("user"))
+	require.NoError(t, err)
+	assert.NotEqual(t, ciphertext, other, "nonces must differ")
+
+	plaintext, err := c.Decrypt(ciphertext, []byte("user"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), plaintext)
+
+	_, err = c.Decrypt(ciphertext, []byte("another user"))
+	assert.ErrorIs(t, err, secure.DecryptionError)
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = c.Decrypt(ciphertext, []byte("user"))
+	assert.ErrorIs(t, err, secure.DecryptionError)
+
+	_, err = c.Decrypt(nil, nil)
+	assert.ErrorIs(t, err, secure.DecryptionError)
+}
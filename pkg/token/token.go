@@ -0,0 +1,234 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+	// TypeVerification tokens prove that their subject receives mail at
+	// the address in their email claim.
+	TypeVerification = "verification"
+)
+
+// minSecretLength is the shortest HMAC secret accepted, matching the size of
+// the SHA-256 output.
+const minSecretLength = 32
+
+var InvalidTokenError = errors.New("invalid token")
+
+type KeyOptions struct {
+	ID        string
+	Algorithm string
+	// Secret is the key of an HS256 key.
+	Secret string
+	// PrivateKeyFile and PublicKeyFile hold PEM encoded Ed25519 keys. An
+	// EdDSA key with only a public key verifies tokens but cannot sign them.
+	PrivateKeyFile string
+	PublicKeyFile  string
+}
+
+type Options struct {
+	Issuer          string
+	Audience        string
+	AccessTTL       time.Duration
+	RefreshTTL      time.Duration
+	VerificationTTL time.Duration
+	// SigningKey is the id of the key new tokens are signed with. All keys
+	// verify tokens, so a retired key stays listed until the tokens it
+	// signed have expired.
+	SigningKey string
+	Keys       []KeyOptions
+}
+
+type Claims struct {
+	jwt.RegisteredClaims
+	// Roles and Permissions are those of the subject when the token was
+	// issued.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
+	// SessionId names the session a token was issued for.
+	SessionId string `json:"sid,omitempty"`
+	// TOTPEnrollment marks access tokens that only allow setting up the
+	// second factor the roles of the subject require.
+	TOTPEnrollment bool `json:"enroll,omitempty"`
+	// Email is the address a verification token was mailed to.
+	Email string `json:"email,omitempty"`
+	Type  string `json:"typ"`
+}
+
+// Manager signs and verifies JWTs. Tokens name their key in the kid header.
+// It reads the time from clock, so tests can control when tokens expire.
+type Manager struct {
+	opts    Options
+	signing *key
+	keys    map[string]*key
+	parser  *jwt.Parser
+	clock   func() time.Time
+}
+
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	// private is nil for verify-only keys.
+	private any
+	public  any
+}
+
+func NewManager(opts Options, clock func() time.Time) (*Manager, error) {
+	m := &Manager{
+		opts: opts,
+		keys: make(map[string]*key, len(opts.Keys)),
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{HS256, EdDSA}),
+			jwt.WithIssuer(opts.Issuer),
+			jwt.WithAudience(opts.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithTimeFunc(clock),
+		),
+		clock: clock,
+	}
+
+	for _, keyOpts := range opts.Keys {
+		k, err := loadKey(keyOpts)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyOpts.ID, err)
+		}
+		if _, ok := m.keys[k.id]; ok {
+			return nil, fmt.Errorf("duplicate key %q", k.id)
+		}
+		m.keys[k.id] = k
+	}
+
+	signing, ok := m.keys[opts.SigningKey]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", opts.SigningKey)
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", opts.SigningKey)
+	}
+	m.signing = signing
+
+	return m, nil
+}
+
+func loadKey(opts KeyOptions) (*key, error) {
+	if opts.ID == "" {
+		return nil, errors.New("missing id")
+	}
+
+	switch opts.Algorithm {
+	case HS256:
+		if len(opts.Secret) < minSecretLength {
+			return nil, fmt.Errorf("secret must be at least %d bytes", minSecretLength)
+		}
+		secret := []byte(opts.Secret)
+		return &key{id: opts.ID, method: jwt.SigningMethodHS256, private: secret, public: secret}, nil
+	case EdDSA:
+		k := &key{id: opts.ID, method: jwt.SigningMethodEdDSA}
+		if opts.PrivateKeyFile != "" {
+			data, err := os.ReadFile(opts.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.private = private
+			k.public = private.(ed25519.PrivateKey).Public()
+		}
+		if opts.PublicKeyFile != "" {
+			data, err := os.ReadFile(opts.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if k.public, err = jwt.ParseEdPublicKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		}
+		if k.public == nil {
+			return nil, errors.New("missing privateKeyFile or publicKeyFile")
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", opts.Algorithm)
+	}
+}
+
+func (m *Manager) AccessTTL() time.Duration {
+	return m.opts.AccessTTL
+}
+
+// Sign fills in the issuer, audience, lifetime, id and type of a token with
+// the subject, roles, permissions and session of claims and signs it with the
+// signing key.
+func (m *Manager) Sign(tokenType string, claims Claims) (string, *Claims, error) {
+	ttl := m.opts.AccessTTL
+	switch tokenType {
+	case TypeRefresh:
+		ttl = m.opts.RefreshTTL
+	case TypeVerification:
+		ttl = m.opts.VerificationTTL
+	}
+
+	now := m.clock()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    m.opts.Issuer,
+		Subject:   claims.Subject,
+		Audience:  jwt.ClaimStrings{m.opts.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	claims.Type = tokenType
+
+	token := jwt.NewWithClaims(m.signing.method, &claims)
+	token.Header["kid"] = m.signing.id
+
+	signed, err := token.SignedString(m.signing.private)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signed, &claims, nil
+}
+
+// Verify checks the signature, issuer, audience, lifetime and type of a
+// token. Any failure is reported as InvalidTokenError.
+func (m *Manager) Verify(tokenString, tokenType string) (*Claims, error) {
+	var claims Claims
+	_, err := m.parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		id, _ := token.Header["kid"].(string)
+		k, ok := m.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", id)
+		}
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("key %q does not use %s", id, token.Method.Alg())
+		}
+		return k.public, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", InvalidTokenError, err)
+	}
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("%w: token type is not %q", InvalidTokenError, tokenType)
+	}
+
+	return &claims, nil
+}
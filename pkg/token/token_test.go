@@ -0,0 +1,213 @@
+package token_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/omelaymy/users/pkg/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var subjectClaims = token.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "subject"}}
+
+func testOptions(keys ...token.KeyOptions) token.Options {
+	return token.Options{
+		Issuer:     "users",
+		Audience:   "users-api",
+		AccessTTL:  time.Minute,
+		RefreshTTL: time.Hour,
+		SigningKey: keys[0].ID,
+		Keys:       keys,
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	private, public := writeEd25519Keys(t)
+
+	for _, key := range []token.KeyOptions{
+		{ID: "hmac", Algorithm: token.HS256, Secret: testSecret},
+		{ID: "ed25519", Algorithm: token.EdDSA, PrivateKeyFile: private, PublicKeyFile: public},
+	} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			m, err := token.NewManager(testOptions(key), time.Now)
+			require.NoError(t, err)
+
+			claims := subjectClaims
+			claims.Permissions, claims.SessionId = []string{"users:read"}, "session"
+			signed, _, err := m.Sign(token.TypeAccess, claims)
+			require.NoError(t, err)
+
+			verified, err := m.Verify(signed, token.TypeAccess)
+			require.NoError(t, err)
+			assert.Equal(t, "subject", verified.Subject)
+			assert.Equal(t, []string{"users:read"}, verified.Permissions)
+			assert.Equal(t, "session", verified.SessionId)
+			assert.NotEmpty(t, verified.ID)
+
+			_, err = m.Verify(signed, token.TypeRefresh)
+			assert.ErrorIs(t, err, token.InvalidTokenError)
+
+			parts := strings.Split(signed, ".")
+			parts[2] = strings.Repeat("A", len(parts[2]))
+			_, err = m.Verify(strings.Join(parts, "."), token.TypeAccess)
+			assert.ErrorIs(t, err, token.InvalidTokenError)
+		})
+	}
+}
+
+func TestVerificationTokens(t *testing.T) {
+	opts := testOptions(token.KeyOptions{ID: "hmac", Algorithm: token.HS256, Secret: testSecret})
+	opts.VerificationTTL = 72 * time.Hour
+	m, err := token.NewManager(opts, time.Now)
+	require.NoError(t, err)
+
+	claims := subjectClaims
+	claims.Email = "alice@example.com"
+	signed, issued, err := m.Sign(token.TypeVerification, claims)
+	require.NoError(t, err)
+	assert.Equal(t, 72*time.Hour, issued.ExpiresAt.Sub(issued.IssuedAt.Time))
+
+	verified, err := m.Verify(signed, token.TypeVerification)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", verified.Email)
+
+	_, err = m.Verify(signed, token.TypeAccess)
+	assert.ErrorIs(t, err, token.InvalidTokenError)
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m, err := token.NewManager(
+		testOptions(token.KeyOptions{ID: "hmac", Algorithm: token.HS256, Secret: testSecret}),
+		func() time.Time { return now },
+	)
+	require.NoError(t, err)
+
+	signed, issued, err := m.Sign(token.TypeAccess, subjectClaims)
+	require.NoError(t, err)
+	assert.True(t, now.Equal(issued.IssuedAt.Time))
+	assert.True(t, now.Add(time.Minute).Equal(issued.ExpiresAt.Time))
+
+	now = now.Add(time.Minute - time.Second)
+	_, err = m.Verify(signed, token.TypeAccess)
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+	_, err = m.Verify(signed, token.TypeAccess)
+	assert.ErrorIs(t, err, token.InvalidTokenError)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := token.KeyOptions{ID: "old", Algorithm: token.HS256, Secret: testSecret}
+	newKey := token.KeyOptions{ID: "new", Algorithm: token.HS256, Secret: strings.ToUpper(testSecret)}
+
+	before, err := token.NewManager(testOptions(oldKey), time.Now)
+	require.NoError(t, err)
+	signedBefore, _, err := before.Sign(token.TypeAccess, subjectClaims)
+	require.NoError(t, err)
+
+	during, err := token.NewManager(testOptions(newKey, oldKey), time.Now)
+	require.NoError(t, err)
+	signedDuring, _, err := during.Sign(token.TypeAccess, subjectClaims)
+	require.NoError(t, err)
+
+	_, err = during.Verify(signedBefore, token.TypeAccess)
+	assert.NoError(t, err)
+	_, err = during.Verify(signedDuring, token.TypeAccess)
+	assert.NoError(t, err)
+
+	after, err := token.NewManager(testOptions(newKey), time.Now)
+	require.NoError(t, err)
+	_, err = after.Verify(signedBefore, token.TypeAccess)
+	assert.ErrorIs(t, err, token.InvalidTokenError)
+	_, err = after.Verify(signedDuring, token.TypeAccess)
+	assert.NoError(t, err)
+}
+
+func TestVerifyRejectsInvalidClaims(t *testing.T) {
+	key := token.KeyOptions{ID: "hmac", Algorithm: token.HS256, Secret: testSecret}
+
+	expired := testOptions(key)
+	expired.AccessTTL = -time.Minute
+	otherAudience := testOptions(key)
+	otherAudience.Audience = "other-api"
+	otherIssuer := testOptions(key)
+	otherIssuer.Issuer = "other"
+
+	m, err := token.NewManager(testOptions(key), time.Now)
+	require.NoError(t, err)
+
+	for name, opts := range map[string]token.Options{
+		"expired":  expired,
+		"audience": otherAudience,
+		"issuer":   otherIssuer,
+	} {
+		t.Run(name, func(t *testing.T) {
+			signer, err := token.NewManager(opts, time.Now)
+			require.NoError(t, err)
+			signed, _, err := signer.Sign(token.TypeAccess, subjectClaims)
+			require.NoError(t, err)
+
+			_, err = m.Verify(signed, token.TypeAccess)
+			assert.ErrorIs(t, err, token.InvalidTokenError)
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+			"iss": "users", "aud": "users-api", "sub": "subject", "typ": token.TypeAccess,
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		unsigned.Header["kid"] = "hmac"
+		signed, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = m.Verify(signed, token.TypeAccess)
+		assert.ErrorIs(t, err, token.InvalidTokenError)
+	})
+}
+
+func TestNewManagerRejectsBadKeys(t *testing.T) {
+	_, public := writeEd25519Keys(t)
+
+	for name, opts := range map[string]token.Options{
+		"short secret":    testOptions(token.KeyOptions{ID: "hmac", Algorithm: token.HS256, Secret: "short"}),
+		"unknown alg":     testOptions(token.KeyOptions{ID: "rsa", Algorithm: "RS256"}),
+		"verify only":     testOptions(token.KeyOptions{ID: "ed", Algorithm: token.EdDSA, PublicKeyFile: public}),
+		"unknown signing": {SigningKey: "missing"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := token.NewManager(opts, time.Now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func writeEd25519Keys(t *testing.T) (privateFile, publicFile string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateFile = filepath.Join(dir, "private.pem")
+	publicFile = filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600))
+	require.NoError(t, os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600))
+
+	return privateFile, publicFile
+}
@@ -0,0 +1,137 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+	// SecretLength is the size of generated secrets, that of the HMAC-SHA1
+	// output as RFC 4226 recommends.
+	SecretLength = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Options configure codes. Digits and Period default to those authenticator
+// apps expect. Skew is the number of periods a code may be early or late to
+// make up for clocks that drift.
+type Options struct {
+	Digits int
+	Period time.Duration
+	Skew   int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Digits <= 0 {
+		o.Digits = DefaultDigits
+	}
+	if o.Period <= 0 {
+		o.Period = DefaultPeriod
+	}
+	if o.Skew < 0 {
+		o.Skew = 0
+	}
+
+	return o
+}
+
+// GenerateSecret returns a new random secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret in unpadded base32, as authenticator apps
+// take it when it is typed in.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// URI of a secret, which authenticator apps read
+// from a QR code.
+func URI(issuer, account string, secret []byte, opts Options) string {
+	opts = opts.withDefaults()
+
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(opts.Digits))
+	query.Set("period", fmt.Sprint(int64(opts.Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Step returns the number of periods since the Unix epoch at t, the counter
+// RFC 6238 derives codes from.
+func Step(t time.Time, period time.Duration) uint64 {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+
+	return uint64(t.Unix()) / uint64(period/time.Second)
+}
+
+// Code returns the HOTP code of RFC 4226 for a counter.
+func Code(secret []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// Validate checks code against the steps around t and returns the step it
+// matched. Steps up to and including after are refused, so a code that was
+// accepted once cannot be used again.
+func Validate(secret []byte, code string, t time.Time, after uint64, opts Options) (uint64, bool) {
+	opts = opts.withDefaults()
+
+	code = strings.TrimSpace(code)
+	if len(code) != opts.Digits {
+		return 0, false
+	}
+
+	current := Step(t, opts.Period)
+	first := current - uint64(opts.Skew)
+	if current < uint64(opts.Skew) {
+		first = 0
+	}
+	for step := first; step <= current+uint64(opts.Skew); step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step, opts.Digits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
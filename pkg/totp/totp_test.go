@@ -0,0 +1,73 @@
+package totp_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/omelaymy/users/pkg/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The SHA-1 test vectors of RFC 6238, appendix B.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		step := totp.Step(time.Unix(unix, 0), totp.DefaultPeriod)
+		assert.Equal(t, want, totp.Code(secret, step, 8), unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	opts := totp.Options{Skew: 1}
+
+	now := time.Unix(1700000000, 0)
+	current := totp.Step(now, totp.DefaultPeriod)
+	code := totp.Code(secret, current, totp.DefaultDigits)
+
+	step, ok := totp.Validate(secret, code, now, 0, opts)
+	assert.True(t, ok)
+	assert.Equal(t, current, step)
+
+	// A code stays valid for one period before and after its own.
+	_, ok = totp.Validate(secret, code, now.Add(totp.DefaultPeriod), 0, opts)
+	assert.True(t, ok)
+	_, ok = totp.Validate(secret, code, now.Add(-totp.DefaultPeriod), 0, opts)
+	assert.True(t, ok)
+	_, ok = totp.Validate(secret, code, now.Add(2*totp.DefaultPeriod), 0, opts)
+	assert.False(t, ok)
+
+	// Codes of accepted steps are refused.
+	_, ok = totp.Validate(secret, code, now, current, opts)
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(secret, "12345", now, 0, opts)
+	assert.False(t, ok)
+	_, ok = totp.Validate(secret, "", now, 0, opts)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	uri, err := url.Parse(totp.URI("Users", "alice@example.com", secret, totp.Options{}))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Users:alice@example.com", uri.Path)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uri.Query().Get("secret"))
+	assert.Equal(t, "Users", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}